@@ -10,8 +10,9 @@ import (
 	"github.com/urfave/cli"
 
 	"wizeBlock/wizeNode/core/blockchain"
-	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/chaincfg"
 	"wizeBlock/wizeNode/core/log"
+	"wizeBlock/wizeNode/core/mempool"
 	"wizeBlock/wizeNode/core/network"
 	"wizeBlock/wizeNode/core/wallet"
 	"wizeBlock/wizeNode/node"
@@ -39,10 +40,15 @@ var GlobalFlags = []cli.Flag{
 	},
 	cli.IntFlag{
 		Name:   "nodeID",
-		Value:  3000,
-		Usage:  "Node ID (port)",
+		Usage:  "Node ID (port), the default port of the network when it's not set",
 		EnvVar: "NODE_ID",
 	},
+	cli.StringFlag{
+		Name:   "network",
+		Value:  chaincfg.MainNet.Name,
+		Usage:  "Network: mainnet, testnet or regtest",
+		EnvVar: "NODE_NETWORK",
+	},
 }
 
 var Commands = []cli.Command{
@@ -75,14 +81,8 @@ var Commands = []cli.Command{
 	{
 		Name:    "createblockchain",
 		Aliases: []string{"cbc"},
-		Flags: []cli.Flag{
-			cli.StringFlag{
-				Name:  "address",
-				Usage: "Wallet address for miner rewards",
-			},
-		},
-		Usage:  "Create a blockchain and send genesis block reward to ADDRESS",
-		Action: CmdCreateBlockchain,
+		Usage:   "Create a blockchain with the genesis block of the network",
+		Action:  CmdCreateBlockchain,
 	},
 	{
 		Name:    "send",
@@ -100,14 +100,31 @@ var Commands = []cli.Command{
 				Name:  "amount",
 				Usage: "Amount of coins",
 			},
-			cli.BoolFlag{
-				Name:  "mine",
-				Usage: "Mine in the same node or only with miner nodes",
-			},
 		},
-		Usage:  "Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set",
+		Usage:  "Send AMOUNT of coins from FROM address to TO",
 		Action: CmdSend,
 	},
+	{
+		Name:  "generate",
+		Usage: "Mine BLOCKS blocks right away and send the rewards to ADDRESS, only in regtest",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "address",
+				Usage: "Wallet address for miner rewards",
+			},
+			cli.IntFlag{
+				Name:  "blocks",
+				Value: 1,
+				Usage: "Number of blocks",
+			},
+		},
+		Action: CmdGenerate,
+	},
+	{
+		Name:   "reindex",
+		Usage:  "Rebuild the transaction and height indexes and the UTXO set from the main chain blocks",
+		Action: CmdReindex,
+	},
 	// blockchain explorer commands
 	{
 		Name:    "printchain",
@@ -134,18 +151,21 @@ var Commands = []cli.Command{
 		Flags: []cli.Flag{
 			cli.StringFlag{
 				Name:  "miner",
-				Usage: "",
+				Usage: "Address to receive the mining rewards, the node mines the mempool transactions on all CPU cores",
 			},
 			cli.StringFlag{
 				Name:  "api",
-				Value: ":4000",
-				Usage: "",
+				Usage: "REST service address, the default API port of the network when it's not set",
 			},
 			cli.IntFlag{
 				Name:  "pause",
 				Value: 0,
 				Usage: "",
 			},
+			cli.BoolTFlag{
+				Name:  "persistmempool",
+				Usage: "Save the mempool between restarts, --persistmempool=false disables it",
+			},
 		},
 		Usage:  "Start a node with ID specified in NODE_ID env. var. -miner enables mining",
 		Action: CmdStartNode,
@@ -159,17 +179,45 @@ func CommandNotFound(c *cli.Context, command string) {
 }
 
 // CommandBefore implements action before run command
+// The network is checked before any files are opened
 func CommandBefore(c *cli.Context) error {
 	if c.GlobalBool("debug") {
 		log.Debug.Enabled = true
 	}
+
+	params, err := chaincfg.Get(c.GlobalString("network"))
+	if err != nil {
+		return err
+	}
+
+	if !c.GlobalIsSet("nodeID") {
+		return c.GlobalSet("nodeID", strconv.Itoa(params.DefaultPort))
+	}
 	return nil
 }
 
+// chainParams returns the network of the command, it's checked by CommandBefore
+func chainParams(c *cli.Context) *chaincfg.ChainParams {
+	params, _ := chaincfg.Get(c.GlobalString("network"))
+	return params
+}
+
+// openBlockchain opens the blockchain of the node in the network of the command
+func openBlockchain(c *cli.Context, nodeID string) *blockchain.Blockchain {
+	params := chainParams(c)
+	return blockchain.NewBlockchain(params.DataDir, nodeID, &params.Params)
+}
+
+// openWallets opens the wallets of the node in the network of the command
+func openWallets(c *cli.Context, nodeID string) (*wallet.Wallets, error) {
+	params := chainParams(c)
+	return wallet.NewWallets(params.DataDir, nodeID, params.AddressVersion)
+}
+
 // wallet commands
 func CmdCreateWallet(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
-	wallets, _ := wallet.NewWallets(nodeID)
+	wallets, _ := openWallets(c, nodeID)
 	address := wallets.CreateWallet()
 	wallets.SaveToFile(nodeID)
 	walletNew := wallets.GetWallet(address)
@@ -185,10 +233,10 @@ func CmdListAddresses(c *cli.Context) (err error) {
 	var addresses []string = []string{}
 	if nodeID == "3100" {
 		// FIXME: experiment with get addresses
-		bc := blockchain.NewBlockchain("3000")
+		bc := openBlockchain(c, "3000")
 		addresses = bc.GetAddresses()
 	} else {
-		wallets, err := wallet.NewWallets(nodeID)
+		wallets, err := openWallets(c, nodeID)
 		if err != nil {
 			return err
 		}
@@ -203,7 +251,7 @@ func CmdListAddresses(c *cli.Context) (err error) {
 func CmdGetBalance(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
 	address := c.String("address")
-	bc := blockchain.NewBlockchain(nodeID)
+	bc := openBlockchain(c, nodeID)
 	balance := bc.GetWalletBalance(address)
 	fmt.Printf("Balance of '%s': %d\n", address, balance)
 	return nil
@@ -212,14 +260,10 @@ func CmdGetBalance(c *cli.Context) (err error) {
 // blockchain commands
 func CmdCreateBlockchain(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
-	address := c.String("address")
-	if !crypto.ValidateAddress(address) {
-		fmt.Printf("ERROR: Address is not valid")
-		return fmt.Errorf("ERROR: Address is not valid")
-	}
-	bc := blockchain.CreateBlockchain(address, nodeID)
+	params := chainParams(c)
+	bc := blockchain.CreateBlockchain(params.DataDir, nodeID, &params.Params)
 	defer bc.Db.Close()
-	UTXOSet := blockchain.UTXOSet{bc}
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
 	UTXOSet.Reindex()
 
 	fmt.Println("Done!")
@@ -231,22 +275,21 @@ func CmdSend(c *cli.Context) (err error) {
 	from := c.String("from")
 	to := c.String("to")
 	amount := c.Int("amount")
-	mineNow := c.Bool("mine")
 
-	if !crypto.ValidateAddress(from) {
+	if !chainParams(c).ValidateAddress(from) {
 		log.Fatal.Println("ERROR: Sender address is not valid")
 		return
 	}
-	if !crypto.ValidateAddress(to) {
+	if !chainParams(c).ValidateAddress(to) {
 		log.Fatal.Println("ERROR: Recipient address is not valid")
 		return
 	}
 
-	bc := blockchain.NewBlockchain(nodeID)
-	UTXOSet := blockchain.UTXOSet{bc}
+	bc := openBlockchain(c, nodeID)
+	UTXOView := blockchain.NewUTXOView(bc, nil)
 	defer bc.Db.Close()
 
-	wallets, err := wallet.NewWallets(nodeID)
+	wallets, err := openWallets(c, nodeID)
 	if err != nil {
 		log.Fatal.Printf("Error: %s", err)
 		return
@@ -258,27 +301,76 @@ func CmdSend(c *cli.Context) (err error) {
 		return
 	}
 
-	tx := blockchain.NewUTXOTransaction(wallet, to, amount, &UTXOSet)
-	if mineNow {
-		cbTx := blockchain.NewCoinbaseTX(from, "")
-		txs := []*blockchain.Transaction{cbTx, tx}
+	tx := blockchain.NewUTXOTransaction(wallet, to, amount, mempool.DefaultMinRelayFeeRate, UTXOView)
+	// the transactions are mined by the running nodes, the stopped node doesn't mine them
+	// TODO: проверять остаток на балансе с учетом незамайненых транзакций,
+	// во избежание двойного использования выходов
+	//SendTx(KnownNodes[0], nodeID, tx)
+	fmt.Printf("Transaction %x\n", tx.ID)
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
-	} else {
-		// TODO: проверять остаток на балансе с учетом незамайненых транзакций,
-		// во избежание двойного использования выходов
-		//SendTx(KnownNodes[0], nodeID, tx)
+	fmt.Println("Success!")
+	return nil
+}
+
+// CmdGenerate mines blocks of the stopped node, the running node generates them by the REST request
+func CmdGenerate(c *cli.Context) (err error) {
+	nodeID := c.GlobalString("nodeID")
+	address := c.String("address")
+	blocks := c.Int("blocks")
+
+	params := chainParams(c)
+	if !params.GenerateOnDemand {
+		fmt.Printf("ERROR: Blocks are not generated by request in %s\n", params.Name)
+		return fmt.Errorf("ERROR: Blocks are not generated by request in %s", params.Name)
+	}
+	if !params.ValidateAddress(address) {
+		fmt.Println("ERROR: Address is not valid")
+		return fmt.Errorf("ERROR: Address is not valid")
 	}
 
-	fmt.Println("Success!")
+	bc := openBlockchain(c, nodeID)
+	defer bc.Db.Close()
+
+	for i := 0; i < blocks; i++ {
+		block := bc.MineBlock(address, nil)
+		if block == nil {
+			return fmt.Errorf("ERROR: Block is not mined")
+		}
+		fmt.Printf("Block %x of height %d\n", block.Hash, block.Height)
+	}
+
+	fmt.Println("Done!")
+	return nil
+}
+
+func CmdReindex(c *cli.Context) (err error) {
+	nodeID := c.GlobalString("nodeID")
+	bc := openBlockchain(c, nodeID)
+	defer bc.Db.Close()
+
+	err = bc.ReindexTransactions()
+	if err != nil {
+		fmt.Printf("ERROR: Transaction index: %s\n", err)
+		return err
+	}
+
+	err = bc.ReindexHeights()
+	if err != nil {
+		fmt.Printf("ERROR: Height index: %s\n", err)
+		return err
+	}
+
+	UTXOSet := blockchain.UTXOSet{Blockchain: bc}
+	UTXOSet.Reindex()
+
+	fmt.Printf("Done! %d transactions in the UTXO set\n", UTXOSet.CountTransactions())
 	return nil
 }
 
 // blockchain explorer commands
 func CmdPrintChain(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
-	bc := blockchain.NewBlockchain(nodeID)
+	bc := openBlockchain(c, nodeID)
 	defer bc.Db.Close()
 
 	bci := bc.Iterator()
@@ -290,7 +382,7 @@ func CmdPrintChain(c *cli.Context) (err error) {
 		fmt.Printf("Height: %d\n", block.Height)
 		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
 		fmt.Printf("Created at: %s\n", time.Unix(block.Timestamp, 0))
-		pow := blockchain.NewProofOfWork(block)
+		pow := blockchain.NewProofOfWork(&block.BlockHeader)
 		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
 		for _, tx := range block.Transactions {
 			fmt.Println(tx)
@@ -306,7 +398,7 @@ func CmdPrintChain(c *cli.Context) (err error) {
 
 func CmdGetBlock(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
-	bc := blockchain.NewBlockchain(nodeID)
+	bc := openBlockchain(c, nodeID)
 	defer bc.Db.Close()
 
 	blockHash := c.String("hash")
@@ -321,7 +413,7 @@ func CmdGetBlock(c *cli.Context) (err error) {
 			fmt.Printf("Height: %d\n", block.Height)
 			fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
 			fmt.Printf("Created at : %s\n", time.Unix(block.Timestamp, 0))
-			pow := blockchain.NewProofOfWork(block)
+			pow := blockchain.NewProofOfWork(&block.BlockHeader)
 			fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
 			for _, tx := range block.Transactions {
 				fmt.Println(tx)
@@ -349,7 +441,8 @@ func CmdStartNode(c *cli.Context) (err error) {
 		Host: c.GlobalString("nodeADD"),
 		Port: nodeID,
 	}
-	log.Info.Printf("Starting Node %s", nodeAddr)
+	params := chainParams(c)
+	log.Info.Printf("Starting Node %s in %s", nodeAddr, params.Name)
 
 	// PROD: add request to masternode and get nodeID
 	//nodeAddress := os.Getenv("NODE_ADD") + ":" + nodeIDStr
@@ -359,6 +452,9 @@ func CmdStartNode(c *cli.Context) (err error) {
 
 	// FIXME: it is just apiPort
 	apiAddr := c.String("api")
+	if apiAddr == "" {
+		apiAddr = fmt.Sprintf(":%d", params.DefaultAPIPort)
+	}
 
 	// FIXME: minerWalletAddress to Node, not to NodeServer
 	minerWalletAddress := c.String("miner")
@@ -367,7 +463,7 @@ func CmdStartNode(c *cli.Context) (err error) {
 	registerDigest()
 
 	if len(minerWalletAddress) > 0 {
-		if crypto.ValidateAddress(minerWalletAddress) {
+		if params.ValidateAddress(minerWalletAddress) {
 			log.Info.Println("Mining is on. Address to receive rewards: ", minerWalletAddress)
 		} else {
 			log.Warn.Println("Wrong miner address!")
@@ -375,7 +471,7 @@ func CmdStartNode(c *cli.Context) (err error) {
 		}
 	}
 
-	newNode := node.NewNode(nodeIDStr, nodeAddr, apiAddr, minerWalletAddress)
+	newNode := node.NewNode(params, nodeIDStr, nodeAddr, apiAddr, minerWalletAddress, c.BoolT("persistmempool"))
 	newNode.Run()
 	return nil
 }
@@ -1,28 +1,26 @@
 package blockchain
 
 import (
-	"bytes"
-	"encoding/gob"
 	"fmt"
 	"time"
 
 	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/wire"
 )
 
 // Block represents a block in the blockchain
 type Block struct {
-	Timestamp     int64
-	Transactions  []*Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Nonce         int
-	Height        int
+	BlockHeader
+	Hash         []byte
+	Transactions []*Transaction
 }
 
 // NewBlock creates and returns Block
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
-	block := &Block{time.Now().Unix(), transactions, prevBlockHash, []byte{}, 0, height}
-	pow := NewProofOfWork(block)
+// bits is the compact target the block hash has to satisfy
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
+	block := newUnsolvedBlock(transactions, prevBlockHash, height, bits)
+
+	pow := NewProofOfWork(&block.BlockHeader)
 	nonce, hash := pow.Run()
 
 	block.Hash = hash[:]
@@ -31,9 +29,27 @@ func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Bl
 	return block
 }
 
+// newUnsolvedBlock creates a Block without the proof-of-work
+func newUnsolvedBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block {
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: prevBlockHash,
+			Timestamp:     time.Now().Unix(),
+			Bits:          bits,
+			Height:        height,
+		},
+		Transactions: transactions,
+	}
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
+
 // NewGenesisBlock creates and returns genesis Block
-func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{}, 0)
+// bits is the compact target of the genesis block defined by the network
+func NewGenesisBlock(coinbase *Transaction, bits uint32) *Block {
+	return NewBlock([]*Transaction{coinbase}, []byte{}, 0, bits)
 }
 
 // HashTransactions returns a hash of the transactions in the block
@@ -50,26 +66,49 @@ func (b *Block) HashTransactions() []byte {
 
 // Serialize serializes the block
 func (b *Block) Serialize() []byte {
-	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
+	w := wire.NewWriter()
+
+	b.BlockHeader.encode(w)
+	w.WriteBytes(b.Hash)
+
+	w.WriteCount(len(b.Transactions))
+	for _, tx := range b.Transactions {
+		tx.encode(w)
+	}
+
+	return w.Bytes()
+}
+
+func (b *Block) decode(r *wire.Reader) {
+	b.BlockHeader.decode(r)
+	b.Hash = r.ReadBytes()
+
+	b.Transactions = make([]*Transaction, r.ReadCount())
+	for i := range b.Transactions {
+		b.Transactions[i] = &Transaction{}
+		b.Transactions[i].decode(r)
+	}
+}
 
-	err := encoder.Encode(b)
+// DecodeBlock deserializes a block and reports malformed data
+func DecodeBlock(d []byte) (*Block, error) {
+	var block Block
+
+	err := decode(d, block.decode)
 	if err != nil {
-		fmt.Println(err)
+		return nil, err
 	}
 
-	return result.Bytes()
+	return &block, nil
 }
 
 // DeserializeBlock deserializes a block
 func DeserializeBlock(d []byte) *Block {
-	var block Block
-
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+	block, err := DecodeBlock(d)
 	if err != nil {
 		fmt.Println(err)
+		return &Block{}
 	}
 
-	return &block
+	return block
 }
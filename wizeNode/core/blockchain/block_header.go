@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+
+	"wizeBlock/wizeNode/core/wire"
+)
+
+// blockVersion is the version of the blocks created by this node
+const blockVersion = 1
+
+// BlockHeader represents the part of a block covered by its hash
+// The transactions are committed by the merkle root, so headers can be
+// synced and validated without the block bodies
+type BlockHeader struct {
+	Version       int
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          uint32
+	Nonce         int
+	Height        int
+}
+
+// Hash returns the hash of the header
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
+}
+
+// Serialize serializes the header
+func (h *BlockHeader) Serialize() []byte {
+	w := wire.NewWriter()
+	h.encode(w)
+
+	return w.Bytes()
+}
+
+func (h *BlockHeader) encode(w *wire.Writer) {
+	w.WriteInt(int64(h.Version))
+	w.WriteBytes(h.PrevBlockHash)
+	w.WriteBytes(h.MerkleRoot)
+	w.WriteInt(h.Timestamp)
+	w.WriteInt(int64(h.Bits))
+	w.WriteInt(int64(h.Nonce))
+	w.WriteInt(int64(h.Height))
+}
+
+func (h *BlockHeader) decode(r *wire.Reader) {
+	h.Version = int(r.ReadInt())
+	h.PrevBlockHash = r.ReadBytes()
+	h.MerkleRoot = r.ReadBytes()
+	h.Timestamp = r.ReadInt()
+	h.Bits = uint32(r.ReadInt())
+	h.Nonce = int(r.ReadInt())
+	h.Height = int(r.ReadInt())
+}
+
+// DeserializeBlockHeader deserializes a header
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	var header BlockHeader
+
+	r, err := wire.NewReader(data)
+	if err != nil {
+		return nil, err
+	}
+	header.decode(r)
+
+	return &header, r.Close()
+}
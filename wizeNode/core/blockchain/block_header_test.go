@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBlockHeaderSerialize(t *testing.T) {
+	block := NewGenesisBlock(NewCoinbaseTX("1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39", "test", 10), 0x207fffff)
+
+	header, err := DeserializeBlockHeader(block.BlockHeader.Serialize())
+	if err != nil {
+		t.Fatalf("DeserializeBlockHeader() error = %v", err)
+	}
+	if !bytes.Equal(header.Hash(), block.Hash) {
+		t.Errorf("DeserializeBlockHeader().Hash() = %x, want %x", header.Hash(), block.Hash)
+	}
+
+	if _, err := DeserializeBlockHeader(block.BlockHeader.Serialize()[:20]); err == nil {
+		t.Error("DeserializeBlockHeader() of truncated data is successful")
+	}
+}
+
+func TestBlockHeaderHash(t *testing.T) {
+	block := NewGenesisBlock(NewCoinbaseTX("1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39", "test", 10), 0x207fffff)
+	hash := block.BlockHeader.Hash()
+
+	// the transactions are committed to by the merkle root only
+	withTx := *block
+	withTx.Transactions = append(withTx.Transactions, NewCoinbaseTX("1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39", "other", 10))
+	if !bytes.Equal(withTx.BlockHeader.Hash(), hash) {
+		t.Error("BlockHeader.Hash() depends on the transactions")
+	}
+	if bytes.Equal(withTx.HashTransactions(), block.MerkleRoot) {
+		t.Error("HashTransactions() doesn't depend on the transactions")
+	}
+
+	for name, change := range map[string]func(h *BlockHeader){
+		"version":     func(h *BlockHeader) { h.Version++ },
+		"prev block":  func(h *BlockHeader) { h.PrevBlockHash = []byte{1} },
+		"merkle root": func(h *BlockHeader) { h.MerkleRoot = []byte{1} },
+		"timestamp":   func(h *BlockHeader) { h.Timestamp++ },
+		"bits":        func(h *BlockHeader) { h.Bits++ },
+		"nonce":       func(h *BlockHeader) { h.Nonce++ },
+		"height":      func(h *BlockHeader) { h.Height++ },
+	} {
+		header := block.BlockHeader
+		change(&header)
+		if bytes.Equal(header.Hash(), hash) {
+			t.Errorf("BlockHeader.Hash() doesn't depend on the %s", name)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestDecodeBlock(t *testing.T) {
+	block := NewGenesisBlock(NewCoinbaseTX("1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39", "test", 10), 0x207fffff)
+
+	decoded, err := DecodeBlock(block.Serialize())
+	if err != nil {
+		t.Fatalf("DecodeBlock() error = %v", err)
+	}
+	if !bytes.Equal(decoded.Hash, block.Hash) {
+		t.Errorf("DecodeBlock().Hash = %x, want %x", decoded.Hash, block.Hash)
+	}
+
+	// the blocks stored with gob by the old versions are not read
+	var legacy bytes.Buffer
+	if err := gob.NewEncoder(&legacy).Encode(block); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{nil, []byte("not a block"), block.Serialize()[:40], legacy.Bytes()} {
+		if _, err := DecodeBlock(data); err == nil {
+			t.Errorf("DecodeBlock(%x) error = nil, want an error", data)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"wizeBlock/wizeNode/core/wire"
+)
+
+const undoBucket = "undo"
+
+// SpentOutput is an output spent by a block transaction
+type SpentOutput struct {
+	Txid   []byte
+	Index  int
+	Output TXOutput
+}
+
+// BlockUndo keeps the outputs spent by a block, so the block can be disconnected
+type BlockUndo struct {
+	SpentOutputs []SpentOutput
+}
+
+// Serialize serializes BlockUndo
+func (u BlockUndo) Serialize() []byte {
+	w := wire.NewWriter()
+
+	w.WriteCount(len(u.SpentOutputs))
+	for _, spent := range u.SpentOutputs {
+		w.WriteBytes(spent.Txid)
+		w.WriteInt(int64(spent.Index))
+		spent.Output.encode(w)
+	}
+
+	return w.Bytes()
+}
+
+func (u *BlockUndo) decode(r *wire.Reader) {
+	u.SpentOutputs = make([]SpentOutput, r.ReadCount())
+
+	for i := range u.SpentOutputs {
+		u.SpentOutputs[i].Txid = r.ReadBytes()
+		u.SpentOutputs[i].Index = int(r.ReadInt())
+		u.SpentOutputs[i].Output.decode(r)
+	}
+}
+
+// DeserializeBlockUndo deserializes BlockUndo
+func DeserializeBlockUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+
+	err := decode(data, undo.decode)
+
+	return undo, err
+}
@@ -0,0 +1,320 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// maxFutureBlockTime is how far ahead of the local clock a block timestamp may be
+	maxFutureBlockTime = 2 * 60 * 60
+	// medianTimeBlocks is the number of previous blocks used for the median time check
+	medianTimeBlocks = 11
+)
+
+// BlockErrorCode identifies the rule a block has broken
+type BlockErrorCode int
+
+const (
+	ErrBlockBadHash BlockErrorCode = iota
+	ErrBlockBadVersion
+	ErrBlockBadProofOfWork
+	ErrBlockBadMerkleRoot
+	ErrBlockOrphan
+	ErrBlockBadHeight
+	ErrBlockBadDifficulty
+	ErrBlockBadTimestamp
+	ErrBlockBadCoinbase
+	ErrBlockDuplicateTx
+	ErrBlockBadTransaction
+	ErrBlockDoubleSpend
+	// ErrBlockTimeTooNew is a timestamp ahead of the local clock, the block can be valid later
+	ErrBlockTimeTooNew
+)
+
+var blockErrorCodeStrings = map[BlockErrorCode]string{
+	ErrBlockBadHash:        "bad hash",
+	ErrBlockBadVersion:     "bad version",
+	ErrBlockBadProofOfWork: "bad proof-of-work",
+	ErrBlockBadMerkleRoot:  "bad merkle root",
+	ErrBlockOrphan:         "orphan",
+	ErrBlockBadHeight:      "bad height",
+	ErrBlockBadDifficulty:  "bad difficulty",
+	ErrBlockBadTimestamp:   "bad timestamp",
+	ErrBlockBadCoinbase:    "bad coinbase",
+	ErrBlockDuplicateTx:    "duplicate transaction",
+	ErrBlockBadTransaction: "bad transaction",
+	ErrBlockDoubleSpend:    "double spend",
+	ErrBlockTimeTooNew:     "time too new",
+}
+
+func (c BlockErrorCode) String() string {
+	if s, ok := blockErrorCodeStrings[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown (%d)", int(c))
+}
+
+// BlockError is returned when a block does not pass validation
+type BlockError struct {
+	Code        BlockErrorCode
+	BlockHash   []byte
+	Description string
+	// TxID is the transaction breaking the rule, it's nil for the rules of the whole block
+	TxID []byte
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("Block %x rejected (%s): %s", e.BlockHash, e.Code, e.Description)
+}
+
+func blockError(blockHash []byte, code BlockErrorCode, format string, args ...interface{}) *BlockError {
+	return &BlockError{
+		Code:        code,
+		BlockHash:   blockHash,
+		Description: fmt.Sprintf(format, args...),
+	}
+}
+
+// ValidateBlock checks that a block can be attached to the blockchain
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	err := bc.ValidateHeader(&block.BlockHeader, block.Hash)
+	if err != nil {
+		return err
+	}
+
+	err = checkBlockSanity(block)
+	if err != nil {
+		return err
+	}
+
+	// spent outputs are only known for the tip of the main chain
+	if bytes.Compare(block.PrevBlockHash, bc.tip) == 0 {
+		_, err = bc.checkTransactions(block)
+		return err
+	}
+
+	return nil
+}
+
+// ValidateHeader checks a block header without the block transactions
+// The parent header has to be known already
+func (bc *Blockchain) ValidateHeader(header *BlockHeader, hash []byte) error {
+	err := checkHeaderSanity(header, hash)
+	if err != nil {
+		return err
+	}
+
+	if CompactToBig(headerBits(header)).Cmp(bc.params.PowLimit) > 0 {
+		return blockError(hash, ErrBlockBadDifficulty, "target of bits %08x is above the limit", headerBits(header))
+	}
+
+	if len(header.PrevBlockHash) == 0 {
+		return blockError(hash, ErrBlockOrphan, "foreign genesis block")
+	}
+
+	prevBlock, err := bc.GetHeader(header.PrevBlockHash)
+	if err != nil {
+		return blockError(hash, ErrBlockOrphan, "previous block %x is not found", header.PrevBlockHash)
+	}
+
+	if header.Height != prevBlock.Height+1 {
+		return blockError(hash, ErrBlockBadHeight, "height %d does not follow %d", header.Height, prevBlock.Height)
+	}
+
+	bits, err := bc.nextBits(prevBlock)
+	if err != nil {
+		return err
+	}
+	if header.Bits != bits {
+		return blockError(hash, ErrBlockBadDifficulty, "bits %08x, expected %08x", header.Bits, bits)
+	}
+
+	medianTime := bc.medianTimePast(prevBlock)
+	if header.Timestamp < medianTime {
+		return blockError(hash, ErrBlockBadTimestamp, "timestamp %d is before median time %d", header.Timestamp, medianTime)
+	}
+
+	return nil
+}
+
+// checkHeaderSanity performs the header checks which don't depend on the chain state
+func checkHeaderSanity(header *BlockHeader, hash []byte) error {
+	if header.Version < blockVersion {
+		return blockError(hash, ErrBlockBadVersion, "version %d is not supported", header.Version)
+	}
+
+	pow := NewProofOfWork(header)
+	if !pow.Validate() {
+		return blockError(hash, ErrBlockBadProofOfWork, "hash does not satisfy the target")
+	}
+	if bytes.Compare(pow.Hash(), hash) != 0 {
+		return blockError(hash, ErrBlockBadHash, "hash does not match the header")
+	}
+
+	maxTimestamp := time.Now().Unix() + maxFutureBlockTime
+	if header.Timestamp > maxTimestamp {
+		return blockError(hash, ErrBlockTimeTooNew, "timestamp %d is too far in the future", header.Timestamp)
+	}
+
+	return nil
+}
+
+// checkBlockSanity checks the block transactions against the header
+// and performs the transaction checks which don't depend on the chain state
+func checkBlockSanity(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return blockError(block.Hash, ErrBlockBadCoinbase, "block has no transactions")
+	}
+
+	if bytes.Compare(block.HashTransactions(), block.MerkleRoot) != 0 {
+		return blockError(block.Hash, ErrBlockBadMerkleRoot, "merkle root does not match the transactions")
+	}
+
+	coinbases := 0
+	txIDs := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			coinbases++
+		}
+
+		txID := hex.EncodeToString(tx.ID)
+		if !tx.VerifyID() {
+			return blockError(block.Hash, ErrBlockBadTransaction, "transaction %s has wrong ID", txID)
+		}
+		if txIDs[txID] {
+			return blockError(block.Hash, ErrBlockDuplicateTx, "transaction %s is included twice", txID)
+		}
+		txIDs[txID] = true
+	}
+	if coinbases != 1 {
+		return blockError(block.Hash, ErrBlockBadCoinbase, "block has %d coinbase transactions", coinbases)
+	}
+
+	return nil
+}
+
+// checkTransactions verifies block transactions against the UTXO set
+// The block is considered to be the next block after the tip
+// The coinbase can't pay more than the subsidy and the fees of the block transactions,
+// the coinbase outputs can be spent only after the maturity
+// The fees of the block transactions are returned
+func (bc *Blockchain) checkTransactions(block *Block) (int, error) {
+	UTXOSet := UTXOSet{bc}
+
+	blockTXs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	fees := 0
+	coinbaseValue := 0
+	immature := bc.immatureCoinbases(block.Height)
+
+	for _, tx := range block.Transactions {
+		txID := hex.EncodeToString(tx.ID)
+		txError := func(code BlockErrorCode, format string, args ...interface{}) *BlockError {
+			err := blockError(block.Hash, code, format, args...)
+			err.TxID = tx.ID
+			return err
+		}
+
+		if len(tx.Vout) == 0 {
+			return 0, txError(ErrBlockBadTransaction, "transaction %s has no outputs", txID)
+		}
+		outputValue := 0
+		for _, out := range tx.Vout {
+			if out.Value < 0 {
+				return 0, txError(ErrBlockBadTransaction, "transaction %s has negative output", txID)
+			}
+			outputValue += out.Value
+		}
+
+		if tx.IsCoinbase() {
+			coinbaseValue = outputValue
+			blockTXs[txID] = *tx
+			continue
+		}
+
+		inputValue := 0
+		prevTXs := make(map[string]Transaction)
+		for _, vin := range tx.Vin {
+			prevTxID := hex.EncodeToString(vin.Txid)
+			outpoint := fmt.Sprintf("%s:%d", prevTxID, vin.Vout)
+
+			if spent[outpoint] {
+				return 0, txError(ErrBlockDoubleSpend, "transaction %s spends %s twice", txID, outpoint)
+			}
+			spent[outpoint] = true
+
+			if immature[prevTxID] {
+				return 0, txError(ErrBlockBadTransaction, "transaction %s spends immature coinbase %s", txID, prevTxID)
+			}
+
+			if prevTx, ok := blockTXs[prevTxID]; ok {
+				if prevTx.IsCoinbase() && bc.params.CoinbaseMaturity > 0 {
+					return 0, txError(ErrBlockBadTransaction, "transaction %s spends immature coinbase %s", txID, prevTxID)
+				}
+				if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+					return 0, txError(ErrBlockBadTransaction, "transaction %s spends missing output %s", txID, outpoint)
+				}
+				inputValue += prevTx.Vout[vin.Vout].Value
+				prevTXs[prevTxID] = prevTx
+				continue
+			}
+
+			out, ok := UTXOSet.FindOutput(vin.Txid, vin.Vout)
+			if !ok {
+				return 0, txError(ErrBlockDoubleSpend, "transaction %s spends missing or spent output %s", txID, outpoint)
+			}
+			inputValue += out.Value
+
+			if _, ok := prevTXs[prevTxID]; !ok {
+				// the output is unspent, so the transaction is missing in the local index, not in the block
+				prevTx, err := bc.FindTransaction(vin.Txid)
+				if err != nil {
+					return 0, fmt.Errorf("Transaction %s spends %s: %s", txID, outpoint, err)
+				}
+				prevTXs[prevTxID] = prevTx
+			}
+		}
+
+		if inputValue < outputValue {
+			return 0, txError(ErrBlockBadTransaction, "transaction %s spends %d but has only %d", txID, outputValue, inputValue)
+		}
+
+		check, err := tx.Verify(prevTXs)
+		if err != nil || !check {
+			return 0, txError(ErrBlockBadTransaction, "transaction %s has invalid signature: %v", txID, err)
+		}
+
+		fees += inputValue - outputValue
+		blockTXs[txID] = *tx
+	}
+
+	reward := bc.params.BlockSubsidy(block.Height) + fees
+	if coinbaseValue > reward {
+		return 0, blockError(block.Hash, ErrBlockBadCoinbase, "coinbase pays %d but the subsidy and fees are %d", coinbaseValue, reward)
+	}
+
+	return fees, nil
+}
+
+// medianTimePast returns the median timestamp of the last blocks ending with the given one
+func (bc *Blockchain) medianTimePast(block *BlockHeader) int64 {
+	timestamps := []int64{block.Timestamp}
+
+	current := block
+	for len(timestamps) < medianTimeBlocks && len(current.PrevBlockHash) > 0 {
+		prevBlock, err := bc.GetHeader(current.PrevBlockHash)
+		if err != nil {
+			break
+		}
+		timestamps = append(timestamps, prevBlock.Timestamp)
+		current = prevBlock
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	return timestamps[len(timestamps)/2]
+}
@@ -2,26 +2,39 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/boltdb/bolt"
 
 	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/wire"
 )
 
-const dbFile = "files/db%s/wizebit.db"
+const dbFile = "db%s/wizebit.db"
 const blocksBucket = "blocks"
-const genesisCoinbaseData = "The Times 22/Jan/2017 Now I have nice gopher"
-const emissionValue = 1000000
 
 // Blockchain implements interactions with a DB
 type Blockchain struct {
-	tip []byte
-	Db  *bolt.DB
+	tip    []byte
+	Db     *bolt.DB
+	params *Params
+
+	// mu serializes changes of the main chain
+	mu       sync.Mutex
+	listener ChainListener
+
+	// orphans are blocks waiting for the parent body, by the parent hash
+	orphans map[string][]*Block
+
+	coinbases coinbaseCache
 }
 
 // Iterator returns a BlockchainIterat
@@ -31,9 +44,10 @@ func (bc *Blockchain) Iterator() *BlockchainIterator {
 	return bci
 }
 
-// CreateBlockchain creates a new blockchain DB
-func CreateBlockchain(address, nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+// CreateBlockchain creates a new blockchain DB of the node in the data directory of the network
+// The chain starts with the genesis block of the network
+func CreateBlockchain(dataDir, nodeID string, params *Params) *Blockchain {
+	dbFile := dataDir + fmt.Sprintf(dbFile, nodeID)
 	ok, err := DbExists(dbFile)
 	if ok {
 		fmt.Println("Blockchain already exists.")
@@ -42,8 +56,13 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 
 	var tip []byte
 
-	cbtx := NewEmissionCoinbaseTX(address, genesisCoinbaseData, emissionValue)
-	genesis := NewGenesisBlock(cbtx)
+	genesis := params.Genesis.Block()
+
+	// the directories of a new network don't exist yet
+	err = os.MkdirAll(filepath.Dir(dbFile), 0755)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	db, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
@@ -67,20 +86,36 @@ func CreateBlockchain(address, nodeID string) *Blockchain {
 		}
 		tip = genesis.Hash
 
+		err = putHeader(tx, genesis.Hash, &genesis.BlockHeader, tip)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = indexTransactions(tx, genesis)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		err = indexHeight(tx, genesis)
+		if err != nil {
+			log.Panic(err)
+		}
+
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{tip: tip, Db: db, params: params, orphans: make(map[string][]*Block)}
 
 	return &bc
 }
 
-// NewBlockchain creates a new Blockchain with genesis Block
-func NewBlockchain(nodeID string) *Blockchain {
-	dbFile := fmt.Sprintf(dbFile, nodeID)
+// NewBlockchain opens the blockchain DB of the node in the data directory of the network
+// The blockchains with another genesis block are not opened
+func NewBlockchain(dataDir, nodeID string, params *Params) *Blockchain {
+	dbFile := dataDir + fmt.Sprintf(dbFile, nodeID)
 	//fmt.Printf("dbFile: %s\n", dbFile)
 	ok, err := DbExists(dbFile)
 	if !ok {
@@ -89,6 +124,7 @@ func NewBlockchain(nodeID string) *Blockchain {
 	}
 
 	var tip []byte
+	var legacy bool
 	db, err := bolt.Open(dbFile, 0600, nil)
 	if err != nil {
 		log.Panic(err)
@@ -96,7 +132,9 @@ func NewBlockchain(nodeID string) *Blockchain {
 
 	err = db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		// bolt values are valid only inside the transaction
+		tip = append([]byte{}, b.Get([]byte("l"))...)
+		legacy = !wire.IsEncoded(b.Get(tip))
 
 		return nil
 	})
@@ -104,67 +142,159 @@ func NewBlockchain(nodeID string) *Blockchain {
 		log.Panic(err)
 	}
 
-	bc := Blockchain{tip, db}
+	// the blocks stored with gob are hashed the old way and don't pass the validation,
+	// so they can't be migrated, the blockchain is synced again
+	if legacy {
+		db.Close()
+		fmt.Printf("The blockchain %s is stored by an old version of the node, its blocks can't be upgraded.\n", dbFile)
+		fmt.Printf("To sync it again: stop the node, remove the directory %s (the wallets are kept in another one),\n", filepath.Dir(dbFile))
+		fmt.Println("run the createblockchain command with the same --network and --nodeID and start the node, the blocks are downloaded from the peers.")
+		os.Exit(1)
+	}
+
+	bc := Blockchain{tip: tip, Db: db, params: params, orphans: make(map[string][]*Block)}
 	//fmt.Println("B db:", db, "bc:", bc)
 
+	// the blockchains created before the indexes get them once
+	if !bc.hasTxIndex() {
+		fmt.Println("Building the transaction index...")
+		err = bc.ReindexTransactions()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	if !bc.hasHeightIndex() {
+		fmt.Println("Building the height index...")
+		err = bc.ReindexHeights()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+
+	genesis, err := bc.GetBlockHashByHeight(0)
+	if err != nil || !bytes.Equal(genesis, params.GenesisHash()) {
+		fmt.Printf("The blockchain %s has the genesis block %x, but the genesis block of the network is %s.\n", dbFile, genesis, params.Genesis.Hash)
+		fmt.Println("It's a blockchain of another network or of an old version, remove it and create the blockchain again.")
+		os.Exit(1)
+	}
+
 	return &bc
 }
 
-// AddBlock saves the block into the blockchain
-func (bc *Blockchain) AddBlock(block *Block) {
-	err := bc.Db.Update(func(tx *bolt.Tx) error {
+// AddBlock validates the block and saves it into the blockchain
+// The main chain is switched to the block if its branch has the most work
+// A block whose parent body is not stored yet waits until the parent is added
+// A *BlockError is returned when the block breaks consensus rules
+func (bc *Blockchain) AddBlock(block *Block) error {
+	bc.mu.Lock()
+	events, err := bc.addBlock(block)
+	if err == nil {
+		events = append(events, bc.connectOrphans(block.Hash)...)
+	}
+	bc.mu.Unlock()
+
+	bc.notify(events)
+
+	return err
+}
+
+func (bc *Blockchain) addBlock(block *Block) ([]chainEvent, error) {
+	if bc.HasBlock(block.Hash) {
+		return nil, nil
+	}
+
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// only the parent header is known, the block waits for the parent body
+	if !bc.HasBlock(block.PrevBlockHash) {
+		bc.addOrphan(block)
+		return nil, nil
+	}
+
+	var blockWork, tipWork *big.Int
+	err = bc.Db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
+		err := b.Put(block.Hash, block.Serialize())
+		if err != nil {
+			return err
+		}
 
-		if blockInDb != nil {
-			return nil
+		err = putHeader(tx, block.Hash, &block.BlockHeader, bc.tip)
+		if err != nil {
+			return err
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		blockWork, err = chainWork(tx, block.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
+		tipWork, err = chainWork(tx, bc.tip)
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			bc.tip = block.Hash
-		}
+	// the first seen branch stays the main one when the work is equal
+	if blockWork.Cmp(tipWork) <= 0 {
+		return nil, nil
+	}
+
+	if bytes.Compare(block.PrevBlockHash, bc.tip) != 0 {
+		return bc.reorganize(block)
+	}
+
+	err = bc.connectBlock(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return []chainEvent{{true, block}}, nil
+}
+
+// HasBlock checks whether the block is already stored
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	exists := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		exists = b.Get(blockHash) != nil
 
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
+
+	return exists
 }
 
-// FindTransaction finds a transaction by its ID
+// FindTransaction finds a main chain transaction by its ID with the transaction index
 func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
-	bci := bc.Iterator()
-
-	for {
-		block := bci.Next()
+	location, err := bc.findTxLocation(ID)
+	if err != nil {
+		return Transaction{}, err
+	}
 
-		for _, tx := range block.Transactions {
-			//fmt.Println("ID: ", ID, " tx.ID: ", tx.ID) //TODO: remove
-			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
-			}
-		}
+	block, err := bc.GetBlock(location.BlockHash)
+	if err != nil {
+		return Transaction{}, err
+	}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	if location.Position >= len(block.Transactions) || bytes.Compare(block.Transactions[location.Position].ID, ID) != 0 {
+		return Transaction{}, fmt.Errorf("Transaction index is broken at %x, it has to be rebuilt", ID)
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return *block.Transactions[location.Position], nil
+}
+
+// FindOutput returns an unspent output of the main chain
+func (bc *Blockchain) FindOutput(txID []byte, vout int) (TXOutput, bool) {
+	return UTXOSet{bc}.FindOutput(txID, vout)
 }
 
 // FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
@@ -192,6 +322,7 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 
 				outs := UTXO[txID]
 				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
 				UTXO[txID] = outs
 			}
 
@@ -213,18 +344,41 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 
 // GetBestHeight returns the height of the latest block
 func (bc *Blockchain) GetBestHeight() int {
-	var lastBlock Block
+	height := 0
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		// the last key of the height index is the height of the tip
+		if b := tx.Bucket([]byte(heightIndexBucket)); b != nil {
+			if k, _ := b.Cursor().Last(); k != nil {
+				height = int(binary.BigEndian.Uint64(k))
+				return nil
+			}
+		}
+
+		header, err := getHeader(tx, bc.tip)
+		if err != nil {
+			return err
+		}
+		height = header.Height
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return height
+}
+
+// GetTip returns the hash of the latest block
+func (bc *Blockchain) GetTip() []byte {
+	var lastHash []byte
 	err := bc.Db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	return lastBlock.Height
+	return lastHash
 }
 
 // GetBlock finds a block by its hash and returns it
@@ -259,70 +413,63 @@ func (bc *Blockchain) GetBlockHashes() [][]byte {
 	return blocks
 }
 
-// MineBlock mines a new block with the provided transactions
-func (bc *Blockchain) MineBlock(transactions []*Transaction) *Block {
-	var lastHash []byte
-	var lastHeight int
-
-	for _, tx := range transactions {
-		// TODO: ignore transaction if it's not valid
-		check, err := bc.VerifyTransaction(tx)
-		if err != nil || !check {
-			fmt.Printf("ERROR: Invalid transaction: %v\n", err)
-			return nil
-		}
-	}
-
+// NewBlockTemplate creates the next block after the tip with the transactions
+// and a coinbase paying the subsidy and the fees to the address,
+// the block has to be solved before it's added to the blockchain
+func (bc *Blockchain) NewBlockTemplate(address string, transactions []*Transaction) (*Block, error) {
+	var lastBlock *Block
 	err := bc.Db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
-		lastHeight = block.Height
+		lastHash := b.Get([]byte("l"))
+		lastBlock = DeserializeBlock(b.Get(lastHash))
 		return nil
 	})
 	if err != nil {
-		fmt.Printf("ERROR: db.View %v\n", err)
-		return nil
-		//log.Panic(err)
+		return nil, err
 	}
 
-	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
+	height := lastBlock.Height + 1
 
-	if newBlock == nil {
-		fmt.Printf("ERROR: NewBlock returns nil")
-		return nil
+	// the same rules as for the network blocks, checked before spending time on PoW
+	fees, err := bc.checkTransactions(&Block{BlockHeader: BlockHeader{Height: height}, Transactions: transactions})
+	if err != nil {
+		return nil, err
 	}
 
-	err = bc.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
+	bits, err := bc.nextBits(&lastBlock.BlockHeader)
+	if err != nil {
+		return nil, fmt.Errorf("Difficulty calculation: %s", err)
+	}
 
-		err = b.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			fmt.Printf("ERROR: b.Put %v\n", err)
-			return nil
-			//log.Panic(err)
-		}
+	cbTx := NewCoinbaseTX(address, "", bc.params.BlockSubsidy(height)+fees)
+	transactions = append([]*Transaction{cbTx}, transactions...)
 
-		bc.tip = newBlock.Hash
+	return newUnsolvedBlock(transactions, lastBlock.Hash, height, bits), nil
+}
 
+// MineBlock mines a new block with the provided transactions
+// The block reward is paid to the address
+func (bc *Blockchain) MineBlock(address string, transactions []*Transaction) *Block {
+	newBlock, err := bc.NewBlockTemplate(address, transactions)
+	if err != nil {
+		fmt.Printf("ERROR: Block template %v\n", err)
 		return nil
-	})
+	}
+
+	pow := NewProofOfWork(&newBlock.BlockHeader)
+	newBlock.Nonce, newBlock.Hash = pow.Run()
+
+	err = bc.AddBlock(newBlock)
 	if err != nil {
-		fmt.Printf("ERROR: db.Update %v\n", err)
+		fmt.Printf("ERROR: AddBlock %v\n", err)
 		return nil
-		//log.Panic(err)
 	}
+
 	return newBlock
 }
 
 // SignTransaction signs inputs of a Transaction
-func (bc *Blockchain) PrepareTransactionToSign(tx *Transaction) (*TransactionToSign, error) {
+func (bc *Blockchain) PrepareTransactionToSign(tx *Transaction, hashType SigHashType) (*TransactionToSign, error) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, vin := range tx.Vin {
@@ -334,7 +481,7 @@ func (bc *Blockchain) PrepareTransactionToSign(tx *Transaction) (*TransactionToS
 		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
 	}
 
-	return tx.PrepareToSign(prevTXs)
+	return tx.PrepareToSign(prevTXs, hashType)
 }
 
 func (bc *Blockchain) SignPreparedTransaction(preparedTx *Transaction, txSignatures *TransactionWithSignatures) error {
@@ -447,7 +594,7 @@ func (bc *Blockchain) GetAddresses() []string {
 }
 
 func (bc *Blockchain) GetWalletBalance(address string) int {
-	if !crypto.ValidateAddress(address) {
+	if !crypto.ValidateAddressVersion(address, bc.params.AddressVersion) {
 		log.Panic("ERROR: Address is not valid")
 	}
 
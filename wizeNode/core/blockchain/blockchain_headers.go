@@ -0,0 +1,360 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// headersBucket keeps headers of all known blocks, including the ones without bodies yet
+// The "l" key holds the hash of the header chain tip with the most work
+const headersBucket = "headers"
+
+// getHeader reads a header from the headers bucket
+// Blocks stored before the headers were indexed are read from the blocks bucket
+func getHeader(tx *bolt.Tx, hash []byte) (*BlockHeader, error) {
+	if hb := tx.Bucket([]byte(headersBucket)); hb != nil {
+		if data := hb.Get(hash); data != nil {
+			return DeserializeBlockHeader(data)
+		}
+	}
+
+	if data := tx.Bucket([]byte(blocksBucket)).Get(hash); data != nil {
+		block, err := DecodeBlock(data)
+		if err != nil {
+			return nil, err
+		}
+		return &block.BlockHeader, nil
+	}
+
+	return nil, fmt.Errorf("Header %x is not found", hash)
+}
+
+// putHeader saves the header and moves the best header to it when its chain has more work
+func putHeader(tx *bolt.Tx, hash []byte, header *BlockHeader, tip []byte) error {
+	hb, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+
+	err = hb.Put(hash, header.Serialize())
+	if err != nil {
+		return err
+	}
+
+	bestHash := hb.Get([]byte("l"))
+	if bestHash == nil {
+		bestHash = tip
+	}
+
+	work, err := chainWork(tx, hash)
+	if err != nil {
+		return err
+	}
+	bestWork, err := chainWork(tx, bestHash)
+	if err != nil {
+		return err
+	}
+
+	if work.Cmp(bestWork) > 0 {
+		return hb.Put([]byte("l"), hash)
+	}
+
+	return nil
+}
+
+// GetHeader finds a block header by the block hash, the block body may be not downloaded yet
+func (bc *Blockchain) GetHeader(hash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		var err error
+		header, err = getHeader(tx, hash)
+		return err
+	})
+
+	return header, err
+}
+
+// HasHeader checks whether the header of the block is known
+func (bc *Blockchain) HasHeader(hash []byte) bool {
+	_, err := bc.GetHeader(hash)
+
+	return err == nil
+}
+
+// BestHeader returns the hash of the header chain tip with the most work
+// It's ahead of the main chain tip while the block bodies are being downloaded
+func (bc *Blockchain) BestHeader() []byte {
+	var bestHash []byte
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		if hb := tx.Bucket([]byte(headersBucket)); hb != nil {
+			bestHash = append([]byte{}, hb.Get([]byte("l"))...)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if len(bestHash) == 0 {
+		return bc.tip
+	}
+
+	return bestHash
+}
+
+// AddHeader validates a header and saves it without the block body
+func (bc *Blockchain) AddHeader(header *BlockHeader) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	hash := header.Hash()
+	if bc.HasHeader(hash) {
+		return nil
+	}
+
+	err := bc.ValidateHeader(header, hash)
+	if err != nil {
+		return err
+	}
+
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		return putHeader(tx, hash, header, bc.tip)
+	})
+}
+
+// BlockLocator returns hashes of the chain ending with the given block
+// to find the fork point with another node: the last 10 blocks one by one,
+// then with doubling steps, the genesis block is always the last one
+func (bc *Blockchain) BlockLocator(hash []byte) [][]byte {
+	var locator [][]byte
+	step := 1
+
+	for len(hash) > 0 {
+		locator = append(locator, hash)
+		if len(locator) > 10 {
+			step *= 2
+		}
+
+		for i := 0; i < step; i++ {
+			header, err := bc.GetHeader(hash)
+			if err != nil {
+				return locator
+			}
+
+			if len(header.PrevBlockHash) == 0 {
+				if i > 0 {
+					locator = append(locator, hash)
+				}
+				return locator
+			}
+			hash = header.PrevBlockHash
+		}
+	}
+
+	return locator
+}
+
+// LocateHeaders returns up to max main chain headers following the first locator
+// hash found in the main chain and ending with stopHash if it's set
+// When no locator hash is found the headers start from the genesis block
+func (bc *Blockchain) LocateHeaders(locator [][]byte, stopHash []byte, max int) []*BlockHeader {
+	_, headers := bc.locate(locator, stopHash, max)
+
+	return headers
+}
+
+// LocateBlocks returns up to max main chain block hashes following the first locator
+// hash found in the main chain, the same way as LocateHeaders
+func (bc *Blockchain) LocateBlocks(locator [][]byte, stopHash []byte, max int) [][]byte {
+	hashes, _ := bc.locate(locator, stopHash, max)
+
+	return hashes
+}
+
+// locate returns hashes and headers of the main chain blocks after the fork point with the locator
+func (bc *Blockchain) locate(locator [][]byte, stopHash []byte, max int) ([][]byte, []*BlockHeader) {
+	known := make(map[string]bool)
+	for _, hash := range locator {
+		known[hex.EncodeToString(hash)] = true
+	}
+
+	var hashes [][]byte
+	var path []*BlockHeader
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		for hash := bc.tip; len(hash) > 0 && !known[hex.EncodeToString(hash)]; {
+			header, err := getHeader(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			hashes = append(hashes, hash)
+			path = append(path, header)
+			hash = header.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Locate blocks: %s\n", err)
+		return nil, nil
+	}
+
+	var located [][]byte
+	var headers []*BlockHeader
+	for i := len(path) - 1; i >= 0 && len(headers) < max; i-- {
+		located = append(located, hashes[i])
+		headers = append(headers, path[i])
+
+		if bytes.Compare(hashes[i], stopHash) == 0 {
+			break
+		}
+	}
+
+	return located, headers
+}
+
+// BlocksToDownload returns hashes of the best header chain blocks
+// which have no body yet, starting from the oldest one
+func (bc *Blockchain) BlocksToDownload() [][]byte {
+	var missing [][]byte
+
+	bestHash := bc.BestHeader()
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+
+		for hash := bestHash; len(hash) > 0 && b.Get(hash) == nil; {
+			header, err := getHeader(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			missing = append(missing, hash)
+			hash = header.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Blocks to download: %s\n", err)
+		return nil
+	}
+
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+
+	return missing
+}
+
+// InvalidateHeader removes the header of an invalid block together with the headers
+// of its descendants, so their bodies are not downloaded, and returns the removed hashes
+// The best header is moved to the remaining header chain with the most work
+func (bc *Blockchain) InvalidateHeader(hash []byte) [][]byte {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	var removed [][]byte
+
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		hb := tx.Bucket([]byte(headersBucket))
+		if hb == nil {
+			return nil
+		}
+		b := tx.Bucket([]byte(blocksBucket))
+		wb := tx.Bucket([]byte(chainWorkBucket))
+
+		children := make(map[string][][]byte)
+		err := hb.ForEach(func(k, v []byte) error {
+			if bytes.Compare(k, []byte("l")) == 0 {
+				return nil
+			}
+			header, err := DeserializeBlockHeader(v)
+			if err != nil {
+				return err
+			}
+
+			parent := hex.EncodeToString(header.PrevBlockHash)
+			children[parent] = append(children[parent], append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// the blocks with bodies are never removed, they are validated already
+		for pending := [][]byte{hash}; len(pending) > 0; pending = pending[1:] {
+			if b.Get(pending[0]) != nil || hb.Get(pending[0]) == nil {
+				continue
+			}
+
+			removed = append(removed, pending[0])
+			pending = append(pending, children[hex.EncodeToString(pending[0])]...)
+		}
+
+		for _, removedHash := range removed {
+			err = hb.Delete(removedHash)
+			if err != nil {
+				return err
+			}
+			if wb != nil {
+				err = wb.Delete(removedHash)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return resetBestHeader(tx, bc.tip)
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Invalidating header %x failed: %s\n", hash, err)
+	}
+
+	for _, removedHash := range removed {
+		bc.dropOrphans(removedHash)
+	}
+
+	return removed
+}
+
+// resetBestHeader finds the header with the most work, the tip wins when the work is equal
+func resetBestHeader(tx *bolt.Tx, tip []byte) error {
+	hb := tx.Bucket([]byte(headersBucket))
+
+	bestHash := tip
+	bestWork, err := chainWork(tx, tip)
+	if err != nil {
+		return err
+	}
+
+	var hashes [][]byte
+	err = hb.ForEach(func(k, v []byte) error {
+		if bytes.Compare(k, []byte("l")) != 0 {
+			hashes = append(hashes, append([]byte{}, k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		work, err := chainWork(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if work.Cmp(bestWork) > 0 {
+			bestHash = hash
+			bestWork = work
+		}
+	}
+
+	return hb.Put([]byte("l"), bestHash)
+}
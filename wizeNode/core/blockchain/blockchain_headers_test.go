@@ -0,0 +1,187 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestHeadersFirst(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	listener := &testListener{}
+	bc.SetListener(listener)
+
+	address := string(w.GetAddress())
+	var blocks []*Block
+	for parent := tipBlock(t, bc); len(blocks) < 3; parent = blocks[len(blocks)-1] {
+		block := newTestBlock(bc, parent, address)
+		if err := bc.AddHeader(&block.BlockHeader); err != nil {
+			t.Fatalf("AddHeader(%d) error = %v", block.Height, err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	if got := bc.BestHeader(); !bytes.Equal(got, blocks[2].Hash) {
+		t.Errorf("BestHeader() = %x, want %x", got, blocks[2].Hash)
+	}
+	if got := bc.BlocksToDownload(); fmt.Sprintf("%x", got) != fmt.Sprintf("%x", [][]byte{blocks[0].Hash, blocks[1].Hash, blocks[2].Hash}) {
+		t.Errorf("BlocksToDownload() = %x, want the 3 blocks oldest first", got)
+	}
+
+	// the blocks arrive in the reverse order and wait for their parents
+	for i := 2; i >= 0; i-- {
+		if err := bc.AddBlock(blocks[i]); err != nil {
+			t.Fatalf("AddBlock(%d) error = %v", blocks[i].Height, err)
+		}
+		if i > 0 && bc.HasBlock(blocks[i].Hash) {
+			t.Errorf("AddBlock(%d) stored the block before its parent", blocks[i].Height)
+		}
+	}
+
+	if got := bc.GetTip(); !bytes.Equal(got, blocks[2].Hash) {
+		t.Errorf("GetTip() = %x, want %x", got, blocks[2].Hash)
+	}
+	if got := fmt.Sprint(listener.events); got != "[connected 1 connected 2 connected 3]" {
+		t.Errorf("events = %s, want [connected 1 connected 2 connected 3]", got)
+	}
+	if got := bc.BlocksToDownload(); len(got) != 0 {
+		t.Errorf("BlocksToDownload() = %x after the download, want none", got)
+	}
+}
+
+func TestInvalidateHeader(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	valid := newTestBlock(bc, tipBlock(t, bc), address)
+	invalid := NewBlock([]*Transaction{NewCoinbaseTX(address, "", bc.params.BlockSubsidy(2)+1)}, valid.Hash, 2, valid.Bits)
+	child := newTestBlock(bc, invalid, address)
+
+	for _, block := range []*Block{valid, invalid, child} {
+		if err := bc.AddHeader(&block.BlockHeader); err != nil {
+			t.Fatalf("AddHeader(%d) error = %v", block.Height, err)
+		}
+	}
+	if err := bc.AddBlock(child); err != nil {
+		t.Fatalf("AddBlock(child) error = %v", err)
+	}
+	if err := bc.AddBlock(valid); err != nil {
+		t.Fatalf("AddBlock(valid) error = %v", err)
+	}
+	if err := bc.AddBlock(invalid); blockErrorCode(err) != ErrBlockBadCoinbase {
+		t.Fatalf("AddBlock(invalid) error = %v, want %s", err, ErrBlockBadCoinbase)
+	}
+
+	// the stored blocks are validated already
+	if removed := bc.InvalidateHeader(valid.Hash); len(removed) != 0 {
+		t.Errorf("InvalidateHeader(valid) removed %x", removed)
+	}
+
+	removed := bc.InvalidateHeader(invalid.Hash)
+	if fmt.Sprintf("%x", removed) != fmt.Sprintf("%x", [][]byte{invalid.Hash, child.Hash}) {
+		t.Errorf("InvalidateHeader() = %x, want the block and its child", removed)
+	}
+	if bc.HasHeader(invalid.Hash) || bc.HasHeader(child.Hash) {
+		t.Error("HasHeader() of an invalidated header = true")
+	}
+	if got := bc.BestHeader(); !bytes.Equal(got, valid.Hash) {
+		t.Errorf("BestHeader() = %x, want %x", got, valid.Hash)
+	}
+	if got := bc.BlocksToDownload(); len(got) != 0 {
+		t.Errorf("BlocksToDownload() = %x, want none", got)
+	}
+	if len(bc.orphans) != 0 {
+		t.Errorf("orphans = %v after invalidating their parent, want none", bc.orphans)
+	}
+}
+
+// newTestChain adds blocks after the tip and returns them oldest first
+func newTestChain(t *testing.T, bc *Blockchain, address string, count int) []*Block {
+	var blocks []*Block
+	for parent := tipBlock(t, bc); len(blocks) < count; parent = blocks[len(blocks)-1] {
+		block := newTestBlock(bc, parent, address)
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	return blocks
+}
+
+func TestBlockLocator(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	newTestChain(t, bc, string(w.GetAddress()), 15)
+
+	var heights []int
+	for _, hash := range bc.BlockLocator(bc.GetTip()) {
+		header, err := bc.GetHeader(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		heights = append(heights, header.Height)
+	}
+
+	// the last 10 blocks one by one, then with doubling steps down to the genesis block
+	want := "[15 14 13 12 11 10 9 8 7 6 5 3 0]"
+	if fmt.Sprint(heights) != want {
+		t.Errorf("BlockLocator() heights = %v, want %s", heights, want)
+	}
+}
+
+func TestLocateBlocks(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	genesis := tipBlock(t, bc)
+	blocks := append([]*Block{genesis}, newTestChain(t, bc, string(w.GetAddress()), 5)...)
+
+	hashes := func(blocks ...*Block) string {
+		var hashes [][]byte
+		for _, block := range blocks {
+			hashes = append(hashes, block.Hash)
+		}
+		return fmt.Sprintf("%x", hashes)
+	}
+
+	// a node 2 blocks behind
+	locator := bc.BlockLocator(blocks[3].Hash)
+
+	tests := []struct {
+		name     string
+		locator  [][]byte
+		stopHash []byte
+		max      int
+		want     string
+	}{
+		{"behind", locator, nil, 500, hashes(blocks[4], blocks[5])},
+		{"stop hash", locator, blocks[4].Hash, 500, hashes(blocks[4])},
+		{"max", nil, nil, 3, hashes(blocks[0], blocks[1], blocks[2])},
+		{"up to date", bc.BlockLocator(bc.GetTip()), nil, 500, hashes()},
+	}
+	for _, test := range tests {
+		if got := bc.LocateBlocks(test.locator, test.stopHash, test.max); fmt.Sprintf("%x", got) != test.want {
+			t.Errorf("LocateBlocks(%s) = %x, want %s", test.name, got, test.want)
+		}
+	}
+
+	// the headers follow the same fork point
+	headers := bc.LocateHeaders(locator, nil, 500)
+	if len(headers) != 2 || !bytes.Equal(headers[0].Hash(), blocks[4].Hash) {
+		t.Errorf("LocateHeaders() = %v, want the headers of blocks 4 and 5", headers)
+	}
+
+	// a locator of a side branch meets the main chain at the fork point
+	side := newTestBlock(bc, blocks[2], string(w.GetAddress()))
+	if err := bc.AddBlock(side); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.LocateBlocks(bc.BlockLocator(side.Hash), nil, 500); fmt.Sprintf("%x", got) != hashes(blocks[3:]...) {
+		t.Errorf("LocateBlocks(side branch) = %x, want blocks 3 to 5", got)
+	}
+}
@@ -0,0 +1,70 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+)
+
+// maxOrphanBlocks limits the number of blocks waiting for the parent body
+const maxOrphanBlocks = 100
+
+// addOrphan keeps a block whose parent header is known, but the parent body is not stored yet
+// The block is added when the parent is, as the fork point can't be found without the bodies
+func (bc *Blockchain) addOrphan(block *Block) {
+	count := 0
+	for _, blocks := range bc.orphans {
+		for _, orphan := range blocks {
+			if bytes.Compare(orphan.Hash, block.Hash) == 0 {
+				return
+			}
+		}
+		count += len(blocks)
+	}
+
+	if count >= maxOrphanBlocks {
+		fmt.Printf("WARN: Orphan block %x is dropped, too many orphans\n", block.Hash)
+		return
+	}
+
+	key := hex.EncodeToString(block.PrevBlockHash)
+	bc.orphans[key] = append(bc.orphans[key], block)
+}
+
+// connectOrphans adds the orphan blocks waiting for the stored block,
+// then the ones waiting for them and so on
+func (bc *Blockchain) connectOrphans(blockHash []byte) []chainEvent {
+	var events []chainEvent
+
+	parents := [][]byte{blockHash}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		key := hex.EncodeToString(parent)
+		if len(bc.orphans[key]) == 0 || !bc.HasBlock(parent) {
+			continue
+		}
+
+		children := bc.orphans[key]
+		delete(bc.orphans, key)
+
+		for _, block := range children {
+			blockEvents, err := bc.addBlock(block)
+			if err != nil {
+				fmt.Printf("WARN: Orphan block %x is rejected: %s\n", block.Hash, err)
+				continue
+			}
+
+			events = append(events, blockEvents...)
+			parents = append(parents, block.Hash)
+		}
+	}
+
+	return events
+}
+
+// dropOrphans forgets the orphan blocks waiting for the block
+func (bc *Blockchain) dropOrphans(blockHash []byte) {
+	delete(bc.orphans, hex.EncodeToString(blockHash))
+}
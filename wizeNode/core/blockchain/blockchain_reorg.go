@@ -0,0 +1,336 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/boltdb/bolt"
+)
+
+const chainWorkBucket = "chainwork"
+
+// ChainListener is notified when blocks are connected to or disconnected from the main chain
+// On a reorganization the disconnected blocks are reported first, oldest first,
+// and then the connected ones, the chain state is already switched to the new branch
+type ChainListener interface {
+	BlockConnected(block *Block)
+	BlockDisconnected(block *Block)
+}
+
+// chainEvent is a postponed notification for the ChainListener
+type chainEvent struct {
+	connected bool
+	block     *Block
+}
+
+// SetListener sets the receiver of main chain changes
+func (bc *Blockchain) SetListener(listener ChainListener) {
+	bc.listener = listener
+}
+
+func (bc *Blockchain) notify(events []chainEvent) {
+	if bc.listener == nil {
+		return
+	}
+
+	for _, event := range events {
+		if event.connected {
+			bc.listener.BlockConnected(event.block)
+		} else {
+			bc.listener.BlockDisconnected(event.block)
+		}
+	}
+}
+
+// chainWork returns the total work of the chain ending with the block or header
+// Blocks stored before the work was tracked are calculated and saved on the way
+func chainWork(tx *bolt.Tx, blockHash []byte) (*big.Int, error) {
+	wb, err := tx.CreateBucketIfNotExists([]byte(chainWorkBucket))
+	if err != nil {
+		return nil, err
+	}
+
+	work := big.NewInt(0)
+	var hashes [][]byte
+	var path []*BlockHeader
+
+	for hash := blockHash; len(hash) > 0; {
+		if data := wb.Get(hash); data != nil {
+			work.SetBytes(data)
+			break
+		}
+
+		header, err := getHeader(tx, hash)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+		path = append(path, header)
+		hash = header.PrevBlockHash
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		work.Add(work, NewProofOfWork(path[i]).Work())
+
+		err = wb.Put(hashes[i], work.Bytes())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return work, nil
+}
+
+// setTip moves the main chain tip to the block
+func (bc *Blockchain) setTip(blockHash []byte) error {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		return b.Put([]byte("l"), blockHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.tip = blockHash
+	return nil
+}
+
+// findFork returns the common ancestor of two blocks, the blocks to disconnect
+// from the old tip down to the ancestor and the blocks to connect from the ancestor up to the new tip
+func (bc *Blockchain) findFork(oldTip, newTip *Block) (*Block, []*Block, []*Block, error) {
+	var detach, attach []*Block
+
+	parent := func(block *Block) (*Block, error) {
+		prevBlock, err := bc.GetBlock(block.PrevBlockHash)
+		if err != nil {
+			return nil, fmt.Errorf("Block %x has no parent in the database", block.Hash)
+		}
+		return &prevBlock, nil
+	}
+
+	var err error
+	oldBlock, newBlock := oldTip, newTip
+
+	for oldBlock.Height > newBlock.Height {
+		detach = append(detach, oldBlock)
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for newBlock.Height > oldBlock.Height {
+		attach = append([]*Block{newBlock}, attach...)
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for bytes.Compare(oldBlock.Hash, newBlock.Hash) != 0 {
+		detach = append(detach, oldBlock)
+		attach = append([]*Block{newBlock}, attach...)
+
+		if oldBlock, err = parent(oldBlock); err != nil {
+			return nil, nil, nil, err
+		}
+		if newBlock, err = parent(newBlock); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return oldBlock, detach, attach, nil
+}
+
+// connectBlock applies the block to the UTXO set and makes it the tip
+// The block is considered to be the next block after the tip
+func (bc *Blockchain) connectBlock(block *Block) error {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		err := UTXOSet{bc}.update(tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = indexTransactions(tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = indexHeight(tx, block)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		return b.Put([]byte("l"), block.Hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.tip = block.Hash
+	return nil
+}
+
+// disconnectBlock rolls the tip block back and makes its parent the tip
+func (bc *Blockchain) disconnectBlock(block *Block) error {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		err := UTXOSet{bc}.revert(tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = unindexTransactions(tx, block)
+		if err != nil {
+			return err
+		}
+
+		err = unindexHeight(tx, block)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		return b.Put([]byte("l"), block.PrevBlockHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.tip = block.PrevBlockHash
+	return nil
+}
+
+// rebuildAt moves the tip to the block and rebuilds the whole UTXO set and the main chain indexes
+// It is used when there is no undo data for the blocks to disconnect
+func (bc *Blockchain) rebuildAt(blockHash []byte) error {
+	err := bc.setTip(blockHash)
+	if err != nil {
+		return err
+	}
+
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	err = bc.ReindexTransactions()
+	if err != nil {
+		return err
+	}
+
+	return bc.ReindexHeights()
+}
+
+// reorganize switches the main chain to the branch ending with the new tip
+// If a block of the new branch is invalid, it is removed together with its descendants
+// and the old main chain is restored
+func (bc *Blockchain) reorganize(newTip *Block) ([]chainEvent, error) {
+	var events []chainEvent
+
+	oldTip, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		return nil, err
+	}
+
+	fork, detach, attach, err := bc.findFork(&oldTip, newTip)
+	if err != nil {
+		return nil, err
+	}
+
+	// disconnect the old branch
+	for _, block := range detach {
+		err = bc.disconnectBlock(block)
+		if err != nil {
+			fmt.Printf("WARN: %s, rebuilding UTXO set\n", err)
+
+			err = bc.rebuildAt(fork.Hash)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// connect the new branch
+	for i, block := range attach {
+		_, err = bc.checkTransactions(block)
+		if err == nil {
+			err = bc.connectBlock(block)
+		}
+
+		if err != nil {
+			bc.removeBlocks(attach[i:])
+
+			restoreErr := bc.restoreChain(attach[:i], detach, oldTip.Hash)
+			if restoreErr != nil {
+				return nil, restoreErr
+			}
+
+			return nil, err
+		}
+	}
+
+	// oldest first, so the transactions of the old branch are returned to the mempool after their parents
+	for i := len(detach) - 1; i >= 0; i-- {
+		events = append(events, chainEvent{false, detach[i]})
+	}
+	for _, block := range attach {
+		events = append(events, chainEvent{true, block})
+	}
+
+	fmt.Printf("Reorganize: fork at %d, %d blocks disconnected, %d blocks connected\n", fork.Height, len(detach), len(attach))
+
+	return events, nil
+}
+
+// restoreChain brings back the main chain after a failed reorganization
+func (bc *Blockchain) restoreChain(connected, disconnected []*Block, oldTip []byte) error {
+	for i := len(connected) - 1; i >= 0; i-- {
+		if err := bc.disconnectBlock(connected[i]); err != nil {
+			return bc.rebuildAt(oldTip)
+		}
+	}
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := bc.connectBlock(disconnected[i]); err != nil {
+			return bc.rebuildAt(oldTip)
+		}
+	}
+
+	return nil
+}
+
+// removeBlocks deletes blocks which turned out to be invalid together with their headers
+// The best header is reset to the main chain tip, as it may be on the removed branch
+func (bc *Blockchain) removeBlocks(blocks []*Block) {
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(blocksBucket))
+		wb := tx.Bucket([]byte(chainWorkBucket))
+		hb := tx.Bucket([]byte(headersBucket))
+
+		for _, block := range blocks {
+			err := b.Delete(block.Hash)
+			if err != nil {
+				return err
+			}
+			if wb != nil {
+				err = wb.Delete(block.Hash)
+				if err != nil {
+					return err
+				}
+			}
+			if hb != nil {
+				err = hb.Delete(block.Hash)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if hb != nil {
+			return hb.Delete([]byte("l"))
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("ERROR: Removing invalid blocks failed: %s\n", err)
+	}
+}
@@ -0,0 +1,142 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/boltdb/bolt"
+
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+type testListener struct {
+	events []string
+}
+
+func (l *testListener) BlockConnected(block *Block) {
+	l.events = append(l.events, fmt.Sprintf("connected %d", block.Height))
+}
+
+func (l *testListener) BlockDisconnected(block *Block) {
+	l.events = append(l.events, fmt.Sprintf("disconnected %d", block.Height))
+}
+
+func testChainWork(t *testing.T, bc *Blockchain, blockHash []byte) *big.Int {
+	var work *big.Int
+	err := bc.Db.Update(func(tx *bolt.Tx) error {
+		var err error
+		work, err = chainWork(tx, blockHash)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return work
+}
+
+func TestChainWork(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	genesis := tipBlock(t, bc)
+	block := newTestBlock(bc, genesis, string(w.GetAddress()))
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	want := new(big.Int).Add(testChainWork(t, bc, genesis.Hash), NewProofOfWork(&block.BlockHeader).Work())
+	if got := testChainWork(t, bc, block.Hash); got.Cmp(want) != 0 {
+		t.Errorf("chainWork() = %s, want %s", got, want)
+	}
+}
+
+func TestReorganize(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	receiver := string(wallet.NewWallet().GetAddress())
+	genesis := tipBlock(t, bc)
+
+	tx := NewUTXOTransaction(w, receiver, 10, 0, NewUTXOView(bc, nil))
+	main1 := newTestBlock(bc, genesis, address, tx)
+	if err := bc.AddBlock(main1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first seen branch stays the main one when the work is equal
+	side1 := newTestBlock(bc, genesis, receiver)
+	if err := bc.AddBlock(side1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.GetTip(), main1.Hash) {
+		t.Fatalf("GetTip() = %x, want %x", bc.GetTip(), main1.Hash)
+	}
+
+	listener := &testListener{}
+	bc.SetListener(listener)
+
+	side2 := newTestBlock(bc, side1, receiver)
+	if err := bc.AddBlock(side2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bc.GetTip(), side2.Hash) {
+		t.Fatalf("GetTip() = %x, want %x", bc.GetTip(), side2.Hash)
+	}
+
+	wantEvents := []string{"disconnected 1", "connected 1", "connected 2"}
+	if fmt.Sprint(listener.events) != fmt.Sprint(wantEvents) {
+		t.Errorf("events = %v, want %v", listener.events, wantEvents)
+	}
+
+	// the transaction of the old branch is not confirmed anymore
+	subsidy := bc.params.BlockSubsidy(1)
+	if got := bc.GetBalance(receiver); got != 2*subsidy {
+		t.Errorf("GetBalance(receiver) = %d, want %d", got, 2*subsidy)
+	}
+	if got := bc.GetBalance(address); got != bc.params.Genesis.Reward {
+		t.Errorf("GetBalance(sender) = %d, want %d", got, bc.params.Genesis.Reward)
+	}
+	if _, err := bc.FindTransaction(tx.ID); err == nil {
+		t.Error("FindTransaction() of the disconnected transaction is successful")
+	}
+}
+
+func TestReorganizeInvalidBranch(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	receiver := wallet.NewWallet()
+	genesis := tipBlock(t, bc)
+
+	tx := NewUTXOTransaction(w, string(receiver.GetAddress()), 10, 0, NewUTXOView(bc, nil))
+	main1 := newTestBlock(bc, genesis, address, tx)
+	if err := bc.AddBlock(main1); err != nil {
+		t.Fatal(err)
+	}
+	// the child of tx is valid only in the main chain
+	child := NewUTXOTransaction(receiver, address, 5, 0, NewUTXOView(bc, nil))
+
+	side1 := newTestBlock(bc, genesis, address)
+	if err := bc.AddBlock(side1); err != nil {
+		t.Fatal(err)
+	}
+	side2 := newTestBlock(bc, side1, address, child)
+	if err := bc.AddBlock(side2); err == nil {
+		t.Fatal("AddBlock() of the invalid branch is successful")
+	}
+
+	if !bytes.Equal(bc.GetTip(), main1.Hash) {
+		t.Errorf("GetTip() = %x, want %x", bc.GetTip(), main1.Hash)
+	}
+	if bc.HasBlock(side2.Hash) {
+		t.Error("the invalid block is kept")
+	}
+	if got := bc.GetBalance(string(receiver.GetAddress())); got != 10 {
+		t.Errorf("GetBalance() = %d, want 10", got)
+	}
+}
@@ -0,0 +1,162 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// testParams are the rules of the test blockchains: half of the hashes solve a block,
+// the difficulty doesn't change and the coinbases can be spent right away
+// The genesis block pays the emission to the wallet
+func testParams(w *wallet.Wallet) *Params {
+	params := DefaultParams
+	params.PowLimit = new(big.Int).Lsh(big.NewInt(1), 255)
+	params.RetargetInterval = 0
+	params.CoinbaseMaturity = 0
+	params.Genesis = Genesis{
+		Address:      string(w.GetAddress()),
+		CoinbaseData: "test genesis",
+		Reward:       1000000,
+		Timestamp:    1523558612,
+		Bits:         BigToCompact(params.PowLimit),
+	}
+
+	for !NewProofOfWork(&params.Genesis.Block().BlockHeader).Validate() {
+		params.Genesis.Nonce++
+	}
+	params.Genesis.Hash = hex.EncodeToString(params.Genesis.Block().Hash)
+
+	return &params
+}
+
+// newTestBlockchain creates a blockchain in a temporary directory,
+// the genesis block pays the emission to the returned wallet
+func newTestBlockchain(t *testing.T) (*Blockchain, *wallet.Wallet, func()) {
+	dir, err := ioutil.TempDir("", "blockchain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := wallet.NewWallet()
+	bc := CreateBlockchain(dir+"/", "test", testParams(w))
+	UTXOSet := UTXOSet{bc}
+	UTXOSet.Reindex()
+
+	return bc, w, func() {
+		bc.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestBlock solves a block after the parent with the transactions
+// and a coinbase paying the subsidy to the address
+func newTestBlock(bc *Blockchain, parent *Block, address string, transactions ...*Transaction) *Block {
+	coinbase := NewCoinbaseTX(address, "", bc.params.BlockSubsidy(parent.Height+1))
+	transactions = append([]*Transaction{coinbase}, transactions...)
+
+	return NewBlock(transactions, parent.Hash, parent.Height+1, parent.Bits)
+}
+
+// solveTestBlock solves the block again after its header is changed
+func solveTestBlock(block *Block) {
+	block.Nonce, block.Hash = NewProofOfWork(&block.BlockHeader).Run()
+}
+
+// tipBlock returns the tip block of the blockchain
+func tipBlock(t *testing.T, bc *Blockchain) *Block {
+	block, err := bc.GetBlock(bc.GetTip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &block
+}
+
+// blockErrorCode returns the rule broken by the block or -1 for other errors
+func blockErrorCode(err error) BlockErrorCode {
+	if blockErr, ok := err.(*BlockError); ok {
+		return blockErr.Code
+	}
+
+	return -1
+}
+
+func TestAddBlockValidation(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	receiver := string(wallet.NewWallet().GetAddress())
+	genesis := tipBlock(t, bc)
+
+	tx := NewUTXOTransaction(w, receiver, 10, 0, NewUTXOView(bc, nil))
+	doubleSpend := NewUTXOTransaction(w, receiver, 20, 0, NewUTXOView(bc, nil))
+
+	// half of the hashes solve a test block, so a nonce which doesn't is found soon
+	badPoW := newTestBlock(bc, genesis, address, tx)
+	for pow := NewProofOfWork(&badPoW.BlockHeader); pow.Validate(); {
+		badPoW.Nonce++
+	}
+	badPoW.Hash = NewProofOfWork(&badPoW.BlockHeader).Hash()
+
+	badMerkleRoot := newTestBlock(bc, genesis, address, tx)
+	badMerkleRoot.MerkleRoot = genesis.MerkleRoot
+	solveTestBlock(badMerkleRoot)
+
+	badHeight := newTestBlock(bc, genesis, address, tx)
+	badHeight.Height = 5
+	solveTestBlock(badHeight)
+
+	orphan := newTestBlock(bc, genesis, address, tx)
+	orphan.PrevBlockHash = orphan.MerkleRoot
+	solveTestBlock(orphan)
+
+	// the parent is unknown too, the target is checked before it's looked up
+	easyTarget := new(big.Int).Lsh(bc.params.PowLimit, 1)
+	aboveLimit := NewBlock([]*Transaction{NewCoinbaseTX(address, "", 0)}, orphan.PrevBlockHash, 1, BigToCompact(easyTarget))
+
+	badCoinbase := NewBlock([]*Transaction{NewCoinbaseTX(address, "", bc.params.BlockSubsidy(1)+1)}, genesis.Hash, 1, genesis.Bits)
+
+	tests := []struct {
+		name  string
+		block *Block
+		code  BlockErrorCode
+	}{
+		{"bad proof-of-work", badPoW, ErrBlockBadProofOfWork},
+		{"bad merkle root", badMerkleRoot, ErrBlockBadMerkleRoot},
+		{"bad height", badHeight, ErrBlockBadHeight},
+		{"orphan", orphan, ErrBlockOrphan},
+		{"target above the limit", aboveLimit, ErrBlockBadDifficulty},
+		{"coinbase above the subsidy", badCoinbase, ErrBlockBadCoinbase},
+		{"double spend", newTestBlock(bc, genesis, address, tx, doubleSpend), ErrBlockDoubleSpend},
+		{"duplicate transaction", newTestBlock(bc, genesis, address, tx, tx), ErrBlockDuplicateTx},
+	}
+	for _, test := range tests {
+		err := bc.AddBlock(test.block)
+		if code := blockErrorCode(err); code != test.code {
+			t.Errorf("AddBlock(%s) error = %v, want %s", test.name, err, test.code)
+		}
+		if bc.HasBlock(test.block.Hash) {
+			t.Errorf("AddBlock(%s) stored the block", test.name)
+		}
+	}
+
+	block := newTestBlock(bc, genesis, address, tx)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatalf("AddBlock() error = %v", err)
+	}
+	if got := bc.GetBalance(receiver); got != 10 {
+		t.Errorf("GetBalance() = %d, want 10", got)
+	}
+
+	// the outputs spent by the tip can't be spent again
+	err := bc.AddBlock(newTestBlock(bc, block, address, doubleSpend))
+	if code := blockErrorCode(err); code != ErrBlockDoubleSpend {
+		t.Errorf("AddBlock(spent output) error = %v, want %s", err, ErrBlockDoubleSpend)
+	}
+}
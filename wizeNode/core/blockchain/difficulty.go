@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+const (
+	// minTargetBits is the lowest difficulty allowed by default, as the number of leading zero bits
+	minTargetBits = 12
+	// maxRetargetFactor limits how much the difficulty can change at once
+	maxRetargetFactor = 4
+)
+
+var (
+	// powLimit is the highest target a block can have by default
+	powLimit = new(big.Int).Lsh(big.NewInt(1), 256-minTargetBits)
+	// genesisBits is the default compact target of the genesis block and of the blocks without a target
+	genesisBits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-targetBits))
+)
+
+// CompactToBig converts a compact target to a big integer
+// The compact form keeps the size of the number in bytes in the highest byte
+// and the 3 most significant bytes of the number in the rest, 0x00800000 is the sign bit
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var n *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		n = big.NewInt(int64(mantissa))
+	} else {
+		n = big.NewInt(int64(mantissa))
+		n.Lsh(n, 8*(exponent-3))
+	}
+
+	if isNegative {
+		n = n.Neg(n)
+	}
+
+	return n
+}
+
+// BigToCompact converts a big integer to a compact target
+// Only the 3 most significant bytes are kept, so the conversion may lose precision
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(new(big.Int).Abs(n).Uint64())
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Abs(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Uint64())
+	}
+
+	// the highest bit of the mantissa is the sign, so move the number one byte right
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+// headerBits returns the compact target of the block
+// Blocks created before the difficulty adjustment have no target and use the genesis one
+func headerBits(h *BlockHeader) uint32 {
+	if h.Bits == 0 {
+		return genesisBits
+	}
+
+	return h.Bits
+}
+
+// nextBits calculates the compact target required for the block after prevBlock
+// The target is changed every RetargetInterval blocks by the ratio of the actual
+// time spent on the last interval to the expected one
+func (bc *Blockchain) nextBits(prevBlock *BlockHeader) (uint32, error) {
+	bits := headerBits(prevBlock)
+	retargetInterval := bc.params.RetargetInterval
+
+	if retargetInterval <= 0 || (prevBlock.Height+1)%retargetInterval != 0 {
+		return bits, nil
+	}
+
+	firstBlock := prevBlock
+	for i := 0; i < retargetInterval-1 && len(firstBlock.PrevBlockHash) > 0; i++ {
+		header, err := bc.GetHeader(firstBlock.PrevBlockHash)
+		if err != nil {
+			return 0, err
+		}
+		firstBlock = header
+	}
+
+	expectedTimespan := int64(retargetInterval-1) * bc.params.TargetBlockSpacing
+	actualTimespan := prevBlock.Timestamp - firstBlock.Timestamp
+	if actualTimespan < expectedTimespan/maxRetargetFactor {
+		actualTimespan = expectedTimespan / maxRetargetFactor
+	}
+	if actualTimespan > expectedTimespan*maxRetargetFactor {
+		actualTimespan = expectedTimespan * maxRetargetFactor
+	}
+
+	target := CompactToBig(bits)
+	target.Mul(target, big.NewInt(actualTimespan))
+	target.Div(target, big.NewInt(expectedTimespan))
+
+	if target.Cmp(bc.params.PowLimit) > 0 {
+		target.Set(bc.params.PowLimit)
+	}
+
+	return BigToCompact(target), nil
+}
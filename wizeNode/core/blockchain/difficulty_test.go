@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCompactToBig(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		want    string
+	}{
+		{0x00000000, "0"},
+		{0x03123456, "123456"},
+		{0x04123456, "12345600"},
+		{0x02123456, "1234"},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000"},
+		{0x1f010000, "1000000000000000000000000000000000000000000000000000000000000"},
+		{0x04923456, "-12345600"},
+	}
+
+	for _, test := range tests {
+		want, _ := new(big.Int).SetString(test.want, 16)
+		got := CompactToBig(test.compact)
+		if got.Cmp(want) != 0 {
+			t.Errorf("CompactToBig(%08x) = %x, want %x", test.compact, got, want)
+		}
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	tests := []struct {
+		n    string
+		want uint32
+	}{
+		{"0", 0x00000000},
+		{"123456", 0x03123456},
+		{"80", 0x02008000},
+		{"12345678", 0x04123456},
+		{"ffff0000000000000000000000000000000000000000000000000000", 0x1d00ffff},
+		{"-12345600", 0x04923456},
+	}
+
+	for _, test := range tests {
+		n, _ := new(big.Int).SetString(test.n, 16)
+		got := BigToCompact(n)
+		if got != test.want {
+			t.Errorf("BigToCompact(%s) = %08x, want %08x", test.n, got, test.want)
+		}
+	}
+}
+
+func TestGenesisBits(t *testing.T) {
+	target := new(big.Int).Lsh(big.NewInt(1), 256-targetBits)
+	if CompactToBig(genesisBits).Cmp(target) != 0 {
+		t.Errorf("genesisBits %08x is not 2^%d", genesisBits, 256-targetBits)
+	}
+	if CompactToBig(genesisBits).Cmp(powLimit) > 0 {
+		t.Errorf("genesisBits %08x is above the limit", genesisBits)
+	}
+}
@@ -0,0 +1,18 @@
+package blockchain
+
+import (
+	"wizeBlock/wizeNode/core/wire"
+)
+
+// decode reads data in the canonical encoding with the decoder function
+// Data stored with gob by the versions before the canonical encoding isn't read,
+// the blockchains of these versions are created again
+func decode(data []byte, decoder func(r *wire.Reader)) error {
+	r, err := wire.NewReader(data)
+	if err != nil {
+		return err
+	}
+	decoder(r)
+
+	return r.Close()
+}
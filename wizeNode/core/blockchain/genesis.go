@@ -0,0 +1,58 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// Genesis fixes every field of the genesis block of a network,
+// so all the nodes of the network build the same block
+type Genesis struct {
+	// Address receives the emission
+	Address string
+	// CoinbaseData is the data of the coinbase input
+	CoinbaseData string
+	// Reward is the emission of the genesis block
+	Reward int
+	// Timestamp is the time of the block, the coinbase transaction gets it in nanoseconds
+	Timestamp int64
+	// Bits is the compact target of the genesis block
+	Bits uint32
+	// Nonce solves the proof-of-work of the block
+	Nonce int
+	// Hash is the hash of the block in hex, the blockchains with another genesis are not opened
+	Hash string
+}
+
+// Block builds the genesis block
+func (g *Genesis) Block() *Block {
+	txin := TXInput{[]byte{}, -1, nil, []byte(g.CoinbaseData), 0}
+	txout := NewTXOutput(g.Reward, g.Address)
+	coinbase := &Transaction{g.Timestamp * int64(time.Second), nil, []TXInput{txin}, []TXOutput{*txout}}
+	coinbase.ID = coinbase.Hash()
+
+	block := &Block{
+		BlockHeader: BlockHeader{
+			Version:       blockVersion,
+			PrevBlockHash: []byte{},
+			Timestamp:     g.Timestamp,
+			Bits:          g.Bits,
+			Nonce:         g.Nonce,
+		},
+		Transactions: []*Transaction{coinbase},
+	}
+	block.MerkleRoot = block.HashTransactions()
+	block.Hash = block.BlockHeader.Hash()
+
+	return block
+}
+
+// GenesisHash returns the hash of the genesis block of the network
+func (p *Params) GenesisHash() []byte {
+	hash, err := hex.DecodeString(p.Genesis.Hash)
+	if err != nil {
+		return nil
+	}
+
+	return hash
+}
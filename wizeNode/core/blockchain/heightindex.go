@@ -0,0 +1,114 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// heightIndexBucket maps the heights of the main chain blocks to their hashes
+// The heights are big-endian, so the last key is the best height
+const heightIndexBucket = "heightindex"
+
+// indexHeight adds a block connected to the main chain to the height index
+func indexHeight(dbtx *bolt.Tx, block *Block) error {
+	b, err := dbtx.CreateBucketIfNotExists([]byte(heightIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(IntToHex(int64(block.Height)), block.Hash)
+}
+
+// unindexHeight removes a block disconnected from the main chain from the height index
+func unindexHeight(dbtx *bolt.Tx, block *Block) error {
+	b := dbtx.Bucket([]byte(heightIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(IntToHex(int64(block.Height)))
+}
+
+// hasHeightIndex checks whether the height index is built
+// The blockchains created before the index was added don't have it
+func (bc *Blockchain) hasHeightIndex() bool {
+	exists := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket([]byte(heightIndexBucket)) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return exists
+}
+
+// ReindexHeights rebuilds the height index from the main chain headers
+func (bc *Blockchain) ReindexHeights() error {
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(heightIndexBucket))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		b, err := tx.CreateBucket([]byte(heightIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		for hash := bc.tip; len(hash) > 0; {
+			header, err := getHeader(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			err = b.Put(IntToHex(int64(header.Height)), hash)
+			if err != nil {
+				return err
+			}
+			hash = header.PrevBlockHash
+		}
+
+		return nil
+	})
+}
+
+// GetBlockHashByHeight returns the hash of the main chain block of the height
+func (bc *Blockchain) GetBlockHashByHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(heightIndexBucket))
+		if b == nil {
+			return nil
+		}
+
+		// bolt values are valid only inside the transaction
+		if data := b.Get(IntToHex(int64(height))); data != nil {
+			hash = append([]byte{}, data...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return nil, fmt.Errorf("No block of height %d in the main chain", height)
+	}
+
+	return hash, nil
+}
+
+// GetBlockByHeight returns the main chain block of the height
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := bc.GetBlockHashByHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return bc.GetBlock(hash)
+}
@@ -0,0 +1,69 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestHeightIndexReorganize(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	genesis := tipBlock(t, bc)
+	blocks := newTestChain(t, bc, address, 2)
+
+	if got := bc.GetBestHeight(); got != 2 {
+		t.Errorf("GetBestHeight() = %d, want 2", got)
+	}
+	if hash, err := bc.GetBlockHashByHeight(2); err != nil || !bytes.Equal(hash, blocks[1].Hash) {
+		t.Errorf("GetBlockHashByHeight(2) = %x, %v, want %x", hash, err, blocks[1].Hash)
+	}
+
+	// the longer side branch rewrites the heights of the main chain
+	side := []*Block{newTestBlock(bc, genesis, address)}
+	for len(side) < 3 {
+		side = append(side, newTestBlock(bc, side[len(side)-1], address))
+	}
+	for _, block := range side {
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := bc.GetBestHeight(); got != 3 {
+		t.Errorf("GetBestHeight() after the reorganization = %d, want 3", got)
+	}
+	for i, block := range append([]*Block{genesis}, side...) {
+		if hash, err := bc.GetBlockHashByHeight(i); err != nil || !bytes.Equal(hash, block.Hash) {
+			t.Errorf("GetBlockHashByHeight(%d) = %x, %v, want %x", i, hash, err, block.Hash)
+		}
+	}
+	if block, err := bc.GetBlockByHeight(2); err != nil || !bytes.Equal(block.Hash, side[1].Hash) {
+		t.Errorf("GetBlockByHeight(2) = %x, %v, want %x", block.Hash, err, side[1].Hash)
+	}
+	if _, err := bc.GetBlockHashByHeight(4); err == nil {
+		t.Error("GetBlockHashByHeight() above the tip is successful")
+	}
+
+	// the index kept by connecting and disconnecting the blocks is the rebuilt one
+	want := fmt.Sprint(bucketSnapshot(t, bc, heightIndexBucket))
+	if err := bc.ReindexHeights(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(bucketSnapshot(t, bc, heightIndexBucket)); got != want {
+		t.Errorf("ReindexHeights() index = %s, want %s", got, want)
+	}
+
+	// the rebuild at a lower block forgets the heights above it
+	if err := bc.rebuildAt(side[0].Hash); err != nil {
+		t.Fatal(err)
+	}
+	if got := bc.GetBestHeight(); got != 1 {
+		t.Errorf("GetBestHeight() after rebuildAt(1) = %d, want 1", got)
+	}
+	if _, err := bc.GetBlockHashByHeight(2); err == nil {
+		t.Error("GetBlockHashByHeight() above the rebuilt tip is successful")
+	}
+}
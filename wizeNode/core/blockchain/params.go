@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"sync"
+
+	"wizeBlock/wizeNode/core/crypto"
+)
+
+// Params are the consensus rules of the blockchain: the genesis block,
+// the difficulty rules and the block rewards
+type Params struct {
+	// Genesis is the first block of the chain
+	Genesis Genesis
+	// AddressVersion is the first byte of the addresses
+	AddressVersion byte
+
+	// PowLimit is the highest target a block can have
+	PowLimit *big.Int
+	// RetargetInterval is the number of blocks between difficulty adjustments,
+	// the difficulty of the genesis block is kept when it's zero
+	RetargetInterval int
+	// TargetBlockSpacing is the desired time between blocks in seconds
+	TargetBlockSpacing int64
+
+	// Subsidy is the reward for a block before the first halving
+	Subsidy int
+	// SubsidyHalvingInterval is the number of blocks after which the subsidy is halved,
+	// the subsidy doesn't change when it's zero
+	SubsidyHalvingInterval int
+	// CoinbaseMaturity is the number of blocks after which coinbase outputs can be spent
+	// The emission of the genesis block can be spent right away
+	CoinbaseMaturity int
+}
+
+// DefaultParams are the rules of the main network
+var DefaultParams = Params{
+	Genesis: Genesis{
+		Address:      "14b21WZ21rQcXQfNRdqNcLesjxXcX5PMP4",
+		CoinbaseData: "The Times 22/Jan/2017 Now I have nice gopher",
+		Reward:       1000000,
+		Timestamp:    1523558612,
+		Bits:         genesisBits,
+		Nonce:        12287,
+		Hash:         "00003f9df8981bfebe98986e894d43602172c2364ff900dc8a529861edc945c1",
+	},
+	AddressVersion: crypto.Version,
+
+	PowLimit:           powLimit,
+	RetargetInterval:   10,
+	TargetBlockSpacing: 60,
+
+	Subsidy:                50,
+	SubsidyHalvingInterval: 210000,
+	CoinbaseMaturity:       100,
+}
+
+// BlockSubsidy returns the reward for a block of the height without the fees
+func (p *Params) BlockSubsidy(height int) int {
+	if p.SubsidyHalvingInterval <= 0 {
+		return p.Subsidy
+	}
+
+	halvings := uint(height / p.SubsidyHalvingInterval)
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.Subsidy >> halvings
+}
+
+// Params returns the consensus rules of the blockchain
+func (bc *Blockchain) Params() *Params {
+	return bc.params
+}
+
+// SetParams sets the consensus rules of the blockchain
+func (bc *Blockchain) SetParams(params *Params) {
+	bc.params = params
+}
+
+// ImmatureCoinbases returns the IDs of the main chain coinbase transactions
+// which outputs can't be spent in the next block
+func (bc *Blockchain) ImmatureCoinbases() map[string]bool {
+	return bc.immatureCoinbases(bc.GetBestHeight() + 1)
+}
+
+// coinbaseCache keeps the heights of the coinbase transactions of the recent main chain blocks,
+// the blocks are read again only when the tip changes
+type coinbaseCache struct {
+	mu      sync.Mutex
+	tip     []byte
+	heights map[string]int
+}
+
+// immatureCoinbases returns the IDs of the main chain coinbase transactions
+// which outputs can't be spent in a block of the height after the tip
+func (bc *Blockchain) immatureCoinbases(height int) map[string]bool {
+	immature := make(map[string]bool)
+	if bc.params.CoinbaseMaturity <= 0 {
+		return immature
+	}
+
+	bc.coinbases.mu.Lock()
+	defer bc.coinbases.mu.Unlock()
+
+	bci := bc.Iterator()
+	if bc.coinbases.heights == nil || !bytes.Equal(bc.coinbases.tip, bci.currentHash) {
+		bc.coinbases.tip = bci.currentHash
+		bc.coinbases.heights = make(map[string]int)
+
+		tipHeight := -1
+		for {
+			block := bci.Next()
+			if tipHeight < 0 {
+				tipHeight = block.Height
+			}
+			if block.Height == 0 || tipHeight-block.Height >= bc.params.CoinbaseMaturity {
+				break
+			}
+
+			for _, tx := range block.Transactions {
+				if tx.IsCoinbase() {
+					bc.coinbases.heights[hex.EncodeToString(tx.ID)] = block.Height
+				}
+			}
+		}
+	}
+
+	for txID, coinbaseHeight := range bc.coinbases.heights {
+		if height-coinbaseHeight < bc.params.CoinbaseMaturity {
+			immature[txID] = true
+		}
+	}
+
+	return immature
+}
@@ -0,0 +1,61 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+func TestBlockSubsidy(t *testing.T) {
+	params := &Params{Subsidy: 50, SubsidyHalvingInterval: 100}
+
+	tests := []struct {
+		height int
+		want   int
+	}{
+		{0, 50},
+		{99, 50},
+		{100, 25},
+		{250, 12},
+		{100 * 6, 0},
+		{100 * 100, 0},
+	}
+
+	for _, test := range tests {
+		if got := params.BlockSubsidy(test.height); got != test.want {
+			t.Errorf("BlockSubsidy(%d) = %d, want %d", test.height, got, test.want)
+		}
+	}
+
+	params.SubsidyHalvingInterval = 0
+	if got := params.BlockSubsidy(1000000); got != 50 {
+		t.Errorf("BlockSubsidy() without halvings = %d, want 50", got)
+	}
+}
+
+func TestImmatureCoinbases(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	bc.params.CoinbaseMaturity = 3
+	blocks := newTestChain(t, bc, string(w.GetAddress()), 4)
+
+	coinbases := func(blocks ...*Block) string {
+		want := make(map[string]bool)
+		for _, block := range blocks {
+			want[hex.EncodeToString(block.Transactions[0].ID)] = true
+		}
+		return fmt.Sprint(want)
+	}
+
+	// the next block has the height 5
+	if got := fmt.Sprint(bc.ImmatureCoinbases()); got != coinbases(blocks[2], blocks[3]) {
+		t.Errorf("ImmatureCoinbases() = %s, want the coinbases of blocks 3 and 4", got)
+	}
+
+	// the cached coinbases follow the new tip
+	blocks = append(blocks, newTestChain(t, bc, string(w.GetAddress()), 1)...)
+	if got := fmt.Sprint(bc.ImmatureCoinbases()); got != coinbases(blocks[3], blocks[4]) {
+		t.Errorf("ImmatureCoinbases() after a new block = %s, want the coinbases of blocks 4 and 5", got)
+	}
+}
@@ -1,7 +1,7 @@
 package blockchain
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"math"
@@ -9,39 +9,34 @@ import (
 )
 
 const (
+	// targetBits is the default difficulty of the genesis block
 	targetBits = 16
 	maxNonce   = math.MaxInt64
+	// cancelCheckNonces is how many nonces are tried between the checks of the cancellation
+	cancelCheckNonces = 10000
 )
 
 // ProofOfWork represents a proof-of-work
 type ProofOfWork struct {
-	block  *Block
+	header *BlockHeader
 	target *big.Int
 }
 
 // NewProofOfWork builds and returns a ProofOfWork
-func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+// The target is taken from the compact form stored in the header
+func NewProofOfWork(h *BlockHeader) *ProofOfWork {
+	target := CompactToBig(headerBits(h))
 
-	pow := &ProofOfWork{b, target}
+	pow := &ProofOfWork{h, target}
 
 	return pow
 }
 
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			IntToHex(pow.block.Timestamp),
-			IntToHex(int64(targetBits)),
-			IntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
-
-	return data
+	header := *pow.header
+	header.Nonce = nonce
+
+	return header.Serialize()
 }
 
 // Run performs a proof-of-work
@@ -50,7 +45,6 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 	var hash [32]byte
 	nonce := 0
 
-	//fmt.Printf("Mining the block containing \"%s\"\n", pow.block.Transactions)
 	fmt.Printf("Mining a new block")
 	for nonce < maxNonce {
 		data := pow.prepareData(nonce)
@@ -72,13 +66,60 @@ func (pow *ProofOfWork) Run() (int, []byte) {
 	return nonce, hash[:]
 }
 
+// RunContext tries the nonces from start with the step until the proof-of-work is found
+// or the context is cancelled, so a few workers can search the nonces together
+// False is returned when the search is stopped without a result
+func (pow *ProofOfWork) RunContext(ctx context.Context, start, step int) (int, []byte, bool) {
+	var hashInt big.Int
+
+	for nonce := start; nonce >= 0 && nonce < maxNonce; nonce += step {
+		if (nonce-start)/step%cancelCheckNonces == 0 {
+			select {
+			case <-ctx.Done():
+				return 0, nil, false
+			default:
+			}
+		}
+
+		hash := sha256.Sum256(pow.prepareData(nonce))
+		hashInt.SetBytes(hash[:])
+
+		if hashInt.Cmp(pow.target) == -1 {
+			return nonce, hash[:], true
+		}
+	}
+
+	return 0, nil, false
+}
+
+// Hash calculates the hash of the block with its nonce
+func (pow *ProofOfWork) Hash() []byte {
+	data := pow.prepareData(pow.header.Nonce)
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// Work returns the expected number of hashes needed to solve the block
+func (pow *ProofOfWork) Work() *big.Int {
+	// 2^256 / (target + 1)
+	denominator := new(big.Int).Add(pow.target, big.NewInt(1))
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+
+	return numerator.Div(numerator, denominator)
+}
+
 // Validate validates block's PoW
+// The target has to be positive and the hash has to be below it,
+// the limit of the network is checked by the header validation
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	if pow.target.Sign() <= 0 {
+		return false
+	}
+
+	hashInt.SetBytes(pow.Hash())
 
 	isValid := hashInt.Cmp(pow.target) == -1
 
@@ -0,0 +1,33 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProofOfWorkRunContext(t *testing.T) {
+	header := &BlockHeader{Version: blockVersion, Timestamp: 1, Bits: BigToCompact(powLimit)}
+
+	// the nonces are shared between two workers
+	for start := 0; start < 2; start++ {
+		pow := NewProofOfWork(header)
+		nonce, hash, ok := pow.RunContext(context.Background(), start, 2)
+		if !ok || nonce%2 != start {
+			t.Fatalf("RunContext(%d, 2) = %d, %t", start, nonce, ok)
+		}
+
+		solved := *header
+		solved.Nonce = nonce
+		pow = NewProofOfWork(&solved)
+		if !pow.Validate() || string(pow.Hash()) != string(hash) {
+			t.Errorf("RunContext(%d, 2) = nonce %d which is not valid", start, nonce)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	header.Bits = 0x03000001
+	if _, _, ok := NewProofOfWork(header).RunContext(ctx, 0, 1); ok {
+		t.Error("RunContext() with a cancelled context is successful")
+	}
+}
@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"wizeBlock/wizeNode/core/wire"
+)
+
+// SigHashType defines which parts of a transaction are covered by an input signature
+type SigHashType int
+
+const (
+	// SigHashAll signs all inputs and outputs
+	SigHashAll SigHashType = 0x01
+	// SigHashNone signs all inputs and no outputs, so the outputs can be changed
+	SigHashNone SigHashType = 0x02
+	// SigHashSingle signs all inputs and the output with the same index as the input
+	SigHashSingle SigHashType = 0x03
+	// SigHashAnyoneCanPay can be combined with the types above to sign only the own input
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashMask = 0x1f
+)
+
+// Valid checks whether the type is one of the known combinations
+func (t SigHashType) Valid() bool {
+	if t&^(sigHashMask|SigHashAnyoneCanPay) != 0 {
+		return false
+	}
+
+	base := t & sigHashMask
+	return base == SigHashAll || base == SigHashNone || base == SigHashSingle
+}
+
+// SignatureHash returns the hash which is signed for the input with the given index
+// prevPubKeyHash is the PubKeyHash of the output spent by the input
+//
+// The hash is sha256 of the preimage in the canonical encoding (see package wire):
+//
+//	timestamp      integer
+//	inputs         list of:
+//	  txid         byte string
+//	  vout         integer
+//	  script       byte string, prevPubKeyHash for the signed input, empty for others
+//	outputs        list of:
+//	  value        integer
+//	  pubkeyhash   byte string
+//	  address      string
+//	input index    integer
+//	sighash type   integer
+//
+// With SigHashAnyoneCanPay the inputs list has only the signed input,
+// with SigHashNone the outputs list is empty and with SigHashSingle
+// it has only the output with the same index as the signed input.
+func (tx *Transaction) SignatureHash(inIdx int, prevPubKeyHash []byte, hashType SigHashType) ([]byte, error) {
+	if !hashType.Valid() {
+		return nil, fmt.Errorf("Unknown sighash type 0x%x", int(hashType))
+	}
+	if inIdx < 0 || inIdx >= len(tx.Vin) {
+		return nil, fmt.Errorf("Input %d is out of range", inIdx)
+	}
+
+	inputs := tx.Vin
+	signedIdx := inIdx
+	if hashType&SigHashAnyoneCanPay != 0 {
+		inputs = tx.Vin[inIdx : inIdx+1]
+		signedIdx = 0
+	}
+
+	var outputs []TXOutput
+	switch hashType & sigHashMask {
+	case SigHashAll:
+		outputs = tx.Vout
+	case SigHashSingle:
+		if inIdx >= len(tx.Vout) {
+			return nil, fmt.Errorf("Input %d has no matching output for SINGLE", inIdx)
+		}
+		outputs = tx.Vout[inIdx : inIdx+1]
+	}
+
+	w := wire.NewWriter()
+	w.WriteInt(tx.Timestamp)
+
+	w.WriteCount(len(inputs))
+	for i, vin := range inputs {
+		w.WriteBytes(vin.Txid)
+		w.WriteInt(int64(vin.Vout))
+		if i == signedIdx {
+			w.WriteBytes(prevPubKeyHash)
+		} else {
+			w.WriteBytes(nil)
+		}
+	}
+
+	w.WriteCount(len(outputs))
+	for _, out := range outputs {
+		w.WriteInt(int64(out.Value))
+		w.WriteBytes(out.PubKeyHash)
+		w.WriteString(out.Address)
+	}
+
+	w.WriteInt(int64(inIdx))
+	w.WriteInt(int64(hashType))
+
+	hash := sha256.Sum256(w.Bytes())
+
+	return hash[:], nil
+}
@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+
+	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// signWithType signs the prepared hashes of the transaction inputs like an external wallet does
+func signWithType(t *testing.T, w *wallet.Wallet, tx *Transaction, prevTXs map[string]Transaction, hashType SigHashType) {
+	toSign, err := tx.PrepareToSign(prevTXs, hashType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signatures []string
+	for _, hashToSign := range toSign.HashesToSign {
+		hash, _ := hex.DecodeString(hashToSign)
+		r, s, err := crypto.Sign(rand.Reader, &w.PrivateKey, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, hex.EncodeToString(crypto.SerializeSignature(r, s)))
+	}
+
+	err = tx.SignPrepared(&TransactionWithSignatures{TxID: tx.ID, Signatures: signatures}, prevTXs)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignatureHash(t *testing.T) {
+	w := wallet.NewWallet()
+	tx, funding := newTestSpend(w, "1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39")
+	pubKeyHash := funding.Vout[0].PubKeyHash
+
+	changeOutput := func(i int) func(tx *Transaction) {
+		return func(tx *Transaction) { tx.Vout[i].Value++ }
+	}
+	addInput := func(tx *Transaction) {
+		tx.Vin = append(tx.Vin, TXInput{Txid: []byte{1}, Vout: 0, PubKey: w.PublicKey})
+	}
+
+	// whether the hash of the first input covers the change
+	tests := []struct {
+		name     string
+		hashType SigHashType
+		change   func(tx *Transaction)
+		covered  bool
+	}{
+		{"all, output", SigHashAll, changeOutput(1), true},
+		{"all, input", SigHashAll, addInput, true},
+		{"none, output", SigHashNone, changeOutput(0), false},
+		{"none, input", SigHashNone, addInput, true},
+		{"single, own output", SigHashSingle, changeOutput(0), true},
+		{"single, other output", SigHashSingle, changeOutput(1), false},
+		{"all anyonecanpay, output", SigHashAll | SigHashAnyoneCanPay, changeOutput(1), true},
+		{"all anyonecanpay, input", SigHashAll | SigHashAnyoneCanPay, addInput, false},
+	}
+	for _, test := range tests {
+		changed := DeserializeTransaction(tx.Serialize())
+		test.change(&changed)
+
+		before, err := tx.SignatureHash(0, pubKeyHash, test.hashType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, err := changed.SignatureHash(0, pubKeyHash, test.hashType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if covered := !bytes.Equal(before, after); covered != test.covered {
+			t.Errorf("SignatureHash(%s) covers the change = %v, want %v", test.name, covered, test.covered)
+		}
+	}
+
+	if _, err := tx.SignatureHash(0, pubKeyHash, SigHashType(0x04)); err == nil {
+		t.Error("SignatureHash() of an unknown type is successful")
+	}
+	if _, err := tx.SignatureHash(1, pubKeyHash, SigHashAll); err == nil {
+		t.Error("SignatureHash() of a missing input is successful")
+	}
+}
+
+func TestVerifySigHashTypes(t *testing.T) {
+	w := wallet.NewWallet()
+
+	for _, hashType := range []SigHashType{SigHashAll, SigHashNone, SigHashSingle, SigHashAll | SigHashAnyoneCanPay} {
+		tx, funding := newTestSpend(w, "1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39")
+		prevTXs := map[string]Transaction{hex.EncodeToString(funding.ID): *funding}
+		signWithType(t, w, tx, prevTXs, hashType)
+
+		if ok, err := tx.Verify(prevTXs); !ok {
+			t.Errorf("Verify() with sighash type 0x%x = %v", int(hashType), err)
+		}
+
+		// the type is a part of the signed hash
+		tx.Vin[0].SigHashType = hashType ^ SigHashAnyoneCanPay
+		if ok, _ := tx.Verify(prevTXs); ok {
+			t.Errorf("Verify() with the sighash type changed from 0x%x is successful", int(hashType))
+		}
+	}
+}
@@ -4,10 +4,8 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"io"
 	"log"
 	"math/big"
 	"strings"
@@ -15,9 +13,11 @@ import (
 
 	"wizeBlock/wizeNode/core/crypto"
 	"wizeBlock/wizeNode/core/wallet"
+	"wizeBlock/wizeNode/core/wire"
 )
 
-const subsidy = 0
+// signatureLength is the length of a compact input signature
+const signatureLength = 64
 
 // Transaction represents a Bitcoin transaction
 type Transaction struct {
@@ -29,6 +29,7 @@ type Transaction struct {
 
 type TransactionToSign struct {
 	TxID         []byte
+	SigHashType  SigHashType
 	HashesToSign []string
 }
 
@@ -44,40 +45,69 @@ func (tx Transaction) IsCoinbase() bool {
 
 // Serialize returns a serialized Transaction
 func (tx Transaction) Serialize() []byte {
-	var encoded bytes.Buffer
+	w := wire.NewWriter()
+	tx.encode(w)
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
+	return w.Bytes()
+}
+
+func (tx *Transaction) encode(w *wire.Writer) {
+	w.WriteInt(tx.Timestamp)
+	w.WriteBytes(tx.ID)
+
+	w.WriteCount(len(tx.Vin))
+	for _, vin := range tx.Vin {
+		vin.encode(w)
+	}
+
+	w.WriteCount(len(tx.Vout))
+	for _, vout := range tx.Vout {
+		vout.encode(w)
+	}
+}
+
+func (tx *Transaction) decode(r *wire.Reader) {
+	tx.Timestamp = r.ReadInt()
+	tx.ID = r.ReadBytes()
+
+	tx.Vin = make([]TXInput, r.ReadCount())
+	for i := range tx.Vin {
+		tx.Vin[i].decode(r)
 	}
 
-	return encoded.Bytes()
+	tx.Vout = make([]TXOutput, r.ReadCount())
+	for i := range tx.Vout {
+		tx.Vout[i].decode(r)
+	}
 }
 
 // Hash returns the hash of the Transaction
+// Signatures and sighash types are not hashed, so the ID is known before the inputs are signed
+// and anyone can recompute it from the transaction content
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
 	txCopy := *tx
 	txCopy.ID = []byte{}
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
 
-	salt := make([]byte, 32)
-	_, err := io.ReadFull(rand.Reader, salt)
-	if err != nil {
-		fmt.Printf("ERROR: Creating salt failed: %s\n", err)
+	for i, vin := range tx.Vin {
+		txCopy.Vin[i] = TXInput{vin.Txid, vin.Vout, nil, vin.PubKey, 0}
 	}
 
-	data := txCopy.Serialize()
-	data = append(data, salt...)
-
-	hash = sha256.Sum256(data)
+	hash = sha256.Sum256(txCopy.Serialize())
 
 	return hash[:]
 }
 
-// Sign signs each input of a Transaction
-func (tx *Transaction) PrepareToSign(prevTXs map[string]Transaction) (*TransactionToSign, error) {
+// VerifyID checks whether the ID matches the transaction content
+func (tx *Transaction) VerifyID() bool {
+	return bytes.Compare(tx.ID, tx.Hash()) == 0
+}
+
+// PrepareToSign returns the hashes to be signed for each input of a Transaction
+// The sighash type is stored in the inputs, so it's known when the signatures are added
+func (tx *Transaction) PrepareToSign(prevTXs map[string]Transaction, hashType SigHashType) (*TransactionToSign, error) {
 	if tx.IsCoinbase() {
 		return nil, nil
 	}
@@ -89,29 +119,22 @@ func (tx *Transaction) PrepareToSign(prevTXs map[string]Transaction) (*Transacti
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
 	prepareToSign := TransactionToSign{
 		TxID:         tx.ID,
-		HashesToSign: make([]string, len(txCopy.Vin)),
+		SigHashType:  hashType,
+		HashesToSign: make([]string, len(tx.Vin)),
 	}
 
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
 
-		//// Signing
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-		//fmt.Printf("txCopy: %s\n", txCopy)
-		hashToSign := sha256.Sum256([]byte(dataToSign))
-		//fmt.Printf("hashToSign: %x\n", hashToSign)
-
-		prepareToSign.HashesToSign[inID] = hex.EncodeToString(hashToSign[:])
+		hashToSign, err := tx.SignatureHash(inID, prevTx.Vout[vin.Vout].PubKeyHash, hashType)
+		if err != nil {
+			return nil, err
+		}
 
-		//
-		//tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+		tx.Vin[inID].SigHashType = hashType
+		prepareToSign.HashesToSign[inID] = hex.EncodeToString(hashToSign)
 	}
 
 	return &prepareToSign, nil
@@ -129,10 +152,15 @@ func (tx *Transaction) SignPrepared(txSignatures *TransactionWithSignatures, pre
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
+	if len(txSignatures.Signatures) != len(tx.Vin) {
+		return fmt.Errorf("ERROR: Got %d signatures for %d inputs", len(txSignatures.Signatures), len(tx.Vin))
+	}
 
-	for inID, _ := range txCopy.Vin {
-		signature, _ := hex.DecodeString(txSignatures.Signatures[inID])
+	for inID := range tx.Vin {
+		signature, err := hex.DecodeString(txSignatures.Signatures[inID])
+		if err != nil {
+			return fmt.Errorf("ERROR: Signature %d decoding failed: %s", inID, err)
+		}
 		fmt.Printf("B signature: %x\n", signature)
 		tx.Vin[inID].Signature = signature
 	}
@@ -140,6 +168,7 @@ func (tx *Transaction) SignPrepared(txSignatures *TransactionWithSignatures, pre
 	return nil
 }
 
+// Sign signs each input of a Transaction with SigHashAll
 func (tx *Transaction) Sign(privKey crypto.PrivateKey, prevTXs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
@@ -151,25 +180,21 @@ func (tx *Transaction) Sign(privKey crypto.PrivateKey, prevTXs map[string]Transa
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for inID, vin := range txCopy.Vin {
+	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
 
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
-		hashToSign := sha256.Sum256([]byte(dataToSign))
+		hashToSign, err := tx.SignatureHash(inID, prevTx.Vout[vin.Vout].PubKeyHash, SigHashAll)
+		if err != nil {
+			log.Panic(err)
+		}
 		fmt.Printf("hashToSign: %x\n", hashToSign)
 
-		r, s, err := crypto.Sign(rand.Reader, &privKey, hashToSign[:])
+		r, s, err := crypto.Sign(rand.Reader, &privKey, hashToSign)
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-
-		tx.Vin[inID].Signature = signature
-		txCopy.Vin[inID].PubKey = nil
+		tx.Vin[inID].Signature = crypto.SerializeSignature(r, s)
+		tx.Vin[inID].SigHashType = SigHashAll
 	}
 }
 
@@ -180,16 +205,12 @@ func (tx Transaction) String() string {
 	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
 
 	for i, input := range tx.Vin {
-		pubKeyHash := crypto.HashPubKey(input.PubKey)
-		versionedPayload := append([]byte{crypto.Version}, pubKeyHash...)
-		fullPayload := append(versionedPayload, crypto.Checksum(versionedPayload)...)
-
 		lines = append(lines, fmt.Sprintf("     Input %d:", i))
 		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
 		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
 		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
 		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
-		lines = append(lines, fmt.Sprintf("       Addr  :    %s", crypto.Base58Encode(fullPayload)))
+		lines = append(lines, fmt.Sprintf("       PubKeyHash: %x", crypto.HashPubKey(input.PubKey)))
 	}
 
 	for i, output := range tx.Vout {
@@ -202,25 +223,6 @@ func (tx Transaction) String() string {
 	return strings.Join(lines, "\n")
 }
 
-// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
-func (tx *Transaction) TrimmedCopy() Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
-
-	for _, vin := range tx.Vin {
-		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
-	}
-
-	for _, vout := range tx.Vout {
-		//outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
-		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash, vout.Address})
-	}
-
-	txCopy := Transaction{tx.Timestamp, tx.ID, inputs, outputs}
-
-	return txCopy
-}
-
 // Verify verifies signatures of Transaction inputs
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
 	if tx.IsCoinbase() {
@@ -233,19 +235,30 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
 		}
 	}
 
-	txCopy := tx.TrimmedCopy()
-	//curve := elliptic.P256()
-
 	for inID, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[inID].Signature = nil
-		txCopy.Vin[inID].PubKey = prevTx.Vout[vin.Vout].PubKeyHash
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false, fmt.Errorf("ERROR: Input %d references missing output %d", inID, vin.Vout)
+		}
+		if !vin.UsesKey(prevTx.Vout[vin.Vout].PubKeyHash) {
+			return false, fmt.Errorf("ERROR: Input %d public key does not match the output", inID)
+		}
+		if len(vin.Signature) == 0 || len(vin.PubKey) == 0 {
+			return false, fmt.Errorf("ERROR: Input %d is not signed", inID)
+		}
+		if len(vin.Signature) != signatureLength {
+			return false, fmt.Errorf("ERROR: Input %d signature is %d bytes, want %d", inID, len(vin.Signature), signatureLength)
+		}
+
+		hashToVerify, err := tx.SignatureHash(inID, prevTx.Vout[vin.Vout].PubKeyHash, vin.SigHashType)
+		if err != nil {
+			return false, fmt.Errorf("ERROR: Input %d: %s", inID, err)
+		}
 
 		r := big.Int{}
 		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen / 2)])
-		s.SetBytes(vin.Signature[(sigLen / 2):])
+		r.SetBytes(vin.Signature[:(signatureLength / 2)])
+		s.SetBytes(vin.Signature[(signatureLength / 2):])
 
 		x := big.Int{}
 		y := big.Int{}
@@ -253,23 +266,18 @@ func (tx *Transaction) Verify(prevTXs map[string]Transaction) (bool, error) {
 		x.SetBytes(vin.PubKey[:(keyLen / 2)])
 		y.SetBytes(vin.PubKey[(keyLen / 2):])
 
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-		//fmt.Printf("txCopy: %s\n", txCopy)
-		hashToVerify := sha256.Sum256([]byte(dataToVerify))
-		//fmt.Printf("hashToVerify: %x\n", hashToVerify)
-
 		rawPubKey := crypto.PublicKey{Curve: nil, X: &x, Y: &y}
-		if crypto.Verify(&rawPubKey, hashToVerify[:], &r, &s) == false {
+		if crypto.Verify(&rawPubKey, hashToVerify, &r, &s) == false {
 			return false, fmt.Errorf("ERROR: Verify return false")
 		}
-		txCopy.Vin[inID].PubKey = nil
 	}
 
 	return true, nil
 }
 
 // NewCoinbaseTX creates a new coinbase transaction
-func NewCoinbaseTX(to, data string) *Transaction {
+// The reward is the block subsidy and the fees of the block transactions
+func NewCoinbaseTX(to, data string, reward int) *Transaction {
 	if data == "" {
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
@@ -279,8 +287,8 @@ func NewCoinbaseTX(to, data string) *Transaction {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(subsidy, to)
+	txin := TXInput{[]byte{}, -1, nil, []byte(data), 0}
+	txout := NewTXOutput(reward, to)
 	tx := Transaction{time.Now().UnixNano(), nil, []TXInput{txin}, []TXOutput{*txout}}
 	tx.ID = tx.Hash()
 
@@ -298,7 +306,7 @@ func NewEmissionCoinbaseTX(to, data string, emission int) *Transaction {
 		data = fmt.Sprintf("%x", randData)
 	}
 
-	txin := TXInput{[]byte{}, -1, nil, []byte(data)}
+	txin := TXInput{[]byte{}, -1, nil, []byte(data), 0}
 	txout := NewTXOutput(emission, to)
 	tx := Transaction{time.Now().UnixNano(), nil, []TXInput{txin}, []TXOutput{*txout}}
 	tx.ID = tx.Hash()
@@ -306,21 +314,85 @@ func NewEmissionCoinbaseTX(to, data string, emission int) *Transaction {
 	return &tx
 }
 
+// FeeForSize returns the fee of a transaction of the size with the fee rate per 1000 bytes
+func FeeForSize(feeRate, size int) int {
+	return (feeRate*size + 999) / 1000
+}
+
+// SignedSize returns the size of the serialized transaction with all the inputs signed,
+// so the fee can be calculated before the signatures are known
+func (tx *Transaction) SignedSize() int {
+	size := len(tx.Serialize())
+
+	for _, vin := range tx.Vin {
+		if len(vin.Signature) == 0 {
+			size += signatureLength
+		}
+	}
+
+	return size
+}
+
 // PrepareUTXOTransaction prepare a new transaction
-func PrepareUTXOTransaction(from, to string, amount int, pubKey []byte, UTXOSet *UTXOSet) (*Transaction, *TransactionToSign, error) {
+// The outputs are chosen from the view, so they aren't spent by the pending transactions
+// The transaction pays the fee when it's set, otherwise the fee is calculated with the fee rate
+func PrepareUTXOTransaction(from, to string, amount, fee, feeRate int, pubKey []byte, hashType SigHashType, UTXOView *UTXOView) (*Transaction, *TransactionToSign, error) {
+	tx, err := buildUTXOTransactionWithFee(from, to, amount, fee, feeRate, pubKey, UTXOView)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("tx.ID: %x\n", tx.ID)
+
+	prevTXs, err := UTXOView.prevTransactions(tx)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return nil, nil, err
+	}
+
+	txToSign, err := tx.PrepareToSign(prevTXs, hashType)
+
+	return tx, txToSign, err
+}
+
+// buildUTXOTransactionWithFee creates an unsigned transaction paying the amount and
+// the fee, the fee is calculated with the fee rate when it's not set
+func buildUTXOTransactionWithFee(from, to string, amount, fee, feeRate int, pubKey []byte, UTXOView *UTXOView) (*Transaction, error) {
+	txFee := fee
+
+	// more inputs make the transaction bigger, so the outputs are chosen again
+	// until they cover the fee of the transaction size
+	for {
+		tx, err := buildUTXOTransaction(from, to, amount, txFee, pubKey, UTXOView)
+		if err != nil {
+			return nil, err
+		}
+
+		if fee > 0 || feeRate <= 0 {
+			return tx, nil
+		}
+		required := FeeForSize(feeRate, tx.SignedSize())
+		if required <= txFee {
+			return tx, nil
+		}
+		txFee = required
+	}
+}
+
+// buildUTXOTransaction creates an unsigned transaction paying the amount and the fee
+func buildUTXOTransaction(from, to string, amount, fee int, pubKey []byte, UTXOView *UTXOView) (*Transaction, error) {
 	var inputs []TXInput
 	var outputs []TXOutput
 
 	pubKeyHash := crypto.HashPubKey(pubKey)
 	fmt.Printf("pubKeyHash %x\n", pubKeyHash)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs := UTXOView.FindSpendableOutputs(pubKeyHash, amount+fee)
 
 	// OLDTODO: delete
 	fmt.Printf("Sum of outputs %d\n", acc)
 
-	if acc < amount {
+	if acc < amount+fee {
 		fmt.Println("ERROR: Not enough funds")
-		return nil, nil, fmt.Errorf("ERROR: Not enough funds")
+		return nil, fmt.Errorf("ERROR: Not enough funds")
 	}
 
 	// TODO: find pubKey by pubKeyHash
@@ -332,90 +404,83 @@ func PrepareUTXOTransaction(from, to string, amount int, pubKey []byte, UTXOSet
 		txID, err := hex.DecodeString(txid)
 		if err != nil {
 			fmt.Printf("ERROR: Transaction ID decoding failed: %s\n", err)
-			return nil, nil, fmt.Errorf("ERROR: Transaction ID decoding failed: %s\n", err)
+			return nil, fmt.Errorf("ERROR: Transaction ID decoding failed: %s\n", err)
 		}
 
 		for _, out := range outs {
 			// OLDTODO: delete
 			//fmt.Println("Output", out)
-			input := TXInput{txID, out, nil, pubKey}
+			input := TXInput{txID, out, nil, pubKey, 0}
 			inputs = append(inputs, input)
 		}
 	}
 
-	// Build a list of outputs
+	// Build a list of outputs, the rest of inputs is the fee
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from)) // a change
 	}
 
 	tx := Transaction{time.Now().UnixNano(), nil, inputs, outputs}
 	tx.ID = tx.Hash()
-	fmt.Printf("tx.ID: %x\n", tx.ID)
 
-	txToSign, err := UTXOSet.Blockchain.PrepareTransactionToSign(&tx)
-
-	return &tx, txToSign, err
+	return &tx, nil
 }
 
 // SignUTXOTransaction signs a prepared transaction
-func SignUTXOTransaction(preparedTx *Transaction, txSignatures *TransactionWithSignatures, UTXOSet *UTXOSet) *Transaction {
-	UTXOSet.Blockchain.SignPreparedTransaction(preparedTx, txSignatures)
-	return preparedTx
-}
+func SignUTXOTransaction(preparedTx *Transaction, txSignatures *TransactionWithSignatures, UTXOView *UTXOView) (*Transaction, error) {
+	prevTXs, err := UTXOView.prevTransactions(preparedTx)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return nil, err
+	}
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(walletFrom *wallet.Wallet, to string, amount int, UTXOSet *UTXOSet) *Transaction {
-	var inputs []TXInput
-	var outputs []TXOutput
+	err = preparedTx.SignPrepared(txSignatures, prevTXs)
+	if err != nil {
+		return nil, err
+	}
 
-	pubKeyHash := crypto.HashPubKey(walletFrom.PublicKey)
-	acc, validOutputs := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	return preparedTx, nil
+}
 
-	// OLDTODO: delete
-	//fmt.Printf("Sum of outputs %d\n", acc)
+// NewUTXOTransaction creates a new transaction
+// The outputs are chosen from the view, so they aren't spent by the pending transactions
+// The fee is calculated with the fee rate per 1000 bytes
+func NewUTXOTransaction(walletFrom *wallet.Wallet, to string, amount, feeRate int, UTXOView *UTXOView) *Transaction {
+	from := fmt.Sprintf("%s", walletFrom.GetVersionedAddress(UTXOView.Blockchain.params.AddressVersion))
+	tx, err := buildUTXOTransactionWithFee(from, to, amount, 0, feeRate, walletFrom.PublicKey, UTXOView)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	if acc < amount {
-		log.Panic("ERROR: Not enough funds")
+	prevTXs, err := UTXOView.prevTransactions(tx)
+	if err != nil {
+		log.Panic(err)
 	}
+	tx.Sign(walletFrom.PrivateKey, prevTXs)
 
-	// Build a list of inputs
-	// OLDTODO: rewrite to smart choice of outputs
-	for txid, outs := range validOutputs {
-		txID, err := hex.DecodeString(txid)
-		if err != nil {
-			log.Panic(err)
-		}
+	return tx
+}
 
-		for _, out := range outs {
-			// OLDTODO: delete
-			//fmt.Println("Output", out)
-			input := TXInput{txID, out, nil, walletFrom.PublicKey}
-			inputs = append(inputs, input)
-		}
-	}
+// DecodeTransaction deserializes a transaction and reports malformed data
+func DecodeTransaction(data []byte) (*Transaction, error) {
+	var transaction Transaction
 
-	// Build a list of outputs
-	from := fmt.Sprintf("%s", walletFrom.GetAddress())
-	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from)) // a change
+	err := decode(data, transaction.decode)
+	if err != nil {
+		return nil, err
 	}
 
-	tx := Transaction{time.Now().UnixNano(), nil, inputs, outputs}
-	tx.ID = tx.Hash()
-	UTXOSet.Blockchain.SignTransaction(&tx, walletFrom.PrivateKey)
-
-	return &tx
+	return &transaction, nil
 }
 
 // DeserializeTransaction deserializes a transaction
 func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	transaction, err := DecodeTransaction(data)
 	if err != nil {
 		fmt.Println(err)
+		return Transaction{}
 	}
-	return transaction
+
+	return *transaction
 }
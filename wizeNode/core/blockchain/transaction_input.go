@@ -4,6 +4,7 @@ import (
 	"bytes"
 
 	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/wire"
 )
 
 // TXInput represents a transaction input
@@ -12,6 +13,8 @@ type TXInput struct {
 	Vout      int
 	Signature []byte
 	PubKey    []byte
+	// SigHashType tells which parts of the transaction are covered by the signature
+	SigHashType SigHashType
 }
 
 // UsesKey checks whether the address initiated the transaction
@@ -20,3 +23,19 @@ func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
 
 	return bytes.Compare(lockingHash, pubKeyHash) == 0
 }
+
+func (in *TXInput) encode(w *wire.Writer) {
+	w.WriteBytes(in.Txid)
+	w.WriteInt(int64(in.Vout))
+	w.WriteBytes(in.Signature)
+	w.WriteInt(int64(in.SigHashType))
+	w.WriteBytes(in.PubKey)
+}
+
+func (in *TXInput) decode(r *wire.Reader) {
+	in.Txid = r.ReadBytes()
+	in.Vout = int(r.ReadInt())
+	in.Signature = r.ReadBytes()
+	in.SigHashType = SigHashType(r.ReadInt())
+	in.PubKey = r.ReadBytes()
+}
@@ -2,10 +2,10 @@ package blockchain
 
 import (
 	"bytes"
-	"encoding/gob"
 	"log"
 
 	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/wire"
 )
 
 // TXOutput represents a transaction output
@@ -38,27 +38,97 @@ func NewTXOutput(value int, address string) *TXOutput {
 // TXOutputs collects TXOutput
 type TXOutputs struct {
 	Outputs []TXOutput
+	// Indexes keeps the original position of each output in the transaction's Vout.
+	// Records written before it was introduced are treated as positional
+	Indexes []int
+}
+
+// Index returns the original Vout index of the i-th collected output
+func (outs TXOutputs) Index(i int) int {
+	if i < len(outs.Indexes) {
+		return outs.Indexes[i]
+	}
+
+	return i
+}
+
+// Find returns an unspent output by its original Vout index
+func (outs TXOutputs) Find(vout int) (TXOutput, bool) {
+	for i, out := range outs.Outputs {
+		if outs.Index(i) == vout {
+			return out, true
+		}
+	}
+
+	return TXOutput{}, false
+}
+
+// insert returns a copy of the outputs with one more output at its original index
+func (outs TXOutputs) insert(vout int, output TXOutput) TXOutputs {
+	result := TXOutputs{}
+	inserted := false
+
+	for i, out := range outs.Outputs {
+		outIdx := outs.Index(i)
+		if !inserted && vout < outIdx {
+			result.Outputs = append(result.Outputs, output)
+			result.Indexes = append(result.Indexes, vout)
+			inserted = true
+		}
+		result.Outputs = append(result.Outputs, out)
+		result.Indexes = append(result.Indexes, outIdx)
+	}
+
+	if !inserted {
+		result.Outputs = append(result.Outputs, output)
+		result.Indexes = append(result.Indexes, vout)
+	}
+
+	return result
+}
+
+func (out *TXOutput) encode(w *wire.Writer) {
+	w.WriteInt(int64(out.Value))
+	w.WriteBytes(out.PubKeyHash)
+	w.WriteString(out.Address)
+}
+
+func (out *TXOutput) decode(r *wire.Reader) {
+	out.Value = int(r.ReadInt())
+	out.PubKeyHash = r.ReadBytes()
+	out.Address = r.ReadString()
 }
 
 // Serialize serializes TXOutputs
+// Indexes are always written, so outputs of legacy records get their positions
 func (outs TXOutputs) Serialize() []byte {
-	var buff bytes.Buffer
+	w := wire.NewWriter()
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
+	w.WriteCount(len(outs.Outputs))
+	for i, out := range outs.Outputs {
+		w.WriteInt(int64(outs.Index(i)))
+		out.encode(w)
 	}
 
-	return buff.Bytes()
+	return w.Bytes()
+}
+
+func (outs *TXOutputs) decode(r *wire.Reader) {
+	n := r.ReadCount()
+	outs.Outputs = make([]TXOutput, n)
+	outs.Indexes = make([]int, n)
+
+	for i := 0; i < n; i++ {
+		outs.Indexes[i] = int(r.ReadInt())
+		outs.Outputs[i].decode(r)
+	}
 }
 
 // DeserializeOutputs deserializes TXOutputs
 func DeserializeOutputs(data []byte) TXOutputs {
 	var outputs TXOutputs
 
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&outputs)
+	err := decode(data, outputs.decode)
 	if err != nil {
 		log.Panic(err)
 	}
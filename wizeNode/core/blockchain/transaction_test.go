@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// newTestSpend creates a signed transaction spending the first output of a funding coinbase
+// to the receiver and the change back to the wallet, the funding transaction is returned too
+func newTestSpend(w *wallet.Wallet, receiver string) (*Transaction, *Transaction) {
+	funding := NewCoinbaseTX(string(w.GetAddress()), "funding", 50)
+
+	tx := &Transaction{
+		Timestamp: 1523558612,
+		Vin:       []TXInput{{Txid: funding.ID, Vout: 0, PubKey: w.PublicKey}},
+		Vout:      []TXOutput{*NewTXOutput(10, receiver), *NewTXOutput(40, string(w.GetAddress()))},
+	}
+	tx.ID = tx.Hash()
+	tx.Sign(w.PrivateKey, map[string]Transaction{hex.EncodeToString(funding.ID): *funding})
+
+	return tx, funding
+}
+
+func TestTransactionHash(t *testing.T) {
+	w := wallet.NewWallet()
+	tx, _ := newTestSpend(w, "1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39")
+
+	// the ID doesn't depend on the signatures, they are made after the ID
+	unsigned := *tx
+	unsigned.Vin = []TXInput{{Txid: tx.Vin[0].Txid, Vout: tx.Vin[0].Vout, PubKey: tx.Vin[0].PubKey}}
+	if !bytes.Equal(unsigned.Hash(), tx.ID) {
+		t.Errorf("Hash() of the unsigned transaction = %x, want %x", unsigned.Hash(), tx.ID)
+	}
+	if !tx.VerifyID() {
+		t.Error("VerifyID() of the signed transaction = false")
+	}
+
+	decoded := DeserializeTransaction(tx.Serialize())
+	if !bytes.Equal(decoded.Hash(), tx.ID) || !decoded.VerifyID() {
+		t.Errorf("Hash() of the decoded transaction = %x, want %x", decoded.Hash(), tx.ID)
+	}
+
+	changed := DeserializeTransaction(tx.Serialize())
+	changed.Vout[0].Value++
+	if changed.VerifyID() {
+		t.Error("VerifyID() of the changed transaction = true")
+	}
+
+	// the coinbases of the same address differ by their data
+	first := NewCoinbaseTX(string(w.GetAddress()), "", 50)
+	second := NewCoinbaseTX(string(w.GetAddress()), "", 50)
+	if bytes.Equal(first.ID, second.ID) {
+		t.Errorf("NewCoinbaseTX() IDs are equal: %x", first.ID)
+	}
+}
+
+func TestDecodeTransaction(t *testing.T) {
+	tx := NewCoinbaseTX("1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39", "test", 10)
+
+	decoded, err := DecodeTransaction(tx.Serialize())
+	if err != nil || !bytes.Equal(decoded.ID, tx.ID) {
+		t.Errorf("DecodeTransaction() = %v, %v, want %x", decoded, err, tx.ID)
+	}
+
+	if _, err := DecodeTransaction(tx.Serialize()[:10]); err == nil {
+		t.Error("DecodeTransaction() of truncated data is successful")
+	}
+}
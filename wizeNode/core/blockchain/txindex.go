@@ -0,0 +1,161 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+
+	"wizeBlock/wizeNode/core/wire"
+)
+
+const txIndexBucket = "txindex"
+
+// ErrTxNotFound is returned for a transaction which isn't in the main chain
+var ErrTxNotFound = errors.New("Transaction is not found")
+
+// TxLocation is the place of a main chain transaction
+type TxLocation struct {
+	BlockHash []byte
+	// Position is the index of the transaction in the block
+	Position int
+}
+
+// Serialize serializes TxLocation
+func (l TxLocation) Serialize() []byte {
+	w := wire.NewWriter()
+	w.WriteBytes(l.BlockHash)
+	w.WriteInt(int64(l.Position))
+
+	return w.Bytes()
+}
+
+// DeserializeTxLocation deserializes TxLocation
+func DeserializeTxLocation(data []byte) (TxLocation, error) {
+	var location TxLocation
+
+	r, err := wire.NewReader(data)
+	if err != nil {
+		return location, err
+	}
+	location.BlockHash = r.ReadBytes()
+	location.Position = int(r.ReadInt())
+
+	return location, r.Close()
+}
+
+// indexTransactions adds the transactions of a block connected to the main chain to the index
+func indexTransactions(dbtx *bolt.Tx, block *Block) error {
+	b, err := dbtx.CreateBucketIfNotExists([]byte(txIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	for i, tx := range block.Transactions {
+		err = b.Put(tx.ID, TxLocation{block.Hash, i}.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unindexTransactions removes the transactions of a block disconnected from the main chain
+func unindexTransactions(dbtx *bolt.Tx, block *Block) error {
+	b := dbtx.Bucket([]byte(txIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	for _, tx := range block.Transactions {
+		err := b.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasTxIndex checks whether the transaction index is built
+// The blockchains created before the index was added don't have it
+func (bc *Blockchain) hasTxIndex() bool {
+	exists := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		exists = tx.Bucket([]byte(txIndexBucket)) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return exists
+}
+
+// ReindexTransactions rebuilds the transaction index from the main chain blocks
+func (bc *Blockchain) ReindexTransactions() error {
+	return bc.Db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(txIndexBucket))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		for hash := bc.tip; len(hash) > 0; {
+			data := b.Get(hash)
+			if data == nil {
+				return fmt.Errorf("Block %x is not found", hash)
+			}
+
+			block, err := DecodeBlock(data)
+			if err != nil {
+				return err
+			}
+
+			err = indexTransactions(tx, block)
+			if err != nil {
+				return err
+			}
+			hash = block.PrevBlockHash
+		}
+
+		// the bucket of a chain without transactions marks the index as built
+		_, err = tx.CreateBucketIfNotExists([]byte(txIndexBucket))
+		return err
+	})
+}
+
+// findTxLocation returns the location of a main chain transaction
+func (bc *Blockchain) findTxLocation(ID []byte) (TxLocation, error) {
+	var location TxLocation
+	found := false
+
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(txIndexBucket))
+		if b == nil {
+			return nil
+		}
+
+		data := b.Get(ID)
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		location, err = DeserializeTxLocation(data)
+		found = err == nil
+
+		return err
+	})
+	if err != nil {
+		return location, err
+	}
+	if !found {
+		return location, ErrTxNotFound
+	}
+
+	return location, nil
+}
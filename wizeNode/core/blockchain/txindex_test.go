@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+func TestTxLocationSerialize(t *testing.T) {
+	location := TxLocation{BlockHash: []byte{1, 2, 3}, Position: 7}
+
+	got, err := DeserializeTxLocation(location.Serialize())
+	if err != nil || !bytes.Equal(got.BlockHash, location.BlockHash) || got.Position != location.Position {
+		t.Errorf("DeserializeTxLocation() = %v, %v, want %v", got, err, location)
+	}
+
+	if _, err := DeserializeTxLocation([]byte{1, 2, 3}); err == nil {
+		t.Error("DeserializeTxLocation() of bad data is successful")
+	}
+}
+
+func TestFindTransactionReorganize(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	receiver := string(wallet.NewWallet().GetAddress())
+	genesis := tipBlock(t, bc)
+
+	tx := NewUTXOTransaction(w, receiver, 10, 0, NewUTXOView(bc, nil))
+	main1 := newTestBlock(bc, genesis, address, tx)
+	if err := bc.AddBlock(main1); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := bc.FindTransaction(tx.ID)
+	if err != nil || !bytes.Equal(found.ID, tx.ID) {
+		t.Fatalf("FindTransaction() = %x, %v, want %x", found.ID, err, tx.ID)
+	}
+	if location, _ := bc.findTxLocation(tx.ID); !bytes.Equal(location.BlockHash, main1.Hash) || location.Position != 1 {
+		t.Errorf("findTxLocation() = %x:%d, want %x:1", location.BlockHash, location.Position, main1.Hash)
+	}
+
+	// the side branch becomes the main chain
+	side1 := newTestBlock(bc, genesis, receiver)
+	side2 := newTestBlock(bc, side1, receiver)
+	for _, block := range []*Block{side1, side2} {
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := bc.FindTransaction(tx.ID); err != ErrTxNotFound {
+		t.Errorf("FindTransaction() of the disconnected transaction error = %v, want %v", err, ErrTxNotFound)
+	}
+	if _, err := bc.FindTransaction(main1.Transactions[0].ID); err != ErrTxNotFound {
+		t.Errorf("FindTransaction() of the disconnected coinbase error = %v, want %v", err, ErrTxNotFound)
+	}
+	if _, err := bc.FindTransaction(side2.Transactions[0].ID); err != nil {
+		t.Errorf("FindTransaction() of the connected coinbase error = %v", err)
+	}
+
+	// and the old branch comes back
+	main2 := newTestBlock(bc, main1, address)
+	main3 := newTestBlock(bc, main2, address)
+	for _, block := range []*Block{main2, main3} {
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := bc.FindTransaction(tx.ID); err != nil {
+		t.Errorf("FindTransaction() of the reconnected transaction error = %v", err)
+	}
+	if _, err := bc.FindTransaction(side1.Transactions[0].ID); err != ErrTxNotFound {
+		t.Errorf("FindTransaction() of the disconnected coinbase error = %v, want %v", err, ErrTxNotFound)
+	}
+}
+
+func TestReindexTransactions(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	genesis := tipBlock(t, bc)
+	newTestChain(t, bc, address, 1)
+
+	// the side branch replaces the first block
+	side1 := newTestBlock(bc, genesis, address)
+	side2 := newTestBlock(bc, side1, address)
+	for _, block := range []*Block{side1, side2} {
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(bc.GetTip(), side2.Hash) {
+		t.Fatalf("GetTip() = %x, want %x", bc.GetTip(), side2.Hash)
+	}
+
+	// the index kept by connecting and disconnecting the blocks
+	want := fmt.Sprint(bucketSnapshot(t, bc, txIndexBucket))
+
+	// the reindex command
+	if err := bc.ReindexTransactions(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(bucketSnapshot(t, bc, txIndexBucket)); got != want {
+		t.Errorf("ReindexTransactions() index = %s, want %s", got, want)
+	}
+
+	// the rebuild of a reorganization without the undo data
+	if err := bc.rebuildAt(bc.tip); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(bucketSnapshot(t, bc, txIndexBucket)); got != want {
+		t.Errorf("rebuildAt() index = %s, want %s", got, want)
+	}
+}
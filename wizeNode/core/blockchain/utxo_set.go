@@ -32,7 +32,8 @@ func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[s
 
 			fmt.Printf("txID: %s, outs: %d\n", txID, len(outs.Outputs))
 
-			for outIdx, out := range outs.Outputs {
+			for i, out := range outs.Outputs {
+				outIdx := outs.Index(i)
 				fmt.Printf("outIdx: %d, value: %d\n", outIdx, out.Value)
 
 				// OLDTODO: rewrite to smart choice of outputs
@@ -81,6 +82,29 @@ func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput {
 	return UTXOs
 }
 
+// FindOutput returns an unspent output by transaction ID and its original Vout index
+func (u UTXOSet) FindOutput(txID []byte, vout int) (TXOutput, bool) {
+	var output TXOutput
+	found := false
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		outsBytes := b.Get(txID)
+		if outsBytes == nil {
+			return nil
+		}
+
+		output, found = DeserializeOutputs(outsBytes).Find(vout)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return output, found
+}
+
 // CountTransactions returns the number of transactions in the UTXO set
 func (u UTXOSet) CountTransactions() int {
 	db := u.Blockchain.Db
@@ -148,54 +172,139 @@ func (u UTXOSet) Reindex() {
 
 // Update updates the UTXO set with transactions from the Block
 // The Block is considered to be the tip of a blockchain
+// Spent outputs are saved as undo data of the block, see Revert
 func (u UTXOSet) Update(block *Block) {
 	db := u.Blockchain.Db
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
+		return u.update(tx, block)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
 
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updatedOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIdx, out := range outs.Outputs {
-						if outIdx != vin.Vout {
-							updatedOuts.Outputs = append(updatedOuts.Outputs, out)
-						}
-					}
+func (u UTXOSet) update(dbtx *bolt.Tx, block *Block) error {
+	b := dbtx.Bucket([]byte(utxoBucket))
+	undo := BlockUndo{}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, vin := range tx.Vin {
+				updatedOuts := TXOutputs{}
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return fmt.Errorf("Outputs of %x are not found in UTXO set", vin.Txid)
+				}
+				outs := DeserializeOutputs(outsBytes)
 
-					if len(updatedOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
+				for i, out := range outs.Outputs {
+					outIdx := outs.Index(i)
+					if outIdx != vin.Vout {
+						updatedOuts.Outputs = append(updatedOuts.Outputs, out)
+						updatedOuts.Indexes = append(updatedOuts.Indexes, outIdx)
 					} else {
-						err := b.Put(vin.Txid, updatedOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
+						undo.SpentOutputs = append(undo.SpentOutputs, SpentOutput{vin.Txid, outIdx, out})
 					}
+				}
 
+				if len(updatedOuts.Outputs) == 0 {
+					err := b.Delete(vin.Txid)
+					if err != nil {
+						return err
+					}
+				} else {
+					err := b.Put(vin.Txid, updatedOuts.Serialize())
+					if err != nil {
+						return err
+					}
 				}
-			}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
 			}
+		}
 
-			err := b.Put(tx.ID, newOutputs.Serialize())
-			if err != nil {
-				log.Panic(err)
-			}
+		newOutputs := TXOutputs{}
+		for outIdx, out := range tx.Vout {
+			newOutputs.Outputs = append(newOutputs.Outputs, out)
+			newOutputs.Indexes = append(newOutputs.Indexes, outIdx)
 		}
 
-		return nil
+		err := b.Put(tx.ID, newOutputs.Serialize())
+		if err != nil {
+			return err
+		}
+	}
+
+	ub, err := dbtx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+
+	return ub.Put(block.Hash, undo.Serialize())
+}
+
+// Revert rolls back the UTXO set changes made by the Block
+// The Block is considered to be the tip of a blockchain
+func (u UTXOSet) Revert(block *Block) error {
+	db := u.Blockchain.Db
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return u.revert(tx, block)
 	})
+}
+
+func (u UTXOSet) revert(dbtx *bolt.Tx, block *Block) error {
+	b := dbtx.Bucket([]byte(utxoBucket))
+	ub := dbtx.Bucket([]byte(undoBucket))
+
+	var undoData []byte
+	if ub != nil {
+		undoData = ub.Get(block.Hash)
+	}
+	if undoData == nil {
+		return fmt.Errorf("Undo data of block %x is not found", block.Hash)
+	}
+
+	undo, err := DeserializeBlockUndo(undoData)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+
+	spent := make(map[string]SpentOutput)
+	for _, spentOut := range undo.SpentOutputs {
+		spent[fmt.Sprintf("%x:%d", spentOut.Txid, spentOut.Index)] = spentOut
 	}
+
+	// later transactions can spend outputs of earlier ones in the same block
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+
+		err := b.Delete(tx.ID)
+		if err != nil {
+			return err
+		}
+
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			spentOut, ok := spent[fmt.Sprintf("%x:%d", vin.Txid, vin.Vout)]
+			if !ok {
+				return fmt.Errorf("Undo data of block %x has no output %x:%d", block.Hash, vin.Txid, vin.Vout)
+			}
+
+			outs := TXOutputs{}
+			if outsBytes := b.Get(vin.Txid); outsBytes != nil {
+				outs = DeserializeOutputs(outsBytes)
+			}
+
+			err := b.Put(vin.Txid, outs.insert(spentOut.Index, spentOut.Output).Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return ub.Delete(block.Hash)
 }
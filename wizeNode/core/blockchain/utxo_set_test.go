@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// utxoSnapshot returns the UTXO set records in hex
+func utxoSnapshot(t *testing.T, bc *Blockchain) map[string]string {
+	return bucketSnapshot(t, bc, utxoBucket)
+}
+
+// bucketSnapshot returns the records of the bucket in hex
+func bucketSnapshot(t *testing.T, bc *Blockchain, bucket string) map[string]string {
+	snapshot := make(map[string]string)
+	err := bc.Db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+			snapshot[hex.EncodeToString(k)] = hex.EncodeToString(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return snapshot
+}
+
+func TestBlockUndoSerialize(t *testing.T) {
+	undo := BlockUndo{SpentOutputs: []SpentOutput{
+		{Txid: []byte{1, 2}, Index: 3, Output: *NewTXOutput(10, "1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39")},
+		{Txid: []byte{4}, Index: 0, Output: *NewTXOutput(0, "1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39")},
+	}}
+
+	got, err := DeserializeBlockUndo(undo.Serialize())
+	if err != nil {
+		t.Fatalf("DeserializeBlockUndo() error = %v", err)
+	}
+	if !bytes.Equal(got.Serialize(), undo.Serialize()) {
+		t.Errorf("DeserializeBlockUndo() = %v, want %v", got, undo)
+	}
+
+	if _, err := DeserializeBlockUndo(undo.Serialize()[:10]); err == nil {
+		t.Error("DeserializeBlockUndo() of truncated data is successful")
+	}
+}
+
+func TestUTXOSetRevert(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	receiver := wallet.NewWallet()
+
+	var blocks []*Block
+	var snapshots []map[string]string
+	for _, spend := range []func() *Transaction{
+		func() *Transaction {
+			return NewUTXOTransaction(w, string(receiver.GetAddress()), 10, 0, NewUTXOView(bc, nil))
+		},
+		func() *Transaction {
+			return NewUTXOTransaction(receiver, address, 3, 0, NewUTXOView(bc, nil))
+		},
+	} {
+		snapshots = append(snapshots, utxoSnapshot(t, bc))
+
+		block := newTestBlock(bc, tipBlock(t, bc), address, spend())
+		if err := bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if err := bc.disconnectBlock(blocks[i]); err != nil {
+			t.Fatalf("disconnectBlock(%d) error = %v", blocks[i].Height, err)
+		}
+
+		got := utxoSnapshot(t, bc)
+		if fmt.Sprint(got) != fmt.Sprint(snapshots[i]) {
+			t.Errorf("UTXO set after disconnecting block %d = %v, want %v", blocks[i].Height, got, snapshots[i])
+		}
+	}
+	if got := bc.GetBalance(string(receiver.GetAddress())); got != 0 {
+		t.Errorf("GetBalance() = %d after disconnecting the blocks, want 0", got)
+	}
+
+	// a block without undo data can't be disconnected
+	if err := (UTXOSet{bc}).Revert(tipBlock(t, bc)); err == nil {
+		t.Error("Revert() of the genesis block is successful")
+	}
+}
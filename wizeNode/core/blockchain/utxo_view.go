@@ -0,0 +1,187 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// PendingTransactions are the unconfirmed transactions applied on top of the UTXO set
+type PendingTransactions interface {
+	Get(txID []byte) (*Transaction, bool)
+	IsSpent(txID []byte, vout int) bool
+	Transactions() []*Transaction
+}
+
+// UTXOView is the UTXO set with the pending transactions applied: the outputs they spend
+// are excluded and the outputs they create can be spent
+// Without pending transactions it's the same as the UTXO set
+type UTXOView struct {
+	UTXOSet
+	Pending PendingTransactions
+}
+
+// NewUTXOView creates a view of the blockchain UTXO set and the pending transactions,
+// pending can be nil
+func NewUTXOView(bc *Blockchain, pending PendingTransactions) *UTXOView {
+	return &UTXOView{UTXOSet{bc}, pending}
+}
+
+// unspentOutput is an output available to be spent in the view
+type unspentOutput struct {
+	txID      []byte
+	vout      int
+	output    TXOutput
+	confirmed bool
+	// immature coinbase outputs are counted in the balance, but can't be spent yet
+	immature bool
+}
+
+// unspentOutputs returns the outputs locked with the key, confirmed outputs go first
+func (v *UTXOView) unspentOutputs(pubKeyHash []byte) []unspentOutput {
+	return v.applyPending(v.confirmedOutputs(pubKeyHash), pubKeyHash)
+}
+
+// confirmedOutputs returns the outputs of the UTXO set locked with the key
+func (v *UTXOView) confirmedOutputs(pubKeyHash []byte) []unspentOutput {
+	var unspent []unspentOutput
+	immature := v.Blockchain.ImmatureCoinbases()
+
+	err := v.Blockchain.Db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		c := b.Cursor()
+
+		for k, val := c.First(); k != nil; k, val = c.Next() {
+			outs := DeserializeOutputs(val)
+
+			for i, out := range outs.Outputs {
+				if !out.IsLockedWithKey(pubKeyHash) {
+					continue
+				}
+
+				txID := append([]byte{}, k...)
+				unspent = append(unspent, unspentOutput{txID, outs.Index(i), out, true, immature[hex.EncodeToString(k)]})
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return unspent
+}
+
+// applyPending excludes the confirmed outputs spent by the pending transactions
+// and adds the unspent outputs of the pending transactions locked with the key
+func (v *UTXOView) applyPending(confirmed []unspentOutput, pubKeyHash []byte) []unspentOutput {
+	if v.Pending == nil {
+		return confirmed
+	}
+
+	var available []unspentOutput
+	for _, u := range confirmed {
+		if !v.Pending.IsSpent(u.txID, u.vout) {
+			available = append(available, u)
+		}
+	}
+
+	for _, tx := range v.Pending.Transactions() {
+		for vout, out := range tx.Vout {
+			if out.IsLockedWithKey(pubKeyHash) && !v.Pending.IsSpent(tx.ID, vout) {
+				available = append(available, unspentOutput{tx.ID, vout, out, false, false})
+			}
+		}
+	}
+
+	return available
+}
+
+// FindSpendableOutputs finds outputs to reference in inputs, the outputs spent by
+// the pending transactions and the immature coinbase outputs are skipped,
+// the new outputs of the pending transactions are used when the confirmed ones are not enough
+func (v *UTXOView) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+
+	for _, u := range v.unspentOutputs(pubKeyHash) {
+		if accumulated >= amount {
+			break
+		}
+		if u.immature {
+			continue
+		}
+
+		txID := hex.EncodeToString(u.txID)
+		accumulated += u.output.Value
+		unspentOutputs[txID] = append(unspentOutputs[txID], u.vout)
+	}
+
+	return accumulated, unspentOutputs
+}
+
+// Balance returns the confirmed balance of the key and the change made by the pending transactions
+func (v *UTXOView) Balance(pubKeyHash []byte) (confirmed int, pending int) {
+	confirmedOutputs := v.confirmedOutputs(pubKeyHash)
+	for _, u := range confirmedOutputs {
+		confirmed += u.output.Value
+	}
+
+	available := 0
+	for _, u := range v.applyPending(confirmedOutputs, pubKeyHash) {
+		available += u.output.Value
+	}
+
+	return confirmed, available - confirmed
+}
+
+// FindTransaction finds a pending or a confirmed transaction by its ID
+func (v *UTXOView) FindTransaction(ID []byte) (Transaction, error) {
+	if v.Pending != nil {
+		if tx, ok := v.Pending.Get(ID); ok {
+			return *tx, nil
+		}
+	}
+
+	return v.Blockchain.FindTransaction(ID)
+}
+
+// prevTransactions returns the transactions referenced by the inputs
+func (v *UTXOView) prevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+
+	for _, vin := range tx.Vin {
+		prevTX, err := v.FindTransaction(vin.Txid)
+		if err != nil {
+			return nil, fmt.Errorf("Cant find transaction: %s", err)
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	return prevTXs, nil
+}
+
+// Fee returns the value of the transaction inputs not spent by its outputs
+func (v *UTXOView) Fee(tx *Transaction) (int, error) {
+	prevTXs, err := v.prevTransactions(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	fee := 0
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return 0, fmt.Errorf("Output %d of transaction %x is not found", vin.Vout, vin.Txid)
+		}
+		fee += prevTx.Vout[vin.Vout].Value
+	}
+	for _, out := range tx.Vout {
+		fee -= out.Value
+	}
+
+	return fee, nil
+}
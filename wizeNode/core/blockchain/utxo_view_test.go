@@ -0,0 +1,108 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// testPending are the pending transactions of a view in the tests
+type testPending struct {
+	txs []*Transaction
+}
+
+func (p *testPending) Get(txID []byte) (*Transaction, bool) {
+	for _, tx := range p.txs {
+		if bytes.Equal(tx.ID, txID) {
+			return tx, true
+		}
+	}
+
+	return nil, false
+}
+
+func (p *testPending) IsSpent(txID []byte, vout int) bool {
+	for _, tx := range p.txs {
+		for _, vin := range tx.Vin {
+			if vin.Vout == vout && bytes.Equal(vin.Txid, txID) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *testPending) Transactions() []*Transaction {
+	return p.txs
+}
+
+func TestUTXOViewPendingSpent(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	pending := &testPending{}
+	view := NewUTXOView(bc, pending)
+	pubKeyHash := crypto.HashPubKey(w.PublicKey)
+	receiver := wallet.NewWallet()
+
+	confirmed, _ := view.Balance(pubKeyHash)
+
+	tx := NewUTXOTransaction(w, string(receiver.GetAddress()), 10, 0, view)
+	pending.txs = append(pending.txs, tx)
+
+	// the genesis output is spent in the mempool, only the change can be spent
+	if gotConfirmed, gotPending := view.Balance(pubKeyHash); gotConfirmed != confirmed || gotPending != -10 {
+		t.Errorf("Balance() = %d, %d, want %d, -10", gotConfirmed, gotPending, confirmed)
+	}
+	accumulated, outputs := view.FindSpendableOutputs(pubKeyHash, 5)
+	if accumulated != confirmed-10 || fmt.Sprint(outputs) != fmt.Sprintf("map[%x:[1]]", tx.ID) {
+		t.Errorf("FindSpendableOutputs() = %d, %v, want the change of %d", accumulated, outputs, confirmed-10)
+	}
+
+	next := NewUTXOTransaction(w, string(receiver.GetAddress()), 5, 0, view)
+	for _, vin := range next.Vin {
+		if pending.IsSpent(vin.Txid, vin.Vout) {
+			t.Errorf("NewUTXOTransaction() spends output %x:%d of a pending transaction", vin.Txid, vin.Vout)
+		}
+	}
+}
+
+func TestUTXOViewPendingOutputs(t *testing.T) {
+	bc, w, done := newTestBlockchain(t)
+	defer done()
+
+	pending := &testPending{}
+	view := NewUTXOView(bc, pending)
+	receiver := wallet.NewWallet()
+	receiverHash := crypto.HashPubKey(receiver.PublicKey)
+
+	tx := NewUTXOTransaction(w, string(receiver.GetAddress()), 10, 0, view)
+	pending.txs = append(pending.txs, tx)
+
+	if confirmed, unconfirmed := view.Balance(receiverHash); confirmed != 0 || unconfirmed != 10 {
+		t.Errorf("Balance() = %d, %d, want 0, 10", confirmed, unconfirmed)
+	}
+
+	// the receiver spends the unconfirmed output with the change back to it
+	spend := NewUTXOTransaction(receiver, string(w.GetAddress()), 4, 0, view)
+	if len(spend.Vin) != 1 || !bytes.Equal(spend.Vin[0].Txid, tx.ID) {
+		t.Fatalf("NewUTXOTransaction() inputs = %v, want the pending output", spend.Vin)
+	}
+	pending.txs = append(pending.txs, spend)
+
+	if _, unconfirmed := view.Balance(receiverHash); unconfirmed != 6 {
+		t.Errorf("Balance() pending = %d after the spend, want 6", unconfirmed)
+	}
+	if accumulated, _ := view.FindSpendableOutputs(receiverHash, 6); accumulated != 6 {
+		t.Errorf("FindSpendableOutputs() = %d, want the pending change of 6", accumulated)
+	}
+
+	// without the pending transactions the view is the UTXO set
+	if confirmed, unconfirmed := NewUTXOView(bc, nil).Balance(receiverHash); confirmed != 0 || unconfirmed != 0 {
+		t.Errorf("Balance() without pending = %d, %d, want 0, 0", confirmed, unconfirmed)
+	}
+}
@@ -0,0 +1,135 @@
+package chaincfg
+
+import (
+	"fmt"
+	"math/big"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/crypto"
+	"wizeBlock/wizeNode/core/network"
+)
+
+// ChainParams define a network: the blockchain rules, the magic of the messages,
+// the default ports, the address version and the directory of the node files
+type ChainParams struct {
+	Name string
+
+	// Params are the genesis block, the address version, the difficulty rules and the subsidy schedule
+	blockchain.Params
+
+	// Magic starts every network message, the nodes of other networks are not connected
+	Magic uint32
+	// DefaultPort is the node port, it's the node ID when the ID isn't set
+	DefaultPort int
+	// DefaultAPIPort is the port of the REST service
+	DefaultAPIPort int
+	// DataDir is the directory of the blockchain, the wallets and the node lists
+	DataDir string
+
+	// GenerateOnDemand allows to generate blocks by request, it's meant for integration testing
+	GenerateOnDemand bool
+}
+
+// MainNet is the main network
+var MainNet = ChainParams{
+	Name:   "mainnet",
+	Params: blockchain.DefaultParams,
+
+	Magic:          network.NetworkMagic,
+	DefaultPort:    3000,
+	DefaultAPIPort: 4000,
+	DataDir:        "files/",
+}
+
+// TestNet is the public test network, it has the rules of the main network
+// with another genesis block
+var TestNet = ChainParams{
+	Name:   "testnet",
+	Params: testNetParams(),
+
+	Magic:          0x545a4957,
+	DefaultPort:    13000,
+	DefaultAPIPort: 14000,
+	DataDir:        "files/testnet/",
+}
+
+// RegTest is the local network for testing, the blocks are solved right away
+// and are generated by request
+var RegTest = ChainParams{
+	Name:   "regtest",
+	Params: regTestParams(),
+
+	Magic:          0x525a4957,
+	DefaultPort:    23000,
+	DefaultAPIPort: 24000,
+	DataDir:        "files/regtest/",
+
+	GenerateOnDemand: true,
+}
+
+var networks = map[string]*ChainParams{
+	MainNet.Name: &MainNet,
+	TestNet.Name: &TestNet,
+	RegTest.Name: &RegTest,
+}
+
+// testAddressVersion is the address version of the test networks
+const testAddressVersion = 0x6f
+
+func testNetParams() blockchain.Params {
+	params := blockchain.DefaultParams
+	params.AddressVersion = testAddressVersion
+	params.Genesis = blockchain.Genesis{
+		Address:      "mj6yJZdzpsqsJX8z9CokSFsCbx8KW1xTXu",
+		CoinbaseData: "WizeBlock testnet genesis",
+		Reward:       1000000,
+		Timestamp:    1523558612,
+		Bits:         params.Genesis.Bits,
+		Nonce:        17413,
+		Hash:         "0000c671d2bb99d85090076a41bacedfb98fd2825c47c448ed2447985223e05c",
+	}
+
+	return params
+}
+
+func regTestParams() blockchain.Params {
+	// the highest target, half of the hashes solve a block
+	powLimit := new(big.Int).Lsh(big.NewInt(1), 255)
+
+	params := blockchain.DefaultParams
+	params.AddressVersion = testAddressVersion
+	params.Genesis = blockchain.Genesis{
+		Address:      "mj6yJZdzpsqsJX8z9CokSFsCbx8KW1xTXu",
+		CoinbaseData: "WizeBlock regtest genesis",
+		Reward:       1000000,
+		Timestamp:    1523558612,
+		Bits:         blockchain.BigToCompact(powLimit),
+		Nonce:        1,
+		Hash:         "74b0d8fe691f7eebf034ded53221c55264adced18be3a3f1d12ffe215be817b0",
+	}
+	params.PowLimit = powLimit
+	params.RetargetInterval = 0
+	params.SubsidyHalvingInterval = 150
+
+	return params
+}
+
+// Get returns the network by its name
+func Get(name string) (*ChainParams, error) {
+	params, ok := networks[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown network %s", name)
+	}
+
+	return params, nil
+}
+
+// ValidateAddress checks the address is valid in the network
+func (params *ChainParams) ValidateAddress(address string) bool {
+	return crypto.ValidateAddressVersion(address, params.AddressVersion)
+}
+
+// InitialNodesFile returns the file of the initial nodes of the network
+func (params *ChainParams) InitialNodesFile() string {
+	return params.DataDir + network.InitialNodesFile
+}
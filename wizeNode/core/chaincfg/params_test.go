@@ -0,0 +1,55 @@
+package chaincfg
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+func TestGet(t *testing.T) {
+	for _, name := range []string{"mainnet", "testnet", "regtest"} {
+		params, err := Get(name)
+		if err != nil || params.Name != name {
+			t.Errorf("Get(%s) = %v, %v", name, params, err)
+		}
+	}
+
+	if _, err := Get("unknown"); err == nil {
+		t.Error("Get() of an unknown network is successful")
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	magics := make(map[uint32]string)
+	for name, params := range networks {
+		if other, ok := magics[params.Magic]; ok {
+			t.Errorf("%s has the magic of %s", name, other)
+		}
+		magics[params.Magic] = name
+
+		if blockchain.CompactToBig(params.Genesis.Bits).Cmp(params.PowLimit) > 0 {
+			t.Errorf("%s genesis bits %08x are above the limit", name, params.Genesis.Bits)
+		}
+		if !params.ValidateAddress(params.Genesis.Address) {
+			t.Errorf("%s genesis address %s is not valid in the network", name, params.Genesis.Address)
+		}
+	}
+}
+
+func TestGenesis(t *testing.T) {
+	for name, params := range networks {
+		genesis := params.Genesis.Block()
+
+		if hash := fmt.Sprintf("%x", genesis.Hash); hash != params.Genesis.Hash {
+			t.Errorf("%s genesis hash = %s, want %s", name, hash, params.Genesis.Hash)
+		}
+		if !blockchain.NewProofOfWork(&genesis.BlockHeader).Validate() {
+			t.Errorf("%s genesis block doesn't solve the proof-of-work", name)
+		}
+		if !bytes.Equal(genesis.Hash, params.GenesisHash()) {
+			t.Errorf("%s GenesisHash() = %x, want %x", name, params.GenesisHash(), genesis.Hash)
+		}
+	}
+}
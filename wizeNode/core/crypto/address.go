@@ -10,7 +10,9 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
+// Version is the first byte of the main network addresses
 const Version = byte(0x00)
+
 const addressChecksumLen = 4
 
 // NewKeyPair
@@ -22,7 +24,7 @@ func NewKeyPair() (*PrivateKey, []byte) {
 		fmt.Printf("Cant generate keys: %s", err)
 		return nil, nil
 	}
-	pubKey := append(privKey.PublicKey.X.Bytes(), privKey.PublicKey.Y.Bytes()...)
+	pubKey := SerializePublicKey(&privKey.PublicKey)
 
 	return privKey, pubKey
 }
@@ -34,7 +36,12 @@ func GetAddress(pubKey []byte) []byte {
 }
 
 func GetAddressFromPubKeyHash(pubKeyHash []byte) []byte {
-	versionedPayload := append([]byte{Version}, pubKeyHash...)
+	return EncodeAddress(Version, pubKeyHash)
+}
+
+// EncodeAddress returns the address of the public key hash with the version of a network
+func EncodeAddress(version byte, pubKeyHash []byte) []byte {
+	versionedPayload := append([]byte{version}, pubKeyHash...)
 	checksum := Checksum(versionedPayload)
 
 	fullPayload := append(versionedPayload, checksum...)
@@ -66,6 +73,12 @@ func ValidateAddress(address string) bool {
 	return bytes.Compare(actualChecksum, targetChecksum) == 0
 }
 
+// ValidateAddressVersion checks the address and its version,
+// the addresses of other networks are not valid
+func ValidateAddressVersion(address string, version byte) bool {
+	return ValidateAddress(address) && Base58Decode([]byte(address))[0] == version
+}
+
 func GetPubKeyHash(address string) []byte {
 	fullPayload := Base58Decode([]byte(address))
 	pubKeyHash := fullPayload[1 : len(fullPayload)-addressChecksumLen]
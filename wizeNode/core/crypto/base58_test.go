@@ -41,3 +41,14 @@ func TestBase58IfPubKeyHashStartsWith00(t *testing.T) {
 		"Address: %s is invalid", address,
 	)
 }
+
+func TestAddressVersion(t *testing.T) {
+	_, public := NewKeyPair()
+	mainAddress := GetAddress(public)
+
+	address := EncodeAddress(0x6f, HashPubKey(public))
+	assert.True(t, ValidateAddressVersion(string(address), 0x6f), "Address: %s is invalid", address)
+	assert.Equal(t, HashPubKey(public), GetPubKeyHash(string(address)))
+	assert.False(t, ValidateAddressVersion(string(mainAddress), 0x6f), "Address: %s of another network is valid", mainAddress)
+	assert.True(t, ValidateAddressVersion(string(mainAddress), Version), "Address: %s is invalid", mainAddress)
+}
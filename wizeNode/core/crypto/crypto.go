@@ -29,6 +29,33 @@ type PrivateKey struct {
 	D *big.Int
 }
 
+// keyCoordLen is the length of a private key, a public key coordinate
+// and each half of a compact signature
+const keyCoordLen = 32
+
+// SerializePublicKey returns the 64 byte X and Y coordinates of a public key
+func SerializePublicKey(pub *PublicKey) []byte {
+	return append(paddedBytes(pub.X, keyCoordLen), paddedBytes(pub.Y, keyCoordLen)...)
+}
+
+// SerializeSignature returns the 64 byte compact form of a signature,
+// big.Int drops leading zeros so r and s are padded to 32 bytes each
+func SerializeSignature(r, s *big.Int) []byte {
+	return append(paddedBytes(r, keyCoordLen), paddedBytes(s, keyCoordLen)...)
+}
+
+// paddedBytes returns n as big-endian bytes left padded with zeros to size
+func paddedBytes(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
 // TODO: more random?
 func rand32() [32]byte {
 	key := [32]byte{}
@@ -144,11 +171,10 @@ func Sign(rand io.Reader, priv *PrivateKey, hash []byte) (r, s *big.Int, err err
 
 	publicKey := make([]byte, 1)
 	publicKey[0] = 0x04
-	publicKey = append(publicKey, priv.PublicKey.X.Bytes()...)
-	publicKey = append(publicKey, priv.PublicKey.Y.Bytes()...)
+	publicKey = append(publicKey, SerializePublicKey(&priv.PublicKey)...)
 	//log.Printf("Public Key: %s\n", hex.EncodeToString(publicKey))
 
-	privateKey := priv.D.Bytes()
+	privateKey := paddedBytes(priv.D, keyCoordLen)
 	_, ecdsaSignature, err := secp256k1.EcdsaSign(ctx, hash, privateKey)
 	if err != nil {
 		return nil, nil, err
@@ -192,7 +218,7 @@ func Verify(pub *PublicKey, hash []byte, r, s *big.Int) bool {
 	}
 	//log.Printf("%+v\n", ctx)
 
-	signature := append(r.Bytes(), s.Bytes()...)
+	signature := SerializeSignature(r, s)
 	log.Info.Printf("Signature Compact: %s\n", hex.EncodeToString(signature[:]))
 	_, ecdsaSignature, err := secp256k1.EcdsaSignatureParseCompact(ctx, signature)
 	if err != nil {
@@ -202,8 +228,7 @@ func Verify(pub *PublicKey, hash []byte, r, s *big.Int) bool {
 
 	publicKey := make([]byte, 1)
 	publicKey[0] = 0x04
-	publicKey = append(publicKey, pub.X.Bytes()...)
-	publicKey = append(publicKey, pub.Y.Bytes()...)
+	publicKey = append(publicKey, SerializePublicKey(pub)...)
 	log.Info.Printf("Public Key: %s\n", hex.EncodeToString(publicKey))
 	_, publicKeyStruct, err := secp256k1.EcPubkeyParse(ctx, publicKey)
 	if err != nil {
@@ -1,8 +1,10 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/sha256"
+	"math/big"
 	"testing"
 )
 
@@ -64,3 +66,45 @@ func TestSignAndVerify(t *testing.T) {
 		t.Errorf("Verify Failed")
 	}
 }
+
+func TestSerializeSignature(t *testing.T) {
+	r := big.NewInt(0x0102)
+	s := new(big.Int).SetBytes(bytes.Repeat([]byte{0xff}, 32))
+
+	signature := SerializeSignature(r, s)
+	if len(signature) != 64 {
+		t.Fatalf("len(SerializeSignature()) = %d, want 64", len(signature))
+	}
+
+	want := make([]byte, 32)
+	want[30], want[31] = 0x01, 0x02
+	if !bytes.Equal(signature[:32], want) {
+		t.Errorf("SerializeSignature() r = %x, want %x", signature[:32], want)
+	}
+	if !bytes.Equal(signature[32:], s.Bytes()) {
+		t.Errorf("SerializeSignature() s = %x, want %x", signature[32:], s.Bytes())
+	}
+}
+
+func TestVerifyShortSignatureHalves(t *testing.T) {
+	private, err := GenerateKey(nil, rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	// about one in 64 signatures has a half with a leading zero byte
+	for i := 0; i < 256; i++ {
+		hash := sha256.Sum256([]byte{byte(i), byte(i >> 8)})
+
+		r, s, err := Sign(rand.Reader, private, hash[:])
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		if len(SerializeSignature(r, s)) != 64 {
+			t.Fatalf("len(SerializeSignature()) = %d, want 64", len(SerializeSignature(r, s)))
+		}
+		if !Verify(&private.PublicKey, hash[:], r, s) {
+			t.Fatalf("Verify() = false for r = %x, s = %x", r.Bytes(), s.Bytes())
+		}
+	}
+}
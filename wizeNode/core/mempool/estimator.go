@@ -0,0 +1,175 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+const (
+	// MaxEstimateBlocks is the biggest number of blocks a fee can be estimated for
+	MaxEstimateBlocks = 25
+
+	// feeRateBuckets is the number of fee rate ranges, every next one starts
+	// from the twice bigger fee rate
+	feeRateBuckets = 24
+	// estimatorDecay reduces the weight of the older confirmations with every block
+	estimatorDecay = 0.998
+	// estimateSuccessThreshold is the part of transactions which must be confirmed
+	// in time for the fee rate to be estimated
+	estimateSuccessThreshold = 0.85
+	// estimateSufficientTxs is the weight of transactions needed for an estimate
+	estimateSufficientTxs = 1.0
+)
+
+type trackedTx struct {
+	height int
+	bucket int
+}
+
+// FeeEstimator estimates the fee rate needed for a transaction to be mined
+// in a number of blocks. It tracks how many blocks the pool transactions wait
+// for a confirmation in every fee rate range. The transactions still waiting
+// in the pool longer than the number of blocks count as not confirmed in time
+type FeeEstimator struct {
+	mu     sync.Mutex
+	height int
+	// tracked are the pool transactions by their IDs
+	tracked map[string]trackedTx
+	// confirmed is the weight of transactions confirmed in every bucket
+	confirmed []float64
+	// confirmedIn is the weight of transactions confirmed in every bucket
+	// in the number of blocks, the index is the number of blocks minus one
+	confirmedIn [][]float64
+}
+
+// NewFeeEstimator creates an estimator without any data for the blockchain of the height
+func NewFeeEstimator(height int) *FeeEstimator {
+	e := &FeeEstimator{
+		height:      height,
+		tracked:     make(map[string]trackedTx),
+		confirmed:   make([]float64, feeRateBuckets),
+		confirmedIn: make([][]float64, MaxEstimateBlocks),
+	}
+	for i := range e.confirmedIn {
+		e.confirmedIn[i] = make([]float64, feeRateBuckets)
+	}
+
+	return e
+}
+
+// bucketFeeRate returns the lowest fee rate of the bucket
+func bucketFeeRate(bucket int) int {
+	return 1 << uint(bucket)
+}
+
+// feeRateBucket returns the bucket of the fee rate
+func feeRateBucket(feeRate int) int {
+	bucket := 0
+	for bucket < feeRateBuckets-1 && bucketFeeRate(bucket+1) <= feeRate {
+		bucket++
+	}
+
+	return bucket
+}
+
+// added starts tracking a new pool transaction
+func (e *FeeEstimator) added(txID string, fee, size int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	feeRate := fee * 1000 / size
+	e.tracked[txID] = trackedTx{e.height, feeRateBucket(feeRate)}
+}
+
+// removed stops tracking a transaction removed from the pool without a confirmation
+func (e *FeeEstimator) removed(txID string) {
+	e.mu.Lock()
+	delete(e.tracked, txID)
+	e.mu.Unlock()
+}
+
+// blockConnected records the number of blocks the pool transactions of the block waited
+func (e *FeeEstimator) blockConnected(block *blockchain.Block) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// the blocks of a reorganization up to the known height don't make the transactions older
+	if block.Height <= e.height {
+		return
+	}
+	e.height = block.Height
+
+	for bucket := range e.confirmed {
+		e.confirmed[bucket] *= estimatorDecay
+		for blocks := range e.confirmedIn {
+			e.confirmedIn[blocks][bucket] *= estimatorDecay
+		}
+	}
+
+	for _, tx := range block.Transactions {
+		txID := hex.EncodeToString(tx.ID)
+		tracked, ok := e.tracked[txID]
+		if !ok {
+			continue
+		}
+		delete(e.tracked, txID)
+
+		blocks := e.height - tracked.height
+		if blocks < 1 {
+			blocks = 1
+		}
+
+		e.confirmed[tracked.bucket]++
+		for i := blocks - 1; i < MaxEstimateBlocks; i++ {
+			e.confirmedIn[i][tracked.bucket]++
+		}
+	}
+}
+
+// Estimate returns the lowest fee rate per 1000 bytes with which most transactions
+// are confirmed in the number of blocks
+// False is returned when there isn't enough data for the estimate
+func (e *FeeEstimator) Estimate(blocks int) (int, bool) {
+	if blocks < 1 {
+		blocks = 1
+	}
+	if blocks > MaxEstimateBlocks {
+		blocks = MaxEstimateBlocks
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	waiting := make([]float64, feeRateBuckets)
+	for _, tracked := range e.tracked {
+		if e.height-tracked.height >= blocks {
+			waiting[tracked.bucket]++
+		}
+	}
+
+	// the buckets are grouped from the highest fee rate until the group has
+	// enough transactions, the lowest bucket of the last successful group is the estimate
+	estimate := -1
+	total, inTime := 0.0, 0.0
+	for bucket := feeRateBuckets - 1; bucket >= 0; bucket-- {
+		total += e.confirmed[bucket] + waiting[bucket]
+		inTime += e.confirmedIn[blocks-1][bucket]
+
+		if total < estimateSufficientTxs {
+			continue
+		}
+		if inTime/total < estimateSuccessThreshold {
+			break
+		}
+
+		estimate = bucket
+		total, inTime = 0, 0
+	}
+
+	if estimate < 0 {
+		return 0, false
+	}
+	return bucketFeeRate(estimate), true
+}
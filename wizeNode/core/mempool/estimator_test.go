@@ -0,0 +1,70 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+func TestFeeRateBucket(t *testing.T) {
+	tests := []struct {
+		feeRate int
+		want    int
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 1},
+		{3, 1},
+		{1000, 9},
+		{1 << 30, feeRateBuckets - 1},
+	}
+
+	for _, test := range tests {
+		if got := feeRateBucket(test.feeRate); got != test.want {
+			t.Errorf("feeRateBucket(%d) = %d, want %d", test.feeRate, got, test.want)
+		}
+	}
+}
+
+func TestFeeEstimator(t *testing.T) {
+	e := NewFeeEstimator(10)
+
+	if _, ok := e.Estimate(1); ok {
+		t.Error("Estimate() without data is successful")
+	}
+
+	// the transactions paying 1 wait for 3 blocks, the ones paying 8 are mined in the next block
+	low := func(i int) *blockchain.Transaction { return &blockchain.Transaction{ID: []byte{0, byte(i)}} }
+	high := func(i int) *blockchain.Transaction { return &blockchain.Transaction{ID: []byte{1, byte(i)}} }
+	height := 10
+	for i := 0; i < 20; i++ {
+		e.added(hex.EncodeToString(low(i).ID), 1, 1000)
+		e.added(hex.EncodeToString(high(i).ID), 8, 1000)
+
+		height++
+		block := &blockchain.Block{BlockHeader: blockchain.BlockHeader{Height: height}}
+		block.Transactions = []*blockchain.Transaction{high(i)}
+		if i >= 2 {
+			block.Transactions = append(block.Transactions, low(i-2))
+		}
+		e.blockConnected(block)
+	}
+
+	tests := []struct {
+		blocks int
+		want   int
+	}{
+		{1, 8},
+		{2, 8},
+		{3, 1},
+		{MaxEstimateBlocks + 1, 1},
+	}
+
+	for _, test := range tests {
+		feeRate, ok := e.Estimate(test.blocks)
+		if !ok || feeRate != test.want {
+			t.Errorf("Estimate(%d) = %d, %t, want %d", test.blocks, feeRate, ok, test.want)
+		}
+	}
+}
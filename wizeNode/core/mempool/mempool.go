@@ -0,0 +1,730 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/log"
+)
+
+const (
+	// DefaultMaxTransactions is the default limit of transactions in the pool
+	DefaultMaxTransactions = 5000
+	// DefaultMaxSize is the default limit of the serialized transactions size in bytes
+	DefaultMaxSize = 32 * 1024 * 1024
+	// DefaultExpiry is the default time a transaction can wait in the pool to be mined
+	DefaultExpiry = 72 * time.Hour
+	// MaxTransactionSize is the biggest serialized transaction accepted into the pool
+	MaxTransactionSize = 100 * 1024
+	// DefaultMinRelayFeeRate is the default lowest fee per 1000 bytes accepted into the pool
+	DefaultMinRelayFeeRate = 1
+	// DefaultBlockSize is the default size of the transactions selected for a block
+	DefaultBlockSize = 1024 * 1024
+
+	// expireInterval is how often expired transactions are removed
+	expireInterval = 10 * time.Minute
+	// snapshotInterval is how often the pool is saved into the storage
+	snapshotInterval = 5 * time.Minute
+)
+
+// TxErrorCode identifies the reason a transaction is not accepted into the pool
+type TxErrorCode int
+
+const (
+	ErrTxInvalid TxErrorCode = iota
+	ErrTxDuplicate
+	ErrTxDoubleSpend
+	ErrTxMissingInputs
+	ErrTxPoolFull
+	ErrTxLowFee
+)
+
+var txErrorCodeStrings = map[TxErrorCode]string{
+	ErrTxInvalid:       "invalid",
+	ErrTxDuplicate:     "duplicate",
+	ErrTxDoubleSpend:   "double spend",
+	ErrTxMissingInputs: "missing inputs",
+	ErrTxPoolFull:      "pool is full",
+	ErrTxLowFee:        "insufficient fee",
+}
+
+func (c TxErrorCode) String() string {
+	if s, ok := txErrorCodeStrings[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("unknown (%d)", int(c))
+}
+
+// TxError is returned when a transaction is not accepted into the pool
+// Only ErrTxInvalid means the transaction breaks the rules, the other codes
+// depend on the state of the pool and the blockchain
+type TxError struct {
+	Code        TxErrorCode
+	TxID        []byte
+	Description string
+}
+
+func (e *TxError) Error() string {
+	return fmt.Sprintf("Transaction %x rejected (%s): %s", e.TxID, e.Code, e.Description)
+}
+
+func txError(txID []byte, code TxErrorCode, format string, args ...interface{}) *TxError {
+	return &TxError{
+		Code:        code,
+		TxID:        txID,
+		Description: fmt.Sprintf(format, args...),
+	}
+}
+
+// Chain is the confirmed state the transactions are validated against
+type Chain interface {
+	FindTransaction(ID []byte) (blockchain.Transaction, error)
+	FindOutput(txID []byte, vout int) (blockchain.TXOutput, bool)
+	ImmatureCoinbases() map[string]bool
+}
+
+// StoredTransaction is a pool transaction with the time it was added
+type StoredTransaction struct {
+	Tx    *blockchain.Transaction
+	Added time.Time
+}
+
+// Interface for storage of the pool transactions, so they survive node restarts
+// Save replaces all the stored transactions
+type Storage interface {
+	Load() ([]StoredTransaction, error)
+	Save(txs []StoredTransaction) error
+}
+
+type entry struct {
+	tx    *blockchain.Transaction
+	size  int
+	fee   int
+	added time.Time
+	// seq is the order of adding, a transaction returned to the pool from
+	// a disconnected block can be the parent of transactions with lower values
+	seq uint64
+}
+
+// Mempool keeps valid transactions waiting to be mined
+// Transactions may spend outputs of other pool transactions, but an output can be
+// spent only once. When the limits are reached the transactions paying the lowest
+// fee rate are evicted together with their descendants
+// The fee of a transaction is the value of its inputs not spent by the outputs
+type Mempool struct {
+	chain     Chain
+	storage   Storage
+	estimator *FeeEstimator
+
+	MaxTransactions int
+	MaxSize         int
+	Expiry          time.Duration
+	MinRelayFeeRate int
+
+	mu    sync.RWMutex
+	txs   map[string]*entry
+	spent map[string]string
+	size  int
+	seq   uint64
+	// the fee and the size of the last evicted package, transactions paying
+	// the same rate or less are not accepted until the next block
+	evictedFee  int
+	evictedSize int
+}
+
+// New creates an empty pool with the default limits
+func New(chain Chain) *Mempool {
+	return &Mempool{
+		chain:           chain,
+		MaxTransactions: DefaultMaxTransactions,
+		MaxSize:         DefaultMaxSize,
+		Expiry:          DefaultExpiry,
+		MinRelayFeeRate: DefaultMinRelayFeeRate,
+		txs:             make(map[string]*entry),
+		spent:           make(map[string]string),
+	}
+}
+
+func outpoint(txID []byte, vout int) string {
+	return fmt.Sprintf("%x:%d", txID, vout)
+}
+
+// SetStorage sets the storage used by Load and Save
+func (mp *Mempool) SetStorage(storage Storage) {
+	mp.storage = storage
+}
+
+// SetFeeEstimator sets the estimator tracking the confirmations of the pool transactions
+func (mp *Mempool) SetFeeEstimator(estimator *FeeEstimator) {
+	mp.estimator = estimator
+}
+
+// Add validates the transaction and adds it to the pool
+// A *TxError is returned when the transaction is not accepted
+func (mp *Mempool) Add(tx *blockchain.Transaction) error {
+	return mp.add(tx, time.Now())
+}
+
+func (mp *Mempool) add(tx *blockchain.Transaction, added time.Time) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	size, fee, err := mp.check(tx)
+	if err != nil {
+		return err
+	}
+
+	txID := hex.EncodeToString(tx.ID)
+	mp.seq++
+	mp.txs[txID] = &entry{tx: tx, size: size, fee: fee, added: added, seq: mp.seq}
+	mp.size += size
+	for _, vin := range tx.Vin {
+		mp.spent[outpoint(vin.Txid, vin.Vout)] = txID
+	}
+	if mp.estimator != nil {
+		mp.estimator.added(txID, fee, size)
+	}
+
+	log.Debug.Printf("Added to pool %d Tx: [%s]\n", len(mp.txs), txID)
+
+	mp.limit()
+	if _, ok := mp.txs[txID]; !ok {
+		return txError(tx.ID, ErrTxPoolFull, "evicted because of the pool limits")
+	}
+
+	return nil
+}
+
+// check validates the transaction against the blockchain and the pool transactions
+// The size and the fee of the transaction are returned
+func (mp *Mempool) check(tx *blockchain.Transaction) (int, int, error) {
+	txID := hex.EncodeToString(tx.ID)
+
+	if _, ok := mp.txs[txID]; ok {
+		return 0, 0, txError(tx.ID, ErrTxDuplicate, "already in the pool")
+	}
+	if tx.IsCoinbase() {
+		return 0, 0, txError(tx.ID, ErrTxInvalid, "coinbase transaction")
+	}
+	if !tx.VerifyID() {
+		return 0, 0, txError(tx.ID, ErrTxInvalid, "wrong ID")
+	}
+
+	size := len(tx.Serialize())
+	if size > MaxTransactionSize {
+		return 0, 0, txError(tx.ID, ErrTxInvalid, "size %d is over the limit %d", size, MaxTransactionSize)
+	}
+
+	if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+		return 0, 0, txError(tx.ID, ErrTxInvalid, "no inputs or outputs")
+	}
+	outputValue := 0
+	for _, out := range tx.Vout {
+		if out.Value < 0 {
+			return 0, 0, txError(tx.ID, ErrTxInvalid, "negative output")
+		}
+		outputValue += out.Value
+	}
+
+	inputValue := 0
+	inputs := make(map[string]bool)
+	prevTXs := make(map[string]blockchain.Transaction)
+	immature := mp.chain.ImmatureCoinbases()
+	for _, vin := range tx.Vin {
+		prevTxID := hex.EncodeToString(vin.Txid)
+		op := outpoint(vin.Txid, vin.Vout)
+
+		if inputs[op] {
+			return 0, 0, txError(tx.ID, ErrTxInvalid, "spends %s twice", op)
+		}
+		inputs[op] = true
+
+		if spender, ok := mp.spent[op]; ok {
+			return 0, 0, txError(tx.ID, ErrTxDoubleSpend, "%s is already spent by %s", op, spender)
+		}
+
+		if parent, ok := mp.txs[prevTxID]; ok {
+			if vin.Vout < 0 || vin.Vout >= len(parent.tx.Vout) {
+				return 0, 0, txError(tx.ID, ErrTxInvalid, "spends missing output %s", op)
+			}
+			inputValue += parent.tx.Vout[vin.Vout].Value
+			prevTXs[prevTxID] = *parent.tx
+			continue
+		}
+
+		out, ok := mp.chain.FindOutput(vin.Txid, vin.Vout)
+		if !ok {
+			return 0, 0, txError(tx.ID, ErrTxMissingInputs, "%s is unknown or spent", op)
+		}
+		// the coinbase can be spent after a few more blocks
+		if immature[prevTxID] {
+			return 0, 0, txError(tx.ID, ErrTxMissingInputs, "%s is an immature coinbase output", op)
+		}
+		inputValue += out.Value
+
+		if _, ok := prevTXs[prevTxID]; !ok {
+			prevTx, err := mp.chain.FindTransaction(vin.Txid)
+			if err != nil {
+				return 0, 0, txError(tx.ID, ErrTxMissingInputs, "%s", err)
+			}
+			prevTXs[prevTxID] = prevTx
+		}
+	}
+
+	if inputValue < outputValue {
+		return 0, 0, txError(tx.ID, ErrTxInvalid, "spends %d but has only %d", outputValue, inputValue)
+	}
+
+	fee := inputValue - outputValue
+	if minFee := blockchain.FeeForSize(mp.MinRelayFeeRate, size); fee < minFee {
+		return 0, 0, txError(tx.ID, ErrTxLowFee, "fee %d is lower than %d", fee, minFee)
+	}
+	if mp.evictedSize > 0 && fee*mp.evictedSize <= mp.evictedFee*size {
+		return 0, 0, txError(tx.ID, ErrTxLowFee, "fee %d for %d bytes is not higher than the rate of the evicted transactions", fee, size)
+	}
+
+	check, err := tx.Verify(prevTXs)
+	if err != nil || !check {
+		return 0, 0, txError(tx.ID, ErrTxInvalid, "invalid signature: %v", err)
+	}
+
+	return size, fee, nil
+}
+
+// limit evicts the transactions while the pool is over the limits
+// A transaction is evicted together with its descendants, so the fee rate of
+// this package is compared, and the package with the lowest rate goes first
+func (mp *Mempool) limit() {
+	for len(mp.txs) > mp.MaxTransactions || mp.size > mp.MaxSize {
+		var worst *entry
+		worstFee, worstSize := 0, 0
+		for _, e := range mp.txs {
+			fee, size := mp.descendantsFee(e)
+
+			// fee rates are compared without division
+			a, b := fee*worstSize, worstFee*size
+			if worst == nil || a < b || (a == b && e.seq < worst.seq) {
+				worst, worstFee, worstSize = e, fee, size
+			}
+		}
+
+		removed := mp.remove(hex.EncodeToString(worst.tx.ID), true)
+		log.Debug.Printf("Evicted %d transactions from the pool", removed)
+
+		if mp.evictedSize == 0 || worstFee*mp.evictedSize > mp.evictedFee*worstSize {
+			mp.evictedFee, mp.evictedSize = worstFee, worstSize
+		}
+	}
+}
+
+// descendantsFee returns the total fee and size of the entry and the pool transactions
+// spending its outputs directly or through other pool transactions
+func (mp *Mempool) descendantsFee(e *entry) (int, int) {
+	fee, size := 0, 0
+
+	visited := make(map[string]bool)
+	queue := []*entry{e}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+
+		fee += e.fee
+		size += e.size
+		for i := range e.tx.Vout {
+			spender, ok := mp.spent[outpoint(e.tx.ID, i)]
+			if ok && !visited[spender] {
+				visited[spender] = true
+				queue = append(queue, mp.txs[spender])
+			}
+		}
+	}
+
+	return fee, size
+}
+
+// remove deletes the transaction from the pool, the transactions spending
+// its outputs are deleted too when withDescendants is set
+// The number of removed transactions is returned
+func (mp *Mempool) remove(txID string, withDescendants bool) int {
+	e, ok := mp.txs[txID]
+	if !ok {
+		return 0
+	}
+
+	delete(mp.txs, txID)
+	mp.size -= e.size
+	for _, vin := range e.tx.Vin {
+		delete(mp.spent, outpoint(vin.Txid, vin.Vout))
+	}
+	if mp.estimator != nil {
+		mp.estimator.removed(txID)
+	}
+
+	removed := 1
+	if withDescendants {
+		for i := range e.tx.Vout {
+			if spender, ok := mp.spent[outpoint(e.tx.ID, i)]; ok {
+				removed += mp.remove(spender, true)
+			}
+		}
+	}
+
+	return removed
+}
+
+// Remove deletes the transaction and the transactions spending its outputs
+func (mp *Mempool) Remove(txID []byte) {
+	mp.mu.Lock()
+	mp.remove(hex.EncodeToString(txID), true)
+	mp.mu.Unlock()
+}
+
+// RemoveForBlock deletes the transactions of a new main chain block and
+// the pool transactions which conflict with them
+func (mp *Mempool) RemoveForBlock(block *blockchain.Block) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if mp.estimator != nil {
+		mp.estimator.blockConnected(block)
+	}
+
+	// the block frees space in the pool
+	mp.evictedFee, mp.evictedSize = 0, 0
+
+	// the children of confirmed transactions stay valid
+	for _, tx := range block.Transactions {
+		mp.remove(hex.EncodeToString(tx.ID), false)
+	}
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		for _, vin := range tx.Vin {
+			spender, ok := mp.spent[outpoint(vin.Txid, vin.Vout)]
+			if !ok {
+				continue
+			}
+
+			removed := mp.remove(spender, true)
+			log.Debug.Printf("Removed %d transactions conflicting with Tx [%x]", removed, tx.ID)
+		}
+	}
+}
+
+// RemoveInvalid deletes the transactions spending outputs which are neither in the main chain
+// nor in the pool anymore, together with their descendants
+// After a reorganization the pool can keep the children of the transactions which were
+// not returned and the spends of the disconnected coinbases
+// The number of removed transactions is returned
+func (mp *Mempool) RemoveInvalid() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	immature := mp.chain.ImmatureCoinbases()
+	invalid := []string{}
+	for txID, e := range mp.txs {
+		for _, vin := range e.tx.Vin {
+			prevTxID := hex.EncodeToString(vin.Txid)
+			if _, ok := mp.txs[prevTxID]; ok {
+				continue
+			}
+
+			if _, ok := mp.chain.FindOutput(vin.Txid, vin.Vout); !ok || immature[prevTxID] {
+				invalid = append(invalid, txID)
+				break
+			}
+		}
+	}
+
+	removed := 0
+	for _, txID := range invalid {
+		removed += mp.remove(txID, true)
+	}
+
+	return removed
+}
+
+// Expire deletes the transactions waiting in the pool longer than Expiry
+// The number of removed transactions is returned
+func (mp *Mempool) Expire() int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	expired := []string{}
+	for txID, e := range mp.txs {
+		if time.Since(e.added) > mp.Expiry {
+			expired = append(expired, txID)
+		}
+	}
+
+	removed := 0
+	for _, txID := range expired {
+		removed += mp.remove(txID, true)
+	}
+
+	return removed
+}
+
+// Load adds the transactions from the storage to the pool
+// The transactions are validated again, the invalid and expired ones are skipped
+// The number of added transactions is returned
+func (mp *Mempool) Load() (int, error) {
+	if mp.storage == nil {
+		return 0, nil
+	}
+
+	stored, err := mp.storage.Load()
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, st := range stored {
+		if time.Since(st.Added) > mp.Expiry {
+			continue
+		}
+
+		err := mp.add(st.Tx, st.Added)
+		if err != nil {
+			log.Debug.Printf("Stored Tx [%x] is skipped: %s", st.Tx.ID, err)
+			continue
+		}
+		added++
+	}
+
+	return added, nil
+}
+
+// Save replaces the transactions in the storage with the pool transactions
+func (mp *Mempool) Save() error {
+	if mp.storage == nil {
+		return nil
+	}
+
+	mp.mu.RLock()
+	entries := mp.ordered()
+	mp.mu.RUnlock()
+
+	stored := make([]StoredTransaction, len(entries))
+	for i, e := range entries {
+		stored[i] = StoredTransaction{e.tx, e.added}
+	}
+
+	return mp.storage.Save(stored)
+}
+
+// Start removes expired transactions and saves the pool into the storage periodically
+// until the stop channel is closed
+func (mp *Mempool) Start(stop <-chan struct{}) {
+	expireTicker := time.NewTicker(expireInterval)
+	defer expireTicker.Stop()
+	snapshotTicker := time.NewTicker(snapshotInterval)
+	defer snapshotTicker.Stop()
+
+	for {
+		select {
+		case <-expireTicker.C:
+			removed := mp.Expire()
+			if removed > 0 {
+				log.Info.Printf("Removed %d expired transactions from the pool", removed)
+			}
+		case <-snapshotTicker.C:
+			err := mp.Save()
+			if err != nil {
+				log.Warn.Printf("Failed with saving the mempool: %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Get returns the pool transaction
+func (mp *Mempool) Get(txID []byte) (*blockchain.Transaction, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	e, ok := mp.txs[hex.EncodeToString(txID)]
+	if !ok {
+		return nil, false
+	}
+
+	return e.tx, true
+}
+
+// Has checks whether the transaction is in the pool
+func (mp *Mempool) Has(txID []byte) bool {
+	_, ok := mp.Get(txID)
+	return ok
+}
+
+// IsSpent checks whether a pool transaction spends the output
+func (mp *Mempool) IsSpent(txID []byte, vout int) bool {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	_, ok := mp.spent[outpoint(txID, vout)]
+	return ok
+}
+
+// Count returns the number of transactions in the pool
+func (mp *Mempool) Count() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return len(mp.txs)
+}
+
+// Size returns the total size of the serialized pool transactions
+func (mp *Mempool) Size() int {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	return mp.size
+}
+
+// Transactions returns the pool transactions, parents go before their children,
+// so the list can be put into a block as is
+func (mp *Mempool) Transactions() []*blockchain.Transaction {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	entries := mp.ordered()
+	txs := make([]*blockchain.Transaction, len(entries))
+	for i, e := range entries {
+		txs[i] = e.tx
+	}
+
+	return txs
+}
+
+// BlockTemplate selects the pool transactions for a new block of the size,
+// the transactions paying more per byte go first. A transaction is selected together
+// with the pool transactions it spends, so the list can be put into a block as is
+// The selected transactions and the sum of their fees are returned
+func (mp *Mempool) BlockTemplate(maxSize int) ([]*blockchain.Transaction, int) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	// a child paying a high fee pulls its parents into the block,
+	// so the transactions are sorted once by the fee rate with their ancestors
+	type candidate struct {
+		ancestors []*entry
+		fee       int
+		size      int
+		seq       uint64
+	}
+	candidates := make([]candidate, 0, len(mp.txs))
+	for _, e := range mp.txs {
+		c := candidate{ancestors: mp.ancestors(e), seq: e.seq}
+		for _, a := range c.ancestors {
+			c.fee += a.fee
+			c.size += a.size
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		// fee rates are compared without division
+		a, b := candidates[i].fee*candidates[j].size, candidates[j].fee*candidates[i].size
+		if a != b {
+			return a > b
+		}
+		return candidates[i].seq < candidates[j].seq
+	})
+
+	var txs []*blockchain.Transaction
+	selected := make(map[string]bool)
+	fees := 0
+	size := 0
+	for _, c := range candidates {
+		var pkg []*entry
+		pkgSize := 0
+		for _, a := range c.ancestors {
+			if !selected[hex.EncodeToString(a.tx.ID)] {
+				pkg = append(pkg, a)
+				pkgSize += a.size
+			}
+		}
+		if len(pkg) == 0 || size+pkgSize > maxSize {
+			continue
+		}
+
+		for _, a := range pkg {
+			txs = append(txs, a.tx)
+			selected[hex.EncodeToString(a.tx.ID)] = true
+			fees += a.fee
+		}
+		size += pkgSize
+	}
+
+	return txs, fees
+}
+
+// ancestors returns the entry and the pool transactions it spends directly or
+// through other pool transactions, parents go before their children
+func (mp *Mempool) ancestors(e *entry) []*entry {
+	var ancestors []*entry
+	visited := make(map[string]bool)
+
+	var visit func(e *entry)
+	visit = func(e *entry) {
+		txID := hex.EncodeToString(e.tx.ID)
+		if visited[txID] {
+			return
+		}
+		visited[txID] = true
+
+		for _, vin := range e.tx.Vin {
+			if parent, ok := mp.txs[hex.EncodeToString(vin.Txid)]; ok {
+				visit(parent)
+			}
+		}
+		ancestors = append(ancestors, e)
+	}
+	visit(e)
+
+	return ancestors
+}
+
+// ordered returns the pool entries with parents before their children
+func (mp *Mempool) ordered() []*entry {
+	entries := make([]*entry, 0, len(mp.txs))
+	for _, e := range mp.txs {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+
+	// a transaction returned to the pool from a disconnected block can be
+	// the parent of older transactions
+	ordered := make([]*entry, 0, len(entries))
+	added := make(map[string]bool)
+	var visit func(e *entry)
+	visit = func(e *entry) {
+		txID := hex.EncodeToString(e.tx.ID)
+		if added[txID] {
+			return
+		}
+		added[txID] = true
+
+		for _, vin := range e.tx.Vin {
+			if parent, ok := mp.txs[hex.EncodeToString(vin.Txid)]; ok {
+				visit(parent)
+			}
+		}
+		ordered = append(ordered, e)
+	}
+	for _, e := range entries {
+		visit(e)
+	}
+
+	return ordered
+}
@@ -0,0 +1,365 @@
+package mempool
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+type testChain struct {
+	txs      map[string]blockchain.Transaction
+	utxos    map[string]blockchain.TXOutput
+	immature map[string]bool
+}
+
+func (c *testChain) FindTransaction(ID []byte) (blockchain.Transaction, error) {
+	tx, ok := c.txs[hex.EncodeToString(ID)]
+	if !ok {
+		return blockchain.Transaction{}, errors.New("Transaction is not found")
+	}
+	return tx, nil
+}
+
+func (c *testChain) FindOutput(txID []byte, vout int) (blockchain.TXOutput, bool) {
+	out, ok := c.utxos[outpoint(txID, vout)]
+	return out, ok
+}
+
+func (c *testChain) ImmatureCoinbases() map[string]bool {
+	return c.immature
+}
+
+// newTestChain creates a chain with a transaction paying the wallet outputs of value 10
+func newTestChain(w *wallet.Wallet, outputs int) (*testChain, *blockchain.Transaction) {
+	address := string(w.GetAddress())
+
+	funding := &blockchain.Transaction{
+		Timestamp: time.Now().UnixNano(),
+		Vin:       []blockchain.TXInput{{Txid: []byte{}, Vout: -1, PubKey: []byte("funding")}},
+	}
+	for i := 0; i < outputs; i++ {
+		funding.Vout = append(funding.Vout, *blockchain.NewTXOutput(10, address))
+	}
+	funding.ID = funding.Hash()
+
+	chain := &testChain{
+		txs:   map[string]blockchain.Transaction{hex.EncodeToString(funding.ID): *funding},
+		utxos: make(map[string]blockchain.TXOutput),
+	}
+	for i, out := range funding.Vout {
+		chain.utxos[outpoint(funding.ID, i)] = out
+	}
+
+	return chain, funding
+}
+
+// spend creates a signed transaction spending the output of the parent to the wallet
+func spend(w *wallet.Wallet, parent *blockchain.Transaction, vout, value int) *blockchain.Transaction {
+	prevTXs := map[string]blockchain.Transaction{hex.EncodeToString(parent.ID): *parent}
+
+	tx := &blockchain.Transaction{
+		Timestamp: time.Now().UnixNano(),
+		Vin:       []blockchain.TXInput{{Txid: parent.ID, Vout: vout, PubKey: w.PublicKey}},
+		Vout:      []blockchain.TXOutput{*blockchain.NewTXOutput(value, string(w.GetAddress()))},
+	}
+	tx.ID = tx.Hash()
+	tx.Sign(w.PrivateKey, prevTXs)
+
+	return tx
+}
+
+func errorCode(err error) string {
+	if txErr, ok := err.(*TxError); ok {
+		return txErr.Code.String()
+	}
+	return fmt.Sprintf("%v", err)
+}
+
+func TestMempoolAdd(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 2)
+	mp := New(chain)
+
+	parent := spend(w, funding, 0, 9)
+	child := spend(w, parent, 0, 7)
+	doubleSpend := spend(w, funding, 0, 5)
+	missing := spend(w, doubleSpend, 0, 4)
+	lowFee := spend(w, funding, 1, 10)
+	overspend := spend(w, funding, 1, 11)
+	badSignature := spend(w, funding, 1, 9)
+	badSignature.Vout[0].Value = 8
+	badSignature.ID = badSignature.Hash()
+
+	tests := []struct {
+		name string
+		tx   *blockchain.Transaction
+		want error
+	}{
+		{"parent", parent, nil},
+		{"duplicate", parent, &TxError{Code: ErrTxDuplicate}},
+		{"child", child, nil},
+		{"double spend", doubleSpend, &TxError{Code: ErrTxDoubleSpend}},
+		{"missing inputs", missing, &TxError{Code: ErrTxMissingInputs}},
+		{"low fee", lowFee, &TxError{Code: ErrTxLowFee}},
+		{"overspend", overspend, &TxError{Code: ErrTxInvalid}},
+		{"bad signature", badSignature, &TxError{Code: ErrTxInvalid}},
+		{"coinbase", funding, &TxError{Code: ErrTxInvalid}},
+	}
+
+	for _, test := range tests {
+		err := mp.Add(test.tx)
+		if errorCode(err) != errorCode(test.want) {
+			t.Errorf("Add(%s) error = %v, want %s", test.name, err, errorCode(test.want))
+		}
+	}
+
+	txs := mp.Transactions()
+	if len(txs) != 2 || hex.EncodeToString(txs[0].ID) != hex.EncodeToString(parent.ID) {
+		t.Errorf("Transactions() = %d transactions, want the parent and the child", len(txs))
+	}
+	// the funding transaction is a coinbase of a recent block
+	chain.immature = map[string]bool{hex.EncodeToString(funding.ID): true}
+	err := mp.Add(spend(w, funding, 1, 9))
+	if errorCode(err) != ErrTxMissingInputs.String() {
+		t.Errorf("Add(immature coinbase) error = %v, want %s", err, ErrTxMissingInputs)
+	}
+}
+
+func TestMempoolRemoveForBlock(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 2)
+	mp := New(chain)
+
+	confirmed := spend(w, funding, 0, 9)
+	confirmedChild := spend(w, confirmed, 0, 8)
+	conflicted := spend(w, funding, 1, 9)
+	conflictedChild := spend(w, conflicted, 0, 8)
+	for _, tx := range []*blockchain.Transaction{confirmed, confirmedChild, conflicted, conflictedChild} {
+		err := mp.Add(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conflicting := spend(w, funding, 1, 7)
+	mp.RemoveForBlock(&blockchain.Block{Transactions: []*blockchain.Transaction{confirmed, conflicting}})
+
+	if mp.Has(confirmed.ID) || mp.Has(conflicted.ID) || mp.Has(conflictedChild.ID) {
+		t.Error("RemoveForBlock() left confirmed or conflicting transactions")
+	}
+	if !mp.Has(confirmedChild.ID) {
+		t.Error("RemoveForBlock() removed the child of a confirmed transaction")
+	}
+}
+
+func TestMempoolRemoveInvalid(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 3)
+	mp := New(chain)
+
+	parent := spend(w, funding, 0, 9)
+	child := spend(w, parent, 0, 8)
+	other := spend(w, funding, 1, 9)
+	for _, tx := range []*blockchain.Transaction{parent, child, other} {
+		err := mp.Add(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a reorganization spends the output in the new branch
+	delete(chain.utxos, outpoint(funding.ID, 0))
+	if removed := mp.RemoveInvalid(); removed != 2 {
+		t.Errorf("RemoveInvalid() = %d, want 2", removed)
+	}
+	if mp.Has(parent.ID) || mp.Has(child.ID) || !mp.Has(other.ID) {
+		t.Error("RemoveInvalid() has to remove the transaction without the input and its child only")
+	}
+
+	// the coinbase of the new branch can't be spent yet
+	chain.immature = map[string]bool{hex.EncodeToString(funding.ID): true}
+	if removed := mp.RemoveInvalid(); removed != 1 || mp.Count() != 0 {
+		t.Errorf("RemoveInvalid() of the immature spend = %d, %d left, want 1, 0 left", removed, mp.Count())
+	}
+}
+
+// the chain listener removes the block transactions while the peers add new ones,
+// the race detector checks the pool is locked
+func TestMempoolConcurrentBlocks(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 20)
+	mp := New(chain)
+
+	var txs []*blockchain.Transaction
+	for i := range funding.Vout {
+		txs = append(txs, spend(w, funding, i, 9))
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for _, tx := range txs {
+			mp.Add(tx)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for _, tx := range txs {
+			mp.RemoveForBlock(&blockchain.Block{Transactions: []*blockchain.Transaction{tx}})
+			mp.Transactions()
+		}
+	}()
+	wg.Wait()
+
+	// every transaction is either added after its block or removed by it
+	mp.RemoveForBlock(&blockchain.Block{Transactions: txs})
+	if mp.Count() != 0 || mp.Size() != 0 {
+		t.Errorf("Count() = %d, Size() = %d after the blocks, want 0", mp.Count(), mp.Size())
+	}
+}
+
+func TestMempoolLimits(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 3)
+	mp := New(chain)
+	mp.MaxTransactions = 2
+
+	old := spend(w, funding, 0, 5)
+	parent := spend(w, funding, 1, 9)
+	for _, tx := range []*blockchain.Transaction{old, parent} {
+		err := mp.Add(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the parent pays less than the older transaction, so it's evicted with its child
+	child := spend(w, parent, 0, 7)
+	if err := mp.Add(child); errorCode(err) != ErrTxPoolFull.String() {
+		t.Errorf("Add() over the limit error = %v, want %s", err, ErrTxPoolFull)
+	}
+	if mp.Count() != 1 || !mp.Has(old.ID) {
+		t.Errorf("Count() = %d after eviction, want only the highest fee rate transaction", mp.Count())
+	}
+	if mp.Size() != len(old.Serialize()) {
+		t.Errorf("Size() = %d, want %d", mp.Size(), len(old.Serialize()))
+	}
+
+	// the rate of the evicted package is the lowest one accepted now
+	if err := mp.Add(spend(w, funding, 1, 9)); errorCode(err) != ErrTxLowFee.String() {
+		t.Errorf("Add() below the evicted rate error = %v, want %s", err, ErrTxLowFee)
+	}
+	if err := mp.Add(spend(w, funding, 1, 7)); err != nil {
+		t.Errorf("Add() above the evicted rate error = %v", err)
+	}
+
+	mp.Expiry = 0
+	if removed := mp.Expire(); removed != 2 || mp.Count() != 0 {
+		t.Errorf("Expire() = %d, %d transactions left", removed, mp.Count())
+	}
+
+	// a block resets the evicted rate
+	mp.RemoveForBlock(&blockchain.Block{})
+	err := mp.Add(spend(w, funding, 0, 9))
+	if err != nil {
+		t.Errorf("Add() after a block error = %v", err)
+	}
+}
+
+func TestMempoolBlockTemplate(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 3)
+	mp := New(chain)
+
+	low := spend(w, funding, 0, 9)
+	lowChild := spend(w, low, 0, 1)
+	high := spend(w, funding, 1, 4)
+	middle := spend(w, funding, 2, 7)
+	for _, tx := range []*blockchain.Transaction{low, lowChild, high, middle} {
+		err := mp.Add(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the child pays for its parent, so the pair goes before the middle one,
+	// but after the transaction paying more than the pair per byte
+	txs, fees := mp.BlockTemplate(DefaultBlockSize)
+	want := []*blockchain.Transaction{high, low, lowChild, middle}
+	if len(txs) != len(want) || fees != 18 {
+		t.Fatalf("BlockTemplate() = %d transactions with fees %d, want %d with fees 18", len(txs), fees, len(want))
+	}
+	for i := range want {
+		if hex.EncodeToString(txs[i].ID) != hex.EncodeToString(want[i].ID) {
+			t.Errorf("BlockTemplate()[%d] = %x, want %x", i, txs[i].ID, want[i].ID)
+		}
+	}
+
+	txs, fees = mp.BlockTemplate(len(high.Serialize()))
+	if len(txs) != 1 || fees != 6 {
+		t.Errorf("BlockTemplate() of a small block = %d transactions with fees %d, want the highest fee one", len(txs), fees)
+	}
+}
+
+type testStorage struct {
+	txs []StoredTransaction
+}
+
+func (s *testStorage) Load() ([]StoredTransaction, error) {
+	return s.txs, nil
+}
+
+func (s *testStorage) Save(txs []StoredTransaction) error {
+	s.txs = txs
+	return nil
+}
+
+func TestMempoolSaveLoad(t *testing.T) {
+	w := wallet.NewWallet()
+	chain, funding := newTestChain(w, 3)
+	storage := &testStorage{}
+	mp := New(chain)
+	mp.SetStorage(storage)
+
+	parent := spend(w, funding, 0, 9)
+	child := spend(w, parent, 0, 8)
+	expired := spend(w, funding, 1, 9)
+	for _, tx := range []*blockchain.Transaction{parent, child, expired} {
+		err := mp.Add(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := mp.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage.txs[2].Added = time.Now().Add(-2 * DefaultExpiry)
+	// the output of the parent is spent while the node is stopped
+	delete(chain.utxos, outpoint(funding.ID, 0))
+
+	restarted := New(chain)
+	restarted.SetStorage(storage)
+	loaded, err := restarted.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded != 0 || restarted.Count() != 0 {
+		t.Errorf("Load() = %d, want the invalid and expired transactions skipped", loaded)
+	}
+
+	chain.utxos[outpoint(funding.ID, 0)] = funding.Vout[0]
+	loaded, _ = restarted.Load()
+	if loaded != 2 || !restarted.Has(parent.ID) || !restarted.Has(child.ID) {
+		t.Errorf("Load() = %d, want the parent and the child", loaded)
+	}
+}
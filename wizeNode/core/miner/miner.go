@@ -0,0 +1,157 @@
+package miner
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/log"
+	"wizeBlock/wizeNode/core/mempool"
+)
+
+// Miner mines blocks with the mempool transactions in the background
+// The proof-of-work is searched by a few workers, it's cancelled and the block is
+// built again when the tip of the blockchain or the pool transactions change
+type Miner struct {
+	bc      *blockchain.Blockchain
+	mempool *mempool.Mempool
+	address string
+	mined   func(block *blockchain.Block)
+
+	// Workers is the number of goroutines searching the proof-of-work
+	Workers int
+
+	updates chan struct{}
+}
+
+// New creates a miner paying the rewards to the address
+// mined is called with every mined block added to the blockchain
+func New(bc *blockchain.Blockchain, mp *mempool.Mempool, address string, mined func(block *blockchain.Block)) *Miner {
+	return &Miner{
+		bc:      bc,
+		mempool: mp,
+		address: address,
+		mined:   mined,
+		Workers: runtime.NumCPU(),
+		updates: make(chan struct{}, 1),
+	}
+}
+
+// Update tells the miner that the tip or the pool transactions have changed,
+// the current block is abandoned and a new one is built
+func (m *Miner) Update() {
+	select {
+	case m.updates <- struct{}{}:
+	default:
+	}
+}
+
+// Start mines blocks until the stop channel is closed
+func (m *Miner) Start(stop <-chan struct{}) {
+	for {
+		block := m.template()
+		if block == nil {
+			// nothing to mine until a new transaction or block
+			select {
+			case <-m.updates:
+				continue
+			case <-stop:
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		solved := make(chan bool, 1)
+		go func() {
+			solved <- m.solve(ctx, block)
+		}()
+
+		select {
+		case ok := <-solved:
+			cancel()
+			if ok {
+				m.submit(block)
+			}
+		case <-m.updates:
+			cancel()
+			// the block could be solved before the cancellation
+			if <-solved {
+				m.submit(block)
+			}
+		case <-stop:
+			cancel()
+			<-solved
+			return
+		}
+	}
+}
+
+// template creates a block with the pool transactions paying the highest fees,
+// nil is returned when there is nothing to mine: no transactions and no subsidy
+func (m *Miner) template() *blockchain.Block {
+	subsidy := m.bc.Params().BlockSubsidy(m.bc.GetBestHeight() + 1)
+	if m.mempool.Count() == 0 && subsidy == 0 {
+		return nil
+	}
+
+	block, err := newBlockTemplate(m.bc, m.mempool, m.address)
+	if err != nil {
+		log.Warn.Printf("Failed with the block template: %s", err)
+		return nil
+	}
+	if len(block.Transactions) == 1 && subsidy == 0 {
+		return nil
+	}
+
+	log.Debug.Printf("Mining a block of height %d with %d transactions, reward: %d", block.Height, len(block.Transactions)-1, block.Transactions[0].Vout[0].Value)
+	return block
+}
+
+// solve searches the proof-of-work of the block with all the workers
+// False is returned when the context is cancelled first
+func (m *Miner) solve(ctx context.Context, block *blockchain.Block) bool {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var once sync.Once
+	var wg sync.WaitGroup
+	found := false
+
+	for i := 0; i < m.Workers; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+
+			// every worker has its own copy of the header, the block is changed by the winner
+			header := block.BlockHeader
+			pow := blockchain.NewProofOfWork(&header)
+			nonce, hash, ok := pow.RunContext(ctx, start, m.Workers)
+			if !ok {
+				return
+			}
+
+			once.Do(func() {
+				block.Nonce = nonce
+				block.Hash = hash
+				found = true
+				cancel()
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	return found
+}
+
+// submit adds the solved block to the blockchain
+func (m *Miner) submit(block *blockchain.Block) {
+	err := m.bc.AddBlock(block)
+	if err != nil {
+		log.Warn.Printf("Mined block %x is rejected: %s", block.Hash, err)
+		return
+	}
+
+	log.Info.Printf("New block %x of height %d is mined", block.Hash, block.Height)
+	m.mined(block)
+}
@@ -0,0 +1,260 @@
+package miner
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/mempool"
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// newTestBlockchain creates a blockchain in a temporary directory with the target 2^targetBits,
+// the difficulty doesn't change and the genesis block pays the emission to the returned wallet
+func newTestBlockchain(t *testing.T, targetBits uint) (*blockchain.Blockchain, *mempool.Mempool, *wallet.Wallet, func()) {
+	dir, err := ioutil.TempDir("", "miner")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := wallet.NewWallet()
+	params := blockchain.DefaultParams
+	params.PowLimit = new(big.Int).Lsh(big.NewInt(1), 255)
+	params.RetargetInterval = 0
+	params.CoinbaseMaturity = 0
+	params.Genesis = blockchain.Genesis{
+		Address:   string(w.GetAddress()),
+		Reward:    1000000,
+		Timestamp: 1523558612,
+		Bits:      blockchain.BigToCompact(new(big.Int).Lsh(big.NewInt(1), targetBits)),
+	}
+	params.Genesis.Hash = hex.EncodeToString(params.Genesis.Block().Hash)
+
+	bc := blockchain.CreateBlockchain(dir+"/", "test", &params)
+	blockchain.UTXOSet{Blockchain: bc}.Reindex()
+
+	mp := mempool.New(bc)
+	mp.MinRelayFeeRate = 0
+
+	return bc, mp, w, func() {
+		bc.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// addTestTx adds a transaction paying 10 from the wallet to the mempool
+func addTestTx(t *testing.T, bc *blockchain.Blockchain, mp *mempool.Mempool, w *wallet.Wallet) *blockchain.Transaction {
+	receiver := wallet.NewWallet()
+	tx := blockchain.NewUTXOTransaction(w, string(receiver.GetAddress()), 10, 0, blockchain.NewUTXOView(bc, mp))
+
+	err := mp.Add(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tx
+}
+
+func hasTransaction(block *blockchain.Block, tx *blockchain.Transaction) bool {
+	for _, blockTx := range block.Transactions {
+		if bytes.Equal(blockTx.ID, tx.ID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestMinerUpdate(t *testing.T) {
+	bc, mp, w, done := newTestBlockchain(t, 250)
+	defer done()
+
+	// without the subsidy there is nothing to mine until a transaction comes
+	bc.Params().Subsidy = 0
+
+	mined := make(chan *blockchain.Block, 10)
+	m := New(bc, mp, string(w.GetAddress()), func(block *blockchain.Block) {
+		mined <- block
+	})
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		m.Start(stop)
+		close(stopped)
+	}()
+
+	select {
+	case block := <-mined:
+		t.Fatalf("Block %x is mined without transactions", block.Hash)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tx := addTestTx(t, bc, mp, w)
+	m.Update()
+
+	select {
+	case block := <-mined:
+		if !hasTransaction(block, tx) {
+			t.Errorf("Mined block %x doesn't include the transaction", block.Hash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the transaction to be mined")
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start() didn't return after stop")
+	}
+}
+
+func TestMinerStop(t *testing.T) {
+	// no block is solved during the test
+	bc, mp, w, done := newTestBlockchain(t, 10)
+	defer done()
+
+	m := New(bc, mp, string(w.GetAddress()), func(block *blockchain.Block) {
+		t.Errorf("Block %x is mined", block.Hash)
+	})
+	m.Workers = 2
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		m.Start(stop)
+		close(stopped)
+	}()
+
+	// the updates cancel the search of the current block
+	for i := 0; i < 3; i++ {
+		m.Update()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start() didn't return after stop")
+	}
+
+	block, err := bc.NewBlockTemplate(string(w.GetAddress()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if m.solve(ctx, block) {
+		t.Error("solve() with the cancelled context = true")
+	}
+}
+
+func TestWorkSolved(t *testing.T) {
+	bc, mp, w, done := newTestBlockchain(t, 255)
+	defer done()
+
+	work := NewWork(bc, mp)
+	tx := addTestTx(t, bc, mp, w)
+
+	template, err := work.Template(string(w.GetAddress()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasTransaction(template, tx) {
+		t.Error("Template() doesn't include the mempool transaction")
+	}
+
+	// the external miner solves a copy of the header
+	header := template.BlockHeader
+	nonce, hash := blockchain.NewProofOfWork(&header).Run()
+
+	if _, err := work.Solved([]byte{1}, 0, nonce); err != ErrUnknownTemplate {
+		t.Errorf("Solved(unknown merkle root) error = %v, want %v", err, ErrUnknownTemplate)
+	}
+
+	block, err := work.Solved(template.MerkleRoot, 0, nonce)
+	if err != nil {
+		t.Fatalf("Solved() error = %v", err)
+	}
+	if !bytes.Equal(block.Hash, hash) || block.Timestamp != template.Timestamp {
+		t.Errorf("Solved() = %x at %d, want %x at %d", block.Hash, block.Timestamp, hash, template.Timestamp)
+	}
+	if err := bc.AddBlock(block); err != nil {
+		t.Errorf("AddBlock(solved) error = %v", err)
+	}
+}
+
+func TestWorkStaleTemplate(t *testing.T) {
+	bc, mp, w, done := newTestBlockchain(t, 255)
+	defer done()
+
+	work := NewWork(bc, mp)
+	address := string(w.GetAddress())
+
+	template, err := work.Template(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := template.BlockHeader
+	nonce, _ := blockchain.NewProofOfWork(&header).Run()
+
+	// another block extends the tip first
+	block, err := work.Generate(address)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatalf("AddBlock(generated) error = %v", err)
+	}
+
+	if _, err := work.Solved(template.MerkleRoot, 0, nonce); err != ErrUnknownTemplate {
+		t.Errorf("Solved(stale template) error = %v, want %v", err, ErrUnknownTemplate)
+	}
+
+	work.TipChanged()
+	work.mu.Lock()
+	count := len(work.templates)
+	work.mu.Unlock()
+	if count != 0 {
+		t.Errorf("%d templates are kept after the tip has changed, want 0", count)
+	}
+}
+
+func TestWorkInvalidPoolTransaction(t *testing.T) {
+	bc, mp, w, done := newTestBlockchain(t, 255)
+	defer done()
+
+	work := NewWork(bc, mp)
+	address := string(w.GetAddress())
+	invalid := addTestTx(t, bc, mp, w)
+
+	// a block spends the output of the pool transaction, the pool isn't told about it
+	conflicting := blockchain.NewUTXOTransaction(w, address, 20, 0, blockchain.NewUTXOView(bc, nil))
+	block, err := bc.NewBlockTemplate(address, []*blockchain.Transaction{conflicting})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.Nonce, block.Hash = blockchain.NewProofOfWork(&block.BlockHeader).Run()
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	template, err := work.Template(address)
+	if err != nil {
+		t.Fatalf("Template() error = %v", err)
+	}
+	if len(template.Transactions) != 1 {
+		t.Errorf("Template() has %d transactions, want the coinbase only", len(template.Transactions))
+	}
+	if mp.Has(invalid.ID) {
+		t.Error("Template() left the rejected transaction in the pool")
+	}
+}
@@ -0,0 +1,151 @@
+package miner
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/log"
+	"wizeBlock/wizeNode/core/mempool"
+)
+
+// maxTemplateAttempts limits the pool transactions removed while a block template is created
+const maxTemplateAttempts = 10
+
+var (
+	// ErrUnknownTemplate is returned for a solution of a template which isn't known
+	// or is built on top of an old tip
+	ErrUnknownTemplate = errors.New("Unknown or stale block template")
+	// ErrNonceNotFound is returned when no nonce of the block meets the target
+	ErrNonceNotFound = errors.New("Proof-of-work is not found for the block")
+)
+
+// Work gives block templates to the external miners and assembles the blocks
+// from their solutions. The solution is the nonce and the timestamp of the header,
+// the template is found by its merkle root
+type Work struct {
+	bc      *blockchain.Blockchain
+	mempool *mempool.Mempool
+
+	mu        sync.Mutex
+	templates map[string]*blockchain.Block
+}
+
+// NewWork creates the templates source for the blockchain and the mempool
+func NewWork(bc *blockchain.Blockchain, mp *mempool.Mempool) *Work {
+	return &Work{
+		bc:        bc,
+		mempool:   mp,
+		templates: make(map[string]*blockchain.Block),
+	}
+}
+
+// Template creates a block with the pool transactions paying the highest fees
+// and the reward paid to the address, the block has to be solved by the caller
+func (w *Work) Template(address string) (*blockchain.Block, error) {
+	block, err := w.newBlock(address)
+	if err != nil {
+		return nil, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.removeStale(block.PrevBlockHash)
+	w.templates[hex.EncodeToString(block.MerkleRoot)] = block
+
+	return block, nil
+}
+
+// TipChanged drops the templates built on top of an old tip,
+// their solutions can't be accepted anymore
+func (w *Work) TipChanged() {
+	tip := w.bc.GetTip()
+
+	w.mu.Lock()
+	w.removeStale(tip)
+	w.mu.Unlock()
+}
+
+// removeStale deletes the templates which don't extend the tip
+func (w *Work) removeStale(tip []byte) {
+	for key, template := range w.templates {
+		if !bytes.Equal(template.PrevBlockHash, tip) {
+			delete(w.templates, key)
+		}
+	}
+}
+
+// Generate creates a block like Template and solves it in the calling goroutine
+// It's meant for the networks where blocks are generated by request with a trivial difficulty
+func (w *Work) Generate(address string) (*blockchain.Block, error) {
+	block, err := w.newBlock(address)
+	if err != nil {
+		return nil, err
+	}
+
+	pow := blockchain.NewProofOfWork(&block.BlockHeader)
+	nonce, hash, ok := pow.RunContext(context.Background(), 0, 1)
+	if !ok {
+		return nil, ErrNonceNotFound
+	}
+	block.Nonce, block.Hash = nonce, hash
+
+	return block, nil
+}
+
+// newBlock creates a block with the pool transactions paying the highest fees
+func (w *Work) newBlock(address string) (*blockchain.Block, error) {
+	return newBlockTemplate(w.bc, w.mempool, address)
+}
+
+// newBlockTemplate creates a block with the pool transactions paying the highest fees
+// A transaction rejected by the block validation is removed from the pool together with
+// its descendants and the block is created again, the block has the coinbase only
+// when the pool transactions still can't be mined
+func newBlockTemplate(bc *blockchain.Blockchain, mp *mempool.Mempool, address string) (*blockchain.Block, error) {
+	for i := 0; i < maxTemplateAttempts; i++ {
+		txs, _ := mp.BlockTemplate(mempool.DefaultBlockSize)
+
+		block, err := bc.NewBlockTemplate(address, txs)
+		if err == nil {
+			return block, nil
+		}
+
+		blockErr, ok := err.(*blockchain.BlockError)
+		if !ok || blockErr.TxID == nil {
+			log.Warn.Printf("Failed with the block template: %s", err)
+			break
+		}
+
+		log.Warn.Printf("Transaction %x is removed from the pool: %s", blockErr.TxID, err)
+		mp.Remove(blockErr.TxID)
+	}
+
+	return bc.NewBlockTemplate(address, nil)
+}
+
+// Solved returns the block of the template with the merkle root and the solution applied
+// The timestamp of the template is kept when the timestamp is zero
+// The proof-of-work isn't checked, it's up to the blockchain validation
+func (w *Work) Solved(merkleRoot []byte, timestamp int64, nonce int) (*blockchain.Block, error) {
+	w.mu.Lock()
+	template, ok := w.templates[hex.EncodeToString(merkleRoot)]
+	w.mu.Unlock()
+
+	if !ok || bytes.Compare(template.PrevBlockHash, w.bc.GetTip()) != 0 {
+		return nil, ErrUnknownTemplate
+	}
+
+	block := *template
+	if timestamp != 0 {
+		block.Timestamp = timestamp
+	}
+	block.Nonce = nonce
+	block.Hash = block.BlockHeader.Hash()
+
+	return &block, nil
+}
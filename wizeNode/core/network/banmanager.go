@@ -0,0 +1,145 @@
+package network
+
+import (
+	"sync"
+	"time"
+
+	"wizeBlock/wizeNode/core/log"
+)
+
+const (
+	// BanThreshold is the misbehavior score after which a host is banned
+	BanThreshold = 100
+	// BanDuration is how long a misbehaving host is banned for
+	BanDuration = 24 * time.Hour
+)
+
+// Interface for extra storage of bans, so they survive node restarts
+type BanStorage interface {
+	GetBans() (map[string]time.Time, error)
+	AddBan(host string, until time.Time) error
+	RemoveBan(host string) error
+}
+
+// BanManager counts protocol violations of remote hosts and bans the ones which
+// reach BanThreshold, a ban expires after BanDuration
+// Hosts are identified by IP, so a node can't avoid the ban with another port
+type BanManager struct {
+	storage BanStorage
+
+	mu     sync.Mutex
+	scores map[string]int
+	bans   map[string]time.Time
+}
+
+// NewBanManager creates a ban manager with the bans loaded from the storage
+func NewBanManager(storage BanStorage) *BanManager {
+	bm := &BanManager{
+		storage: storage,
+		scores:  make(map[string]int),
+		bans:    make(map[string]time.Time),
+	}
+
+	if storage != nil {
+		bans, err := storage.GetBans()
+		if err != nil {
+			log.Warn.Printf("Failed with loading bans: %s", err)
+		}
+		for host, until := range bans {
+			bm.bans[host] = until
+		}
+	}
+
+	return bm
+}
+
+// Misbehaving adds the score to the host and bans it when the threshold is reached
+// True is returned when the host is banned
+func (bm *BanManager) Misbehaving(host string, score int, reason string) bool {
+	bm.mu.Lock()
+	bm.scores[host] += score
+	total := bm.scores[host]
+	bm.mu.Unlock()
+
+	log.Info.Printf("Misbehaving %s: %s, score %d (+%d)", host, reason, total, score)
+
+	if total < BanThreshold {
+		return false
+	}
+
+	bm.Ban(host, BanDuration)
+
+	return true
+}
+
+// Ban bans the host for the duration
+func (bm *BanManager) Ban(host string, duration time.Duration) {
+	until := time.Now().Add(duration)
+
+	bm.mu.Lock()
+	bm.bans[host] = until
+	delete(bm.scores, host)
+	bm.mu.Unlock()
+
+	log.Info.Printf("Ban %s until %s", host, until.Format(time.RFC3339))
+
+	if bm.storage != nil {
+		err := bm.storage.AddBan(host, until)
+		if err != nil {
+			log.Warn.Printf("Failed with saving ban of %s: %s", host, err)
+		}
+	}
+}
+
+// Unban removes the ban and the misbehavior score of the host
+func (bm *BanManager) Unban(host string) {
+	bm.mu.Lock()
+	delete(bm.bans, host)
+	delete(bm.scores, host)
+	bm.mu.Unlock()
+
+	if bm.storage != nil {
+		err := bm.storage.RemoveBan(host)
+		if err != nil {
+			log.Warn.Printf("Failed with removing ban of %s: %s", host, err)
+		}
+	}
+}
+
+// IsBanned checks whether the host is banned, expired bans are removed
+func (bm *BanManager) IsBanned(host string) bool {
+	bm.mu.Lock()
+	until, ok := bm.bans[host]
+	bm.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(until) {
+		bm.Unban(host)
+		return false
+	}
+
+	return true
+}
+
+// Bans returns the banned hosts with the ban expiration times
+func (bm *BanManager) Bans() map[string]time.Time {
+	bans := make(map[string]time.Time)
+
+	bm.mu.Lock()
+	for host, until := range bm.bans {
+		bans[host] = until
+	}
+	bm.mu.Unlock()
+
+	for host, until := range bans {
+		if time.Now().After(until) {
+			bm.Unban(host)
+			delete(bans, host)
+		}
+	}
+
+	return bans
+}
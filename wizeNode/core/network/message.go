@@ -0,0 +1,101 @@
+package network
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"wizeBlock/wizeNode/core/wire"
+)
+
+// Messages are sent over a connection in frames with the header:
+// magic (4 bytes), command (12 bytes), payload length (4 bytes), payload checksum (4 bytes),
+// message id (4 bytes) and id of the request the message replies to (4 bytes)
+// All numbers are little endian, ids are 0 for messages which are not requests or replies
+const messageHeaderLength = 4 + CommandLength + 4 + 4 + 4 + 4
+
+// MaxMessageLength is the largest payload accepted from a peer
+const MaxMessageLength = wire.MaxLength
+
+var (
+	ErrBadMagic    = errors.New("Wrong network magic")
+	ErrBadChecksum = errors.New("Wrong message checksum")
+)
+
+// Message is a command with its payload received from or sent to a peer
+type Message struct {
+	Command string
+	ID      uint32
+	ReplyTo uint32
+	Payload []byte
+}
+
+// checksum returns the first 4 bytes of the double sha256 of the payload
+func checksum(payload []byte) []byte {
+	hash := sha256.Sum256(payload)
+	hash = sha256.Sum256(hash[:])
+
+	return hash[:4]
+}
+
+// WriteMessage writes a message frame with the network magic
+func WriteMessage(w io.Writer, magic uint32, msg *Message) error {
+	if len(msg.Command) > CommandLength {
+		return fmt.Errorf("Command %s is too long", msg.Command)
+	}
+	if len(msg.Payload) > MaxMessageLength {
+		return fmt.Errorf("Message %s is too long: %d bytes", msg.Command, len(msg.Payload))
+	}
+
+	frame := make([]byte, messageHeaderLength, messageHeaderLength+len(msg.Payload))
+	binary.LittleEndian.PutUint32(frame[0:], magic)
+	copy(frame[4:], CommandToBytes(msg.Command))
+	binary.LittleEndian.PutUint32(frame[4+CommandLength:], uint32(len(msg.Payload)))
+	copy(frame[8+CommandLength:], checksum(msg.Payload))
+	binary.LittleEndian.PutUint32(frame[12+CommandLength:], msg.ID)
+	binary.LittleEndian.PutUint32(frame[16+CommandLength:], msg.ReplyTo)
+	frame = append(frame, msg.Payload...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// ReadMessage reads a message frame and checks its network magic and checksum
+func ReadMessage(r io.Reader, magic uint32) (*Message, error) {
+	header := make([]byte, messageHeaderLength)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(header[0:]) != magic {
+		return nil, ErrBadMagic
+	}
+
+	length := binary.LittleEndian.Uint32(header[4+CommandLength:])
+	if length > MaxMessageLength {
+		return nil, fmt.Errorf("Message is too long: %d bytes", length)
+	}
+
+	msg := &Message{
+		Command: BytesToCommand(header[4 : 4+CommandLength]),
+		ID:      binary.LittleEndian.Uint32(header[12+CommandLength:]),
+		ReplyTo: binary.LittleEndian.Uint32(header[16+CommandLength:]),
+		Payload: make([]byte, length),
+	}
+
+	_, err = io.ReadFull(r, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(checksum(msg.Payload), header[8+CommandLength:12+CommandLength]) {
+		return nil, ErrBadChecksum
+	}
+
+	return msg, nil
+}
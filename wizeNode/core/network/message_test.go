@@ -0,0 +1,85 @@
+package network
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	sent := &Message{Command: "getdata", ID: 7, ReplyTo: 3, Payload: []byte{1, 2, 3}}
+
+	err := WriteMessage(&buf, NetworkMagic, sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := ReadMessage(&buf, NetworkMagic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Command != sent.Command || msg.ID != sent.ID || msg.ReplyTo != sent.ReplyTo ||
+		!bytes.Equal(msg.Payload, sent.Payload) {
+		t.Errorf("ReadMessage() = %+v, want %+v", msg, sent)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var buf bytes.Buffer
+	WriteMessage(&buf, NetworkMagic, &Message{Command: "tx", Payload: []byte{1, 2, 3}})
+	frame := buf.Bytes()
+
+	_, err := ReadMessage(bytes.NewReader(frame), NetworkMagic+1)
+	if err != ErrBadMagic {
+		t.Errorf("ReadMessage() with another magic error = %v, want %v", err, ErrBadMagic)
+	}
+
+	corrupted := append([]byte{}, frame...)
+	corrupted[len(corrupted)-1]++
+	_, err = ReadMessage(bytes.NewReader(corrupted), NetworkMagic)
+	if err != ErrBadChecksum {
+		t.Errorf("ReadMessage() with a corrupted payload error = %v, want %v", err, ErrBadChecksum)
+	}
+
+	_, err = ReadMessage(bytes.NewReader(frame[:len(frame)-1]), NetworkMagic)
+	if err == nil {
+		t.Error("ReadMessage() of a truncated frame succeeded")
+	}
+
+	err = WriteMessage(&buf, NetworkMagic, &Message{Command: "verylongcommand"})
+	if err == nil {
+		t.Error("WriteMessage() with a long command succeeded")
+	}
+}
+
+func TestPeerRequest(t *testing.T) {
+	c1, c2 := net.Pipe()
+
+	client := NewPeer(c1, NetworkMagic, false, nil)
+	server := NewPeer(c2, NetworkMagic, true, func(p *Peer, msg *Message) {
+		p.Reply(msg, "echo", msg.Payload)
+	})
+	client.Start()
+	server.Start()
+	defer client.Disconnect()
+	defer server.Disconnect()
+
+	for i := byte(0); i < 3; i++ {
+		reply, err := client.Request("ping", []byte{i}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Command != "echo" || !bytes.Equal(reply.Payload, []byte{i}) {
+			t.Errorf("Request() = %+v, want echo of %d", reply, i)
+		}
+	}
+
+	server.Disconnect()
+	_, err := client.Request("ping", nil, time.Second)
+	if err != ErrPeerDisconnected {
+		t.Errorf("Request() to a disconnected peer error = %v, want %v", err, ErrPeerDisconnected)
+	}
+}
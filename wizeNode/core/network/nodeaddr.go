@@ -1,8 +1,6 @@
 package network
 
 import (
-	"bytes"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"strconv"
@@ -12,9 +10,30 @@ import (
 // TODO: add AuthStringLength
 
 const Protocol = "tcp"
-const NodeVersion = 1
+const NodeVersion = 3
 const CommandLength = 12
 
+// MinNodeVersion is the oldest protocol version of nodes we can talk to
+const MinNodeVersion = 3
+
+// ServiceNode is set by nodes which keep the full blockchain and serve blocks
+const ServiceNode uint64 = 1
+
+// NetworkMagic starts every message frame, it separates the network from others
+const NetworkMagic = 0x455a4957
+
+// MaxHeadersPerMessage is the largest number of headers sent in one message
+const MaxHeadersPerMessage = 2000
+
+// MaxBlocksPerMessage is the largest number of block hashes sent in reply to getblocks
+const MaxBlocksPerMessage = 500
+
+// MaxInvPerMessage is the largest number of items accepted in one inv message
+const MaxInvPerMessage = 50000
+
+// MaxAddrPerMessage is the largest number of addresses accepted in one addr message
+const MaxAddrPerMessage = 1000
+
 // Represents a node address
 type NodeAddr struct {
 	Host string
@@ -87,16 +106,3 @@ func BytesToCommand(bytes []byte) string {
 func ExtractCommand(request []byte) []byte {
 	return request[:CommandLength]
 }
-
-// Encode structure to bytes
-func GobEncode(data interface{}) ([]byte, error) {
-	var buff bytes.Buffer
-
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	if err != nil {
-		return []byte{}, err
-	}
-
-	return buff.Bytes(), nil
-}
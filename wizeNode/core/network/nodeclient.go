@@ -1,11 +1,9 @@
 package network
 
 import (
-	"bytes"
+	"encoding"
 	"errors"
 	"fmt"
-	"io"
-	"net"
 
 	"wizeBlock/wizeNode/core/blockchain"
 	"wizeBlock/wizeNode/core/log"
@@ -20,6 +18,7 @@ type NodeClient struct {
 	WalletAddress string
 	NodeAddress   NodeAddr
 	Network       *NodeNetwork
+	Peers         *PeerManager
 }
 
 type ComAddr struct {
@@ -33,6 +32,19 @@ type ComBlock struct {
 
 type ComGetBlocks struct {
 	AddrFrom NodeAddr
+	Locator  [][]byte
+	StopHash []byte
+}
+
+type ComGetHeaders struct {
+	AddrFrom NodeAddr
+	Locator  [][]byte
+	StopHash []byte
+}
+
+type ComHeaders struct {
+	AddrFrom NodeAddr
+	Headers  [][]byte
 }
 
 type ComGetData struct {
@@ -54,10 +66,16 @@ type ComTx struct {
 
 type ComVersion struct {
 	Version    int
+	Services   uint64
 	BestHeight int
 	AddrFrom   NodeAddr
 }
 
+// ComPing is the payload of both ping and pong messages, pong repeats the nonce of ping
+type ComPing struct {
+	Nonce uint64
+}
+
 // Set currrent node address , to include itin requests to other nodes
 func (c *NodeClient) SetNodeAddress(address NodeAddr) {
 	c.NodeAddress = address
@@ -78,17 +96,17 @@ func (c *NodeClient) CheckNodeAddress(address NodeAddr) error {
 }
 
 // Builds a command data. It prepares a slice of bytes from given data
-func (c *NodeClient) BuildCommandData(command string, data interface{}) ([]byte, error) {
+func (c *NodeClient) BuildCommandData(command string, data encoding.BinaryMarshaler) ([]byte, error) {
 	return c.doBuildCommandData(command, data)
 }
 
 // Builds a command data. It prepares a slice of bytes from given data
-func (c *NodeClient) doBuildCommandData(command string, data interface{}) ([]byte, error) {
+func (c *NodeClient) doBuildCommandData(command string, data encoding.BinaryMarshaler) ([]byte, error) {
 	var payload []byte
 	var err error
 
 	if data != nil {
-		payload, err = GobEncode(data)
+		payload, err = data.MarshalBinary()
 
 		if err != nil {
 			return nil, err
@@ -103,35 +121,61 @@ func (c *NodeClient) doBuildCommandData(command string, data interface{}) ([]byt
 	return request, nil
 }
 
+// Sends command data to the node over the connection to it
 func (c *NodeClient) SendData(address NodeAddr, data []byte) error {
-	err := c.CheckNodeAddress(address)
+	peer, err := c.connect(address)
 	if err != nil {
 		return err
 	}
 
 	log.Debug.Printf("Sending %d bytes to %s", len(data), address)
-	conn, err := net.Dial(Protocol, address.String())
+
+	return peer.Send(BytesToCommand(data[:CommandLength]), data[CommandLength:])
+}
+
+// Sends command data to the node and waits for the reply
+func (c *NodeClient) Request(address NodeAddr, data []byte) (*Message, error) {
+	peer, err := c.connect(address)
 	if err != nil {
-		log.Warn.Println("Dial error: ", err.Error())
+		return nil, err
+	}
 
-		// we can not connect
-		// we could remove this node from known
-		// but this is not always good. we need something more smart here
-		// TODO this needs analysis. if removing of a node is good idea
-		//c.Network.RemoveNodeFromKnown(address)
+	reply, err := peer.Request(BytesToCommand(data[:CommandLength]), data[CommandLength:], RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
 
-		return fmt.Errorf("%s is not available\n", address)
+	if reply.Command == "error" {
+		return nil, errors.New(DecodeError(reply.Payload))
 	}
-	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	return reply, nil
+}
+
+// Opens a connection to the node, the handshake is started right away
+func (c *NodeClient) Connect(address NodeAddr) error {
+	_, err := c.connect(address)
+
+	return err
+}
+
+func (c *NodeClient) connect(address NodeAddr) (*Peer, error) {
+	err := c.CheckNodeAddress(address)
 	if err != nil {
-		log.Warn.Printf("Send data error: %s", err)
+		return nil, err
 	}
 
-	log.Debug.Printf("Send data: %x", data)
+	peer, err := c.Peers.Connect(address)
+	if err != nil {
+		log.Warn.Println("Dial error: ", err.Error())
 
-	return nil
+		// the peer manager removes the node from known
+		// after several failed connections in a row
+
+		return nil, fmt.Errorf("%s is not available\n", address)
+	}
+
+	return peer, nil
 }
 
 func (c *NodeClient) SendAddr(address NodeAddr, addresses []NodeAddr) error {
@@ -167,8 +211,29 @@ func (c *NodeClient) SendInv(address NodeAddr, kind string, items [][]byte) erro
 	return c.SendData(address, request)
 }
 
-func (c *NodeClient) SendGetBlocks(address NodeAddr) error {
-	data := ComGetBlocks{c.NodeAddress}
+// Sends the inventory to all peers after the handshake except the given one
+func (c *NodeClient) BroadcastInv(kind string, items [][]byte, except *Peer) error {
+	data := ComInv{c.NodeAddress, kind, items}
+
+	payload, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	for _, peer := range c.Peers.All() {
+		if peer == except || !peer.HandshakeDone() {
+			continue
+		}
+
+		log.Debug.Printf("Announce %d %s to %s", len(items), kind, peer.Addr())
+		peer.Send("inv", payload)
+	}
+
+	return nil
+}
+
+func (c *NodeClient) SendGetBlocks(address NodeAddr, locator [][]byte, stopHash []byte) error {
+	data := ComGetBlocks{c.NodeAddress, locator, stopHash}
 
 	request, err := c.BuildCommandData("getblocks", &data)
 	if err != nil {
@@ -178,6 +243,31 @@ func (c *NodeClient) SendGetBlocks(address NodeAddr) error {
 	return c.SendData(address, request)
 }
 
+func (c *NodeClient) SendGetHeaders(address NodeAddr, locator [][]byte, stopHash []byte) error {
+	data := ComGetHeaders{c.NodeAddress, locator, stopHash}
+
+	request, err := c.BuildCommandData("getheaders", &data)
+	if err != nil {
+		return err
+	}
+
+	return c.SendData(address, request)
+}
+
+func (c *NodeClient) SendHeaders(address NodeAddr, headers []*blockchain.BlockHeader) error {
+	data := ComHeaders{c.NodeAddress, make([][]byte, len(headers))}
+	for i, header := range headers {
+		data.Headers[i] = header.Serialize()
+	}
+
+	request, err := c.BuildCommandData("headers", &data)
+	if err != nil {
+		return err
+	}
+
+	return c.SendData(address, request)
+}
+
 func (c *NodeClient) SendGetData(address NodeAddr, kind string, id []byte) error {
 	data := ComGetData{c.NodeAddress, kind, id}
 
@@ -201,7 +291,7 @@ func (c *NodeClient) SendTx(address NodeAddr, tnx *blockchain.Transaction) error
 }
 
 func (c *NodeClient) SendVersion(address NodeAddr, bestHeight int) error {
-	data := ComVersion{NodeVersion, bestHeight, c.NodeAddress}
+	data := ComVersion{NodeVersion, ServiceNode, bestHeight, c.NodeAddress}
 
 	request, err := c.BuildCommandData("version", &data)
 	if err != nil {
@@ -0,0 +1,248 @@
+package network
+
+import (
+	"fmt"
+
+	"wizeBlock/wizeNode/core/wire"
+)
+
+// Network messages are encoded with the canonical binary encoding,
+// fields are written in the order they are declared
+
+func encodeAddr(w *wire.Writer, addr NodeAddr) {
+	w.WriteString(addr.Host)
+	w.WriteInt(int64(addr.Port))
+}
+
+func decodeAddr(r *wire.Reader) NodeAddr {
+	host := r.ReadString()
+	port := int(r.ReadInt())
+
+	return NodeAddr{Host: host, Port: port}
+}
+
+func unmarshal(data []byte, decoder func(r *wire.Reader)) error {
+	r, err := wire.NewReader(data)
+	if err != nil {
+		return err
+	}
+	decoder(r)
+
+	return r.Close()
+}
+
+// EncodeError encodes an error message sent back to the client
+func EncodeError(message string) []byte {
+	w := wire.NewWriter()
+	w.WriteString(message)
+
+	return w.Bytes()
+}
+
+// DecodeError decodes an error message received from the node
+func DecodeError(data []byte) string {
+	var message string
+	err := unmarshal(data, func(r *wire.Reader) {
+		message = r.ReadString()
+	})
+	if err != nil {
+		return fmt.Sprintf("Wrong error message: %s", err)
+	}
+
+	return message
+}
+
+func (m *ComAddr) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+
+	w.WriteCount(len(m.AddrList))
+	for _, addr := range m.AddrList {
+		encodeAddr(w, addr)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *ComAddr) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrList = make([]NodeAddr, r.ReadCount())
+		for i := range m.AddrList {
+			m.AddrList[i] = decodeAddr(r)
+		}
+	})
+}
+
+func (m *ComBlock) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddrFrom)
+	w.WriteBytes(m.Block)
+
+	return w.Bytes(), nil
+}
+
+func (m *ComBlock) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrFrom = decodeAddr(r)
+		m.Block = r.ReadBytes()
+	})
+}
+
+func encodeLocator(w *wire.Writer, locator [][]byte, stopHash []byte) {
+	w.WriteCount(len(locator))
+	for _, hash := range locator {
+		w.WriteBytes(hash)
+	}
+	w.WriteBytes(stopHash)
+}
+
+func decodeLocator(r *wire.Reader) ([][]byte, []byte) {
+	locator := make([][]byte, r.ReadCount())
+	for i := range locator {
+		locator[i] = r.ReadBytes()
+	}
+	stopHash := r.ReadBytes()
+
+	return locator, stopHash
+}
+
+func (m *ComGetBlocks) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddrFrom)
+	encodeLocator(w, m.Locator, m.StopHash)
+
+	return w.Bytes(), nil
+}
+
+func (m *ComGetBlocks) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrFrom = decodeAddr(r)
+		m.Locator, m.StopHash = decodeLocator(r)
+	})
+}
+
+func (m *ComGetHeaders) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddrFrom)
+	encodeLocator(w, m.Locator, m.StopHash)
+
+	return w.Bytes(), nil
+}
+
+func (m *ComGetHeaders) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrFrom = decodeAddr(r)
+		m.Locator, m.StopHash = decodeLocator(r)
+	})
+}
+
+func (m *ComHeaders) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddrFrom)
+
+	w.WriteCount(len(m.Headers))
+	for _, header := range m.Headers {
+		w.WriteBytes(header)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *ComHeaders) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrFrom = decodeAddr(r)
+
+		m.Headers = make([][]byte, r.ReadCount())
+		for i := range m.Headers {
+			m.Headers[i] = r.ReadBytes()
+		}
+	})
+}
+
+func (m *ComGetData) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddrFrom)
+	w.WriteString(m.Type)
+	w.WriteBytes(m.ID)
+
+	return w.Bytes(), nil
+}
+
+func (m *ComGetData) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrFrom = decodeAddr(r)
+		m.Type = r.ReadString()
+		m.ID = r.ReadBytes()
+	})
+}
+
+func (m *ComInv) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddrFrom)
+	w.WriteString(m.Type)
+
+	w.WriteCount(len(m.Items))
+	for _, item := range m.Items {
+		w.WriteBytes(item)
+	}
+
+	return w.Bytes(), nil
+}
+
+func (m *ComInv) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddrFrom = decodeAddr(r)
+		m.Type = r.ReadString()
+
+		m.Items = make([][]byte, r.ReadCount())
+		for i := range m.Items {
+			m.Items[i] = r.ReadBytes()
+		}
+	})
+}
+
+func (m *ComTx) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	encodeAddr(w, m.AddFrom)
+	w.WriteBytes(m.Transaction)
+
+	return w.Bytes(), nil
+}
+
+func (m *ComTx) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.AddFrom = decodeAddr(r)
+		m.Transaction = r.ReadBytes()
+	})
+}
+
+func (m *ComVersion) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	w.WriteInt(int64(m.Version))
+	w.WriteInt(int64(m.Services))
+	w.WriteInt(int64(m.BestHeight))
+	encodeAddr(w, m.AddrFrom)
+
+	return w.Bytes(), nil
+}
+
+func (m *ComVersion) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.Version = int(r.ReadInt())
+		m.Services = uint64(r.ReadInt())
+		m.BestHeight = int(r.ReadInt())
+		m.AddrFrom = decodeAddr(r)
+	})
+}
+
+func (m *ComPing) MarshalBinary() ([]byte, error) {
+	w := wire.NewWriter()
+	w.WriteInt(int64(m.Nonce))
+
+	return w.Bytes(), nil
+}
+
+func (m *ComPing) UnmarshalBinary(data []byte) error {
+	return unmarshal(data, func(r *wire.Reader) {
+		m.Nonce = uint64(r.ReadInt())
+	})
+}
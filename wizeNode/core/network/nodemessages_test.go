@@ -0,0 +1,53 @@
+package network
+
+import (
+	"encoding"
+	"reflect"
+	"testing"
+)
+
+type binaryMessage interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+func TestNodeMessagesRoundTrip(t *testing.T) {
+	addr := NodeAddr{Host: "localhost", Port: 3000}
+	hashes := [][]byte{{1, 2, 3}, {4, 5}}
+
+	tests := []struct {
+		sent, received binaryMessage
+	}{
+		{&ComAddr{AddrList: []NodeAddr{addr, {Host: "10.0.0.1", Port: 3001}}}, &ComAddr{}},
+		{&ComBlock{AddrFrom: addr, Block: []byte{1, 2, 3}}, &ComBlock{}},
+		{&ComGetBlocks{AddrFrom: addr, Locator: hashes, StopHash: []byte{6}}, &ComGetBlocks{}},
+		{&ComGetHeaders{AddrFrom: addr, Locator: hashes, StopHash: []byte{6}}, &ComGetHeaders{}},
+		{&ComHeaders{AddrFrom: addr, Headers: hashes}, &ComHeaders{}},
+		{&ComGetData{AddrFrom: addr, Type: "block", ID: []byte{7}}, &ComGetData{}},
+		{&ComInv{AddrFrom: addr, Type: "tx", Items: hashes}, &ComInv{}},
+		{&ComTx{AddFrom: addr, Transaction: []byte{8, 9}}, &ComTx{}},
+		{&ComVersion{Version: NodeVersion, Services: ServiceNode, BestHeight: 12, AddrFrom: addr}, &ComVersion{}},
+		{&ComPing{Nonce: 1<<63 + 5}, &ComPing{}},
+	}
+
+	for _, test := range tests {
+		data, err := test.sent.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary(%T) error = %v", test.sent, err)
+		}
+
+		err = test.received.UnmarshalBinary(data)
+		if err != nil || !reflect.DeepEqual(test.received, test.sent) {
+			t.Errorf("UnmarshalBinary(%T) = %+v, %v, want %+v", test.sent, test.received, err, test.sent)
+		}
+
+		// a truncated message is reported instead of decoded partly
+		if err := test.received.UnmarshalBinary(data[:len(data)-1]); err == nil {
+			t.Errorf("UnmarshalBinary(%T) of truncated data is successful", test.sent)
+		}
+	}
+
+	if got := DecodeError(EncodeError("Block is not found")); got != "Block is not found" {
+		t.Errorf("DecodeError() = %q, want %q", got, "Block is not found")
+	}
+}
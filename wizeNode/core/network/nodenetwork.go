@@ -4,13 +4,15 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"strings"
+	"sync"
 
 	"wizeBlock/wizeNode/core/log"
 )
 
 // TODO: rethink with LoadInitialNodes and Genesis
 
-const InitialNodesList = "files/initialnodes.json"
+// InitialNodesFile is the file of the nodes to connect first in the directory of the network
+const InitialNodesFile = "initialnodes.json"
 
 // Interface for extra storage for a nodes
 type NodeNetworkStorage interface {
@@ -21,9 +23,12 @@ type NodeNetworkStorage interface {
 }
 
 // This manages list of known nodes by a node
+// One instance is shared by the node handlers and the peer manager, so the list is locked
 type NodeNetwork struct {
-	Nodes   []NodeAddr
 	Storage NodeNetworkStorage
+
+	mu    sync.Mutex
+	nodes []NodeAddr
 }
 
 type NodesListJSON struct {
@@ -48,20 +53,22 @@ func (n *NodeNetwork) LoadNodes() error {
 		return err
 	}
 
-	for _, node := range nodes {
-		n.Nodes = append(n.Nodes, node)
-	}
+	n.mu.Lock()
+	n.nodes = append(n.nodes, nodes...)
+	n.mu.Unlock()
 
 	return nil
 }
 
 // Set nodes list. This can be used to do initial nodes loading from  config or so
 func (n *NodeNetwork) SetNodes(nodes []NodeAddr, replace bool) {
+	n.mu.Lock()
 	if replace {
-		n.Nodes = nodes
+		n.nodes = append([]NodeAddr{}, nodes...)
 	} else {
-		n.Nodes = append(n.Nodes, nodes...)
+		n.nodes = append(n.nodes, nodes...)
 	}
+	n.mu.Unlock()
 
 	if n.Storage != nil {
 		// remember what is not yet remembered
@@ -73,9 +80,9 @@ func (n *NodeNetwork) SetNodes(nodes []NodeAddr, replace bool) {
 
 // If n any known nodes then it will be loaded from the url on a host
 // Accepts genesis block hash. It will be compared to the hash in JSON doc
-func (n *NodeNetwork) LoadInitialNodes(exceptAddr NodeAddr) error {
-	//response, err := http.Get(InitialNodesList)
-	jsondoc, err := ioutil.ReadFile(InitialNodesList)
+func (n *NodeNetwork) LoadInitialNodes(file string, exceptAddr NodeAddr) error {
+	//response, err := http.Get(file)
+	jsondoc, err := ioutil.ReadFile(file)
 	if err != nil {
 		log.Warn.Printf("Failed with reading initial nodes: %+v", err)
 		return err
@@ -102,7 +109,9 @@ func (n *NodeNetwork) LoadInitialNodes(exceptAddr NodeAddr) error {
 
 	log.Info.Printf("Initial nodes: %+v", nodes)
 
-	n.Nodes = append(n.Nodes, nodes.Nodes...)
+	n.mu.Lock()
+	n.nodes = append(n.nodes, nodes.Nodes...)
+	n.mu.Unlock()
 
 	if n.Storage != nil {
 		// remember loaded nodes in local storage
@@ -115,29 +124,39 @@ func (n *NodeNetwork) LoadInitialNodes(exceptAddr NodeAddr) error {
 	return nil
 }
 
+// GetNodes returns a copy of the known nodes list
 func (n *NodeNetwork) GetNodes() []NodeAddr {
-	return n.Nodes
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]NodeAddr{}, n.nodes...)
 }
 
 // Returns number of known nodes
 func (n *NodeNetwork) GetCountOfKnownNodes() int {
-	l := len(n.Nodes)
+	n.mu.Lock()
+	l := len(n.nodes)
+	n.mu.Unlock()
 
 	return l
 }
 
 // Check if node address is known
 func (n *NodeNetwork) CheckIsKnown(addr NodeAddr) bool {
-	exists := false
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.find(addr) >= 0
+}
 
-	for _, node := range n.Nodes {
+func (n *NodeNetwork) find(addr NodeAddr) int {
+	for i, node := range n.nodes {
 		if node.CompareToAddress(addr) {
-			exists = true
-			break
+			return i
 		}
 	}
 
-	return exists
+	return -1
 }
 
 /*
@@ -145,17 +164,12 @@ func (n *NodeNetwork) CheckIsKnown(addr NodeAddr) bool {
 * Returns true if was added
  */
 func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
-	exists := false
-
-	for _, node := range n.Nodes {
-		if node.CompareToAddress(addr) {
-			exists = true
-			break
-		}
-	}
+	n.mu.Lock()
+	exists := n.find(addr) >= 0
 	if !exists {
-		n.Nodes = append(n.Nodes, addr)
+		n.nodes = append(n.nodes, addr)
 	}
+	n.mu.Unlock()
 
 	if n.Storage != nil {
 		n.Storage.AddNodeToKnown(addr)
@@ -168,13 +182,15 @@ func (n *NodeNetwork) AddNodeToKnown(addr NodeAddr) bool {
 func (n *NodeNetwork) RemoveNodeFromKnown(addr NodeAddr) {
 	updatedlist := []NodeAddr{}
 
-	for _, node := range n.Nodes {
+	n.mu.Lock()
+	for _, node := range n.nodes {
 		if !node.CompareToAddress(addr) {
 			updatedlist = append(updatedlist, node)
 		}
 	}
 
-	n.Nodes = updatedlist
+	n.nodes = updatedlist
+	n.mu.Unlock()
 
 	if n.Storage != nil {
 		n.Storage.RemoveNodeFromKnown(addr)
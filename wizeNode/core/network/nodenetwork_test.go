@@ -0,0 +1,43 @@
+package network
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNodeNetworkConcurrent(t *testing.T) {
+	n := &NodeNetwork{}
+	n.SetNodes([]NodeAddr{{Host: "127.0.0.1", Port: 3000}}, true)
+
+	// the handlers learn nodes while the peer manager reads and evicts them
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for port := 0; port < 50; port++ {
+				addr := NodeAddr{Host: "127.0.0.1", Port: 4000 + i*100 + port}
+				n.AddNodeToKnown(addr)
+				n.GetNodes()
+				if port%2 == 0 {
+					n.RemoveNodeFromKnown(addr)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := n.GetCountOfKnownNodes(); got != 1+4*25 {
+		t.Errorf("GetCountOfKnownNodes() = %d, want %d", got, 1+4*25)
+	}
+	if !n.CheckIsKnown(NodeAddr{Host: "127.0.0.1", Port: 3000}) {
+		t.Error("CheckIsKnown() of the initial node = false")
+	}
+
+	// the returned list is a copy
+	nodes := n.GetNodes()
+	nodes[0].Port = 1
+	if n.GetNodes()[0].Port != 3000 {
+		t.Error("GetNodes() returned the list of the network")
+	}
+}
@@ -0,0 +1,318 @@
+package network
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"wizeBlock/wizeNode/core/log"
+)
+
+const (
+	// sendQueueSize is the number of messages waiting to be written to a peer
+	sendQueueSize = 64
+	// writeTimeout is how long writing of one message can take
+	writeTimeout = 30 * time.Second
+	// dialTimeout is how long connecting to a peer can take
+	dialTimeout = 10 * time.Second
+	// RequestTimeout is how long to wait for a reply to a request
+	RequestTimeout = 30 * time.Second
+)
+
+var (
+	ErrPeerDisconnected = errors.New("Peer is disconnected")
+	ErrRequestTimeout   = errors.New("Request timed out")
+)
+
+// MessageHandler processes a message received from a peer
+// Messages of a peer are handled one by one in the order they are received,
+// so a handler must not wait for a reply from the same peer
+type MessageHandler func(p *Peer, msg *Message)
+
+// Peer is a long-lived connection to another node
+// Messages are read and written by separate goroutines, replies to requests
+// are matched by the request id and are not passed to the handler
+type Peer struct {
+	conn    net.Conn
+	magic   uint32
+	inbound bool
+	handler MessageHandler
+
+	mu      sync.Mutex
+	addr    NodeAddr
+	nextID  uint32
+	pending map[uint32]chan *Message
+
+	// the state known from the handshake and pings
+	connected       time.Time
+	lastRecv        time.Time
+	version         int
+	services        uint64
+	bestHeight      int
+	versionReceived bool
+	verackReceived  bool
+	pingNonce       uint64
+	pingSent        time.Time
+	latency         time.Duration
+
+	sendQueue chan *Message
+	quit      chan struct{}
+	closeOnce sync.Once
+	started   bool
+	stopped   chan struct{}
+}
+
+// NewPeer creates a peer for the connection, Start should be called to run it
+func NewPeer(conn net.Conn, magic uint32, inbound bool, handler MessageHandler) *Peer {
+	return &Peer{
+		conn:      conn,
+		magic:     magic,
+		inbound:   inbound,
+		handler:   handler,
+		pending:   make(map[uint32]chan *Message),
+		connected: time.Now(),
+		lastRecv:  time.Now(),
+		sendQueue: make(chan *Message, sendQueueSize),
+		quit:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+}
+
+// Start runs the read and write loops of the peer
+func (p *Peer) Start() {
+	p.mu.Lock()
+	p.started = true
+	p.mu.Unlock()
+
+	go p.readLoop()
+	go p.writeLoop()
+}
+
+// Wait waits until the read loop of the started peer returns, so the handler
+// doesn't process its messages anymore
+func (p *Peer) Wait() {
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+
+	if started {
+		<-p.stopped
+	}
+}
+
+// Addr returns the listening address of the peer node
+// It's unknown for inbound peers until the node tells it
+func (p *Peer) Addr() NodeAddr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.addr
+}
+
+// SetAddr sets the listening address of the peer node
+func (p *Peer) SetAddr(addr NodeAddr) {
+	p.mu.Lock()
+	p.addr = addr
+	p.mu.Unlock()
+}
+
+// Version returns the protocol version of the peer node
+func (p *Peer) Version() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.version
+}
+
+// Services returns the services flags of the peer node
+func (p *Peer) Services() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.services
+}
+
+// BestHeight returns the blockchain height the peer node has told about last time
+func (p *Peer) BestHeight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.bestHeight
+}
+
+// Latency returns the round trip time of the last ping
+func (p *Peer) Latency() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.latency
+}
+
+// HandshakeDone checks whether the peer has sent its version and acknowledged ours
+func (p *Peer) HandshakeDone() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.versionReceived && p.verackReceived
+}
+
+// Inbound checks whether the connection was opened by the peer
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+// RemoteAddr returns the network address of the connection
+func (p *Peer) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+// Host returns the IP of the peer, it's used for bans
+func (p *Peer) Host() string {
+	return remoteHost(p.conn)
+}
+
+// Done returns a channel which is closed when the peer is disconnected
+func (p *Peer) Done() <-chan struct{} {
+	return p.quit
+}
+
+// disconnected checks whether the peer is disconnected already
+func (p *Peer) disconnected() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Disconnect closes the connection and stops the peer loops
+func (p *Peer) Disconnect() {
+	p.closeOnce.Do(func() {
+		close(p.quit)
+		p.conn.Close()
+	})
+}
+
+// Send queues a message to the peer
+func (p *Peer) Send(command string, payload []byte) error {
+	return p.queue(&Message{Command: command, Payload: payload})
+}
+
+// Reply queues a reply to the request received from the peer
+func (p *Peer) Reply(request *Message, command string, payload []byte) error {
+	return p.queue(&Message{Command: command, ReplyTo: request.ID, Payload: payload})
+}
+
+// Request sends a message to the peer and waits for the reply
+func (p *Peer) Request(command string, payload []byte, timeout time.Duration) (*Message, error) {
+	reply := make(chan *Message, 1)
+
+	p.mu.Lock()
+	p.nextID++
+	if p.nextID == 0 {
+		p.nextID++
+	}
+	id := p.nextID
+	p.pending[id] = reply
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+
+	err := p.queue(&Message{Command: command, ID: id, Payload: payload})
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case msg := <-reply:
+		return msg, nil
+	case <-timer.C:
+		return nil, ErrRequestTimeout
+	case <-p.quit:
+		return nil, ErrPeerDisconnected
+	}
+}
+
+func remoteHost(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+
+	return host
+}
+
+func (p *Peer) queue(msg *Message) error {
+	select {
+	case p.sendQueue <- msg:
+		return nil
+	case <-p.quit:
+		return ErrPeerDisconnected
+	}
+}
+
+func (p *Peer) readLoop() {
+	defer close(p.stopped)
+	defer p.Disconnect()
+
+	r := bufio.NewReader(p.conn)
+	for {
+		msg, err := ReadMessage(r, p.magic)
+		if err != nil {
+			if err != io.EOF {
+				log.Debug.Printf("Read from %s: %s", p.conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		p.mu.Lock()
+		p.lastRecv = time.Now()
+		p.mu.Unlock()
+
+		if msg.ReplyTo != 0 {
+			p.mu.Lock()
+			reply, ok := p.pending[msg.ReplyTo]
+			delete(p.pending, msg.ReplyTo)
+			p.mu.Unlock()
+
+			if ok {
+				reply <- msg
+				continue
+			}
+		}
+
+		if p.handler != nil {
+			p.handler(p, msg)
+		}
+	}
+}
+
+func (p *Peer) writeLoop() {
+	defer p.Disconnect()
+
+	for {
+		select {
+		case msg := <-p.sendQueue:
+			p.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+			err := WriteMessage(p.conn, p.magic, msg)
+			if err != nil {
+				log.Debug.Printf("Write %s to %s: %s", msg.Command, p.conn.RemoteAddr(), err)
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
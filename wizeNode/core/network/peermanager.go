@@ -0,0 +1,559 @@
+package network
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"wizeBlock/wizeNode/core/log"
+)
+
+const (
+	// MaxInboundPeers is the number of connections other nodes can open to us
+	MaxInboundPeers = 32
+	// MaxOutboundPeers is the number of connections we open to known nodes
+	MaxOutboundPeers = 8
+
+	// peerCheckInterval is how often peers are pinged and known nodes are connected
+	peerCheckInterval = 5 * time.Second
+	// handshakeTimeout is how long a peer can take to complete the handshake
+	handshakeTimeout = 30 * time.Second
+	// pingInterval is how often peers are pinged
+	pingInterval = 30 * time.Second
+	// peerTimeout is how long a peer can be silent before it's considered dead
+	peerTimeout = 3 * pingInterval
+
+	// connectRetryBase is the delay after the first failed connection, it's doubled after each failure
+	connectRetryBase = 5 * time.Second
+	// connectRetryMax is the longest delay between connection attempts
+	connectRetryMax = 5 * time.Minute
+	// maxConnectFailures is the number of failed connections after which a node is forgotten
+	maxConnectFailures = 5
+)
+
+var (
+	ErrTooManyPeers = errors.New("Too many peers")
+	ErrBanned       = errors.New("Node is banned")
+	ErrClosed       = errors.New("Peer manager is closed")
+)
+
+type connectAttempt struct {
+	failures   int
+	next       time.Time
+	connecting bool
+}
+
+// PeerManager keeps connections to other nodes, one connection is used for all messages to a node
+// A connection is usable after the handshake: both sides send their version and acknowledge
+// the version of the other side with verack. Peers are pinged to detect dead connections,
+// known nodes are connected to keep MaxOutboundPeers connections, nodes which can't be
+// connected several times in a row are removed from the known nodes.
+type PeerManager struct {
+	magic   uint32
+	network *NodeNetwork
+	bans    *BanManager
+
+	mu           sync.Mutex
+	handler      MessageHandler
+	localVersion func() *ComVersion
+	peers        []*Peer
+	attempts     map[string]*connectAttempt
+	closed       bool
+}
+
+func NewPeerManager(magic uint32, network *NodeNetwork, bans *BanManager) *PeerManager {
+	return &PeerManager{
+		magic:    magic,
+		network:  network,
+		bans:     bans,
+		attempts: make(map[string]*connectAttempt),
+	}
+}
+
+// Bans returns the ban manager of the peers
+func (pm *PeerManager) Bans() *BanManager {
+	return pm.bans
+}
+
+// Misbehaving adds the score to the host of the peer, all peers of the host
+// are disconnected when it gets banned
+func (pm *PeerManager) Misbehaving(p *Peer, score int, reason string) {
+	host := p.Host()
+	if !pm.bans.Misbehaving(host, score, reason) {
+		return
+	}
+
+	pm.DisconnectHost(host)
+}
+
+// DisconnectHost disconnects all peers with the IP
+func (pm *PeerManager) DisconnectHost(host string) {
+	for _, peer := range pm.All() {
+		if peer.Host() == host {
+			peer.Disconnect()
+		}
+	}
+}
+
+// SetHandler sets the handler of messages received from peers after the handshake
+func (pm *PeerManager) SetHandler(handler MessageHandler) {
+	pm.mu.Lock()
+	pm.handler = handler
+	pm.mu.Unlock()
+}
+
+// SetLocalVersion sets the function which returns the version message of this node
+func (pm *PeerManager) SetLocalVersion(localVersion func() *ComVersion) {
+	pm.mu.Lock()
+	pm.localVersion = localVersion
+	pm.mu.Unlock()
+}
+
+// Start pings peers and connects known nodes until the stop channel is closed
+func (pm *PeerManager) Start(stop chan struct{}) {
+	ticker := time.NewTicker(peerCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			pm.check()
+		}
+	}
+}
+
+// Accept starts a peer for the connection opened by another node
+// The connection is closed when there are too many inbound peers
+func (pm *PeerManager) Accept(conn net.Conn) *Peer {
+	if pm.bans.IsBanned(remoteHost(conn)) {
+		log.Debug.Printf("Reject connection from %s: banned", conn.RemoteAddr())
+		conn.Close()
+		return nil
+	}
+
+	pm.mu.Lock()
+	if pm.closed || pm.count(true) >= MaxInboundPeers {
+		pm.mu.Unlock()
+
+		log.Info.Printf("Reject connection from %s: too many inbound peers", conn.RemoteAddr())
+		conn.Close()
+		return nil
+	}
+
+	p := NewPeer(conn, pm.magic, true, pm.handle)
+	pm.peers = append(pm.peers, p)
+	pm.mu.Unlock()
+
+	pm.start(p)
+
+	return p
+}
+
+// Connect returns the peer connected to the node, a new connection is opened if there is none
+// An existing connection is used even when there are no free outbound slots,
+// so the nodes which have connected to us can be answered
+func (pm *PeerManager) Connect(addr NodeAddr) (*Peer, error) {
+	pm.mu.Lock()
+	found := pm.find(addr)
+	full := pm.count(false) >= MaxOutboundPeers
+	closed := pm.closed
+	pm.mu.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+	if found != nil {
+		return found, nil
+	}
+	if full {
+		return nil, ErrTooManyPeers
+	}
+
+	if pm.bans.IsBanned(addr.Host) {
+		return nil, ErrBanned
+	}
+
+	conn, err := net.DialTimeout(Protocol, addr.String(), dialTimeout)
+	if err != nil {
+		pm.connectFailed(addr)
+		return nil, err
+	}
+
+	if pm.bans.IsBanned(remoteHost(conn)) {
+		conn.Close()
+		return nil, ErrBanned
+	}
+
+	p := NewPeer(conn, pm.magic, false, pm.handle)
+	p.SetAddr(addr)
+	// the side which has opened the connection starts the handshake,
+	// the version is queued before any other message
+	pm.sendVersion(p)
+
+	pm.mu.Lock()
+	if pm.closed {
+		pm.mu.Unlock()
+		conn.Close()
+		return nil, ErrClosed
+	}
+	// another goroutine could connect to the node in the meantime
+	if found := pm.find(addr); found != nil {
+		pm.mu.Unlock()
+		conn.Close()
+		return found, nil
+	}
+
+	pm.peers = append(pm.peers, p)
+	pm.mu.Unlock()
+
+	pm.start(p)
+
+	return p, nil
+}
+
+// Find returns the peer connected to the node or nil
+func (pm *PeerManager) Find(addr NodeAddr) *Peer {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	return pm.find(addr)
+}
+
+// All returns all connected peers
+func (pm *PeerManager) All() []*Peer {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	return append([]*Peer{}, pm.peers...)
+}
+
+// Close disconnects all peers and waits until their messages are handled,
+// no peers are accepted or connected after that
+func (pm *PeerManager) Close() {
+	pm.mu.Lock()
+	pm.closed = true
+	pm.mu.Unlock()
+
+	peers := pm.All()
+	for _, p := range peers {
+		p.Disconnect()
+	}
+	for _, p := range peers {
+		p.Wait()
+	}
+}
+
+// handle processes the handshake and ping messages and passes the rest to the handler
+func (pm *PeerManager) handle(p *Peer, msg *Message) {
+	switch msg.Command {
+	case "version":
+		if !pm.handleVersion(p, msg) {
+			return
+		}
+	case "verack":
+		p.mu.Lock()
+		p.verackReceived = true
+		p.mu.Unlock()
+
+		if !p.Inbound() {
+			// the node is reachable again, so the failures are forgotten
+			pm.mu.Lock()
+			delete(pm.attempts, p.Addr().String())
+			pm.mu.Unlock()
+		}
+		return
+	case "ping":
+		p.Send("pong", msg.Payload)
+		return
+	case "pong":
+		pm.handlePong(p, msg)
+		return
+	default:
+		p.mu.Lock()
+		versionReceived := p.versionReceived
+		p.mu.Unlock()
+
+		if !versionReceived {
+			log.Debug.Printf("Ignore %s from %s before the handshake", msg.Command, p.RemoteAddr())
+			return
+		}
+	}
+
+	pm.mu.Lock()
+	handler := pm.handler
+	pm.mu.Unlock()
+
+	if handler != nil {
+		handler(p, msg)
+	}
+}
+
+// handleVersion remembers the peer version and answers it during the handshake
+// False is returned when the peer is disconnected
+func (pm *PeerManager) handleVersion(p *Peer, msg *Message) bool {
+	var payload ComVersion
+	err := payload.UnmarshalBinary(msg.Payload)
+	if err != nil {
+		log.Info.Printf("Disconnect %s: wrong version message: %s", p.RemoteAddr(), err)
+		p.Disconnect()
+		return false
+	}
+
+	if payload.Version < MinNodeVersion {
+		log.Info.Printf("Disconnect %s: protocol version %d is too old", p.RemoteAddr(), payload.Version)
+		p.Disconnect()
+		return false
+	}
+
+	if payload.AddrFrom.CompareToAddress(pm.localAddr()) {
+		log.Info.Printf("Disconnect %s: connected to itself", p.RemoteAddr())
+		p.Disconnect()
+		return false
+	}
+
+	p.mu.Lock()
+	first := !p.versionReceived
+	p.versionReceived = true
+	p.version = payload.Version
+	p.services = payload.Services
+	p.bestHeight = payload.BestHeight
+	p.mu.Unlock()
+
+	if first {
+		if p.Inbound() {
+			pm.bind(p, payload.AddrFrom)
+			pm.sendVersion(p)
+		}
+		p.Send("verack", nil)
+	}
+
+	return true
+}
+
+func (pm *PeerManager) handlePong(p *Peer, msg *Message) {
+	var payload ComPing
+	err := payload.UnmarshalBinary(msg.Payload)
+	if err != nil {
+		log.Debug.Printf("Wrong pong from %s: %s", p.RemoteAddr(), err)
+		return
+	}
+
+	p.mu.Lock()
+	if payload.Nonce == p.pingNonce && !p.pingSent.IsZero() {
+		p.latency = time.Since(p.pingSent)
+	}
+	p.mu.Unlock()
+}
+
+// check disconnects dead peers, pings the rest and connects known nodes
+func (pm *PeerManager) check() {
+	now := time.Now()
+
+	for _, p := range pm.All() {
+		p.mu.Lock()
+		handshakeDone := p.versionReceived && p.verackReceived
+		silence := now.Sub(p.lastRecv)
+		age := now.Sub(p.connected)
+		pingDue := handshakeDone && now.Sub(p.pingSent) >= pingInterval
+		if pingDue {
+			p.pingNonce = uint64(rand.Int63())
+			p.pingSent = now
+		}
+		nonce := p.pingNonce
+		p.mu.Unlock()
+
+		switch {
+		case !handshakeDone && age > handshakeTimeout:
+			log.Info.Printf("Disconnect %s: no handshake for %s", p.RemoteAddr(), age)
+			p.Disconnect()
+		case silence > peerTimeout:
+			log.Info.Printf("Disconnect %s: no messages for %s", p.RemoteAddr(), silence)
+			p.Disconnect()
+		case pingDue:
+			ping := ComPing{nonce}
+			payload, _ := ping.MarshalBinary()
+			p.Send("ping", payload)
+		}
+	}
+
+	pm.connectKnownNodes(now)
+}
+
+// connectKnownNodes opens connections to known nodes while there are free outbound slots
+func (pm *PeerManager) connectKnownNodes(now time.Time) {
+	if pm.network == nil {
+		return
+	}
+
+	local := pm.localAddr()
+
+	for _, addr := range pm.network.GetNodes() {
+		pm.mu.Lock()
+		if pm.count(false)+pm.connecting() >= MaxOutboundPeers {
+			pm.mu.Unlock()
+			return
+		}
+
+		if addr.CompareToAddress(local) || pm.find(addr) != nil {
+			pm.mu.Unlock()
+			continue
+		}
+
+		attempt := pm.attempt(addr)
+		if attempt.connecting || now.Before(attempt.next) {
+			pm.mu.Unlock()
+			continue
+		}
+		attempt.connecting = true
+		pm.mu.Unlock()
+
+		go func(addr NodeAddr) {
+			_, err := pm.Connect(addr)
+			if err != nil {
+				log.Debug.Printf("Connect %s: %s", addr, err)
+			}
+
+			pm.mu.Lock()
+			if attempt, ok := pm.attempts[addr.String()]; ok {
+				attempt.connecting = false
+			}
+			pm.mu.Unlock()
+		}(addr)
+	}
+}
+
+// connectFailed delays the next connection to the node, the node is forgotten
+// after maxConnectFailures failures in a row
+func (pm *PeerManager) connectFailed(addr NodeAddr) {
+	pm.mu.Lock()
+	attempt := pm.attempt(addr)
+	attempt.failures++
+
+	evict := attempt.failures >= maxConnectFailures
+	if evict {
+		delete(pm.attempts, addr.String())
+	} else {
+		delay := connectRetryBase << uint(attempt.failures-1)
+		if delay > connectRetryMax {
+			delay = connectRetryMax
+		}
+		attempt.next = time.Now().Add(delay)
+	}
+	pm.mu.Unlock()
+
+	if evict && pm.network != nil {
+		log.Info.Printf("Remove %s from known nodes: %d failed connections", addr, maxConnectFailures)
+		pm.network.RemoveNodeFromKnown(addr)
+	}
+}
+
+func (pm *PeerManager) sendVersion(p *Peer) {
+	pm.mu.Lock()
+	localVersion := pm.localVersion
+	pm.mu.Unlock()
+
+	if localVersion == nil {
+		return
+	}
+
+	payload, err := localVersion().MarshalBinary()
+	if err != nil {
+		log.Warn.Printf("Version message: %s", err)
+		return
+	}
+	p.Send("version", payload)
+}
+
+func (pm *PeerManager) localAddr() NodeAddr {
+	pm.mu.Lock()
+	localVersion := pm.localVersion
+	pm.mu.Unlock()
+
+	if localVersion == nil {
+		return NodeAddr{}
+	}
+
+	return localVersion().AddrFrom
+}
+
+// bind sets the listening address of an inbound peer, so messages to the node
+// are sent over the connection it has opened
+func (pm *PeerManager) bind(p *Peer, addr NodeAddr) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.find(addr) == nil {
+		p.SetAddr(addr)
+	}
+}
+
+// find returns the connected peer of the node, the disconnected peers
+// which are not forgotten yet are skipped
+func (pm *PeerManager) find(addr NodeAddr) *Peer {
+	for _, p := range pm.peers {
+		if !p.disconnected() && p.Addr().CompareToAddress(addr) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+func (pm *PeerManager) count(inbound bool) int {
+	n := 0
+	for _, p := range pm.peers {
+		if p.Inbound() == inbound && !p.disconnected() {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (pm *PeerManager) connecting() int {
+	n := 0
+	for _, attempt := range pm.attempts {
+		if attempt.connecting {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (pm *PeerManager) attempt(addr NodeAddr) *connectAttempt {
+	attempt, ok := pm.attempts[addr.String()]
+	if !ok {
+		attempt = &connectAttempt{}
+		pm.attempts[addr.String()] = attempt
+	}
+
+	return attempt
+}
+
+// start runs the peer and forgets it when it's disconnected
+func (pm *PeerManager) start(p *Peer) {
+	p.Start()
+
+	go func() {
+		<-p.Done()
+
+		pm.mu.Lock()
+		for i, peer := range pm.peers {
+			if peer == p {
+				pm.peers = append(pm.peers[:i], pm.peers[i+1:]...)
+				break
+			}
+		}
+		pm.mu.Unlock()
+
+		// a node which drops our connections is retried with the backoff
+		if !p.Inbound() && !p.HandshakeDone() {
+			pm.connectFailed(p.Addr())
+		}
+	}()
+}
@@ -0,0 +1,268 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, pm *PeerManager) (net.Listener, NodeAddr) {
+	ln, err := net.Listen(Protocol, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			pm.Accept(conn)
+		}
+	}()
+
+	tcpAddr := ln.Addr().(*net.TCPAddr)
+	return ln, NodeAddr{Host: "127.0.0.1", Port: tcpAddr.Port}
+}
+
+func newTestPeerManager(addr NodeAddr, version int, received chan string) *PeerManager {
+	pm := NewPeerManager(NetworkMagic, nil, NewBanManager(nil))
+	pm.SetLocalVersion(func() *ComVersion {
+		return &ComVersion{Version: version, Services: ServiceNode, BestHeight: 5, AddrFrom: addr}
+	})
+	pm.SetHandler(func(p *Peer, msg *Message) {
+		received <- msg.Command
+	})
+
+	return pm
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPeerManagerHandshake(t *testing.T) {
+	received := make(chan string, 10)
+
+	server := newTestPeerManager(NodeAddr{}, NodeVersion, received)
+	ln, serverAddr := listen(t, server)
+	defer ln.Close()
+	server.SetLocalVersion(func() *ComVersion {
+		return &ComVersion{Version: NodeVersion, Services: ServiceNode, BestHeight: 5, AddrFrom: serverAddr}
+	})
+	defer server.Close()
+
+	clientAddr := NodeAddr{Host: "127.0.0.1", Port: 1}
+	client := newTestPeerManager(clientAddr, NodeVersion, received)
+	defer client.Close()
+
+	peer, err := client.Connect(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the handshake", peer.HandshakeDone)
+
+	if peer.Version() != NodeVersion || peer.BestHeight() != 5 || peer.Services() != ServiceNode {
+		t.Errorf("Peer version %d, height %d, services %d", peer.Version(), peer.BestHeight(), peer.Services())
+	}
+
+	// the inbound peer is found by the address from its version
+	waitFor(t, "the inbound peer", func() bool {
+		p := server.Find(clientAddr)
+		return p != nil && p.HandshakeDone()
+	})
+
+	// versions are passed to the handler too, but not the handshake and ping messages
+	peer.Send("inv", nil)
+	for _, want := range []string{"version", "version", "inv"} {
+		if command := <-received; command != want {
+			t.Errorf("Handler received %s, want %s", command, want)
+		}
+	}
+
+	client.check()
+	waitFor(t, "the pong", func() bool { return peer.Latency() > 0 })
+}
+
+func TestPeerManagerOldVersion(t *testing.T) {
+	received := make(chan string, 10)
+
+	server := newTestPeerManager(NodeAddr{Host: "127.0.0.1", Port: 2}, NodeVersion, received)
+	ln, serverAddr := listen(t, server)
+	defer ln.Close()
+	defer server.Close()
+
+	client := newTestPeerManager(NodeAddr{Host: "127.0.0.1", Port: 1}, MinNodeVersion-1, received)
+	defer client.Close()
+
+	peer, err := client.Connect(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-peer.Done():
+	case <-time.After(2 * time.Second):
+		t.Error("Peer with an old version is not disconnected")
+	}
+}
+
+func TestPeerManagerBan(t *testing.T) {
+	received := make(chan string, 10)
+
+	serverAddr := NodeAddr{Host: "127.0.0.1", Port: 2}
+	server := newTestPeerManager(serverAddr, NodeVersion, received)
+	ln, addr := listen(t, server)
+	defer ln.Close()
+	defer server.Close()
+
+	client := newTestPeerManager(NodeAddr{Host: "127.0.0.1", Port: 1}, NodeVersion, received)
+	defer client.Close()
+
+	peer, err := client.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the inbound peer", func() bool { return len(server.All()) == 1 })
+
+	inbound := server.All()[0]
+	server.Misbehaving(inbound, BanThreshold-1, "test")
+	if server.Bans().IsBanned("127.0.0.1") {
+		t.Fatal("Host is banned below the threshold")
+	}
+
+	server.Misbehaving(inbound, 1, "test")
+	if !server.Bans().IsBanned("127.0.0.1") {
+		t.Fatal("Host is not banned at the threshold")
+	}
+
+	select {
+	case <-peer.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("Banned peer is not disconnected")
+	}
+
+	// connections from the banned host are closed right away
+	peer, err = client.Connect(addr)
+	if err == nil {
+		select {
+		case <-peer.Done():
+		case <-time.After(2 * time.Second):
+			t.Fatal("Connection from a banned host is accepted")
+		}
+	}
+
+	server.Bans().Unban("127.0.0.1")
+	if server.Bans().IsBanned("127.0.0.1") {
+		t.Error("Host is banned after unban")
+	}
+}
+
+func TestPeerManagerConnectWhenFull(t *testing.T) {
+	received := make(chan string, 10)
+
+	server := newTestPeerManager(NodeAddr{Host: "127.0.0.1", Port: 2}, NodeVersion, received)
+	ln, serverAddr := listen(t, server)
+	defer ln.Close()
+	defer server.Close()
+
+	// the outbound slots of the server are taken
+	var outbound []*Peer
+	for i := 0; i < MaxOutboundPeers; i++ {
+		conn, _ := net.Pipe()
+		p := NewPeer(conn, NetworkMagic, false, nil)
+		p.SetAddr(NodeAddr{Host: "10.0.0.1", Port: 3000 + i})
+		outbound = append(outbound, p)
+	}
+	server.mu.Lock()
+	server.peers = append(server.peers, outbound...)
+	server.mu.Unlock()
+
+	clientAddr := NodeAddr{Host: "127.0.0.1", Port: 1}
+	client := newTestPeerManager(clientAddr, NodeVersion, received)
+	defer client.Close()
+
+	if _, err := client.Connect(serverAddr); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the inbound peer", func() bool {
+		p := server.Find(clientAddr)
+		return p != nil && p.HandshakeDone()
+	})
+
+	// the node connected to us is answered over its connection
+	p, err := server.Connect(clientAddr)
+	if err != nil || !p.Inbound() {
+		t.Errorf("Connect(inbound peer) = %v, %v, want the inbound peer", p, err)
+	}
+	if _, err := server.Connect(NodeAddr{Host: "127.0.0.1", Port: 3}); err != ErrTooManyPeers {
+		t.Errorf("Connect(new node) error = %v, want %v", err, ErrTooManyPeers)
+	}
+
+	// the disconnected peers don't take the slots until they are forgotten
+	outbound[0].Disconnect()
+	if p := server.Find(outbound[0].Addr()); p != nil {
+		t.Error("Find() returned a disconnected peer")
+	}
+	if _, err := server.Connect(NodeAddr{Host: "127.0.0.1", Port: 3}); err == ErrTooManyPeers {
+		t.Errorf("Connect(new node) error = %v with a free slot", err)
+	}
+}
+
+func TestPeerManagerClose(t *testing.T) {
+	received := make(chan string, 10)
+
+	server := newTestPeerManager(NodeAddr{Host: "127.0.0.1", Port: 2}, NodeVersion, received)
+	ln, serverAddr := listen(t, server)
+	defer ln.Close()
+
+	// the handler is busy with a message when the server is closed
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	server.SetHandler(func(p *Peer, msg *Message) {
+		if msg.Command == "inv" {
+			close(handling)
+			<-release
+		}
+	})
+
+	client := newTestPeerManager(NodeAddr{Host: "127.0.0.1", Port: 1}, NodeVersion, received)
+	defer client.Close()
+
+	peer, err := client.Connect(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the handshake", peer.HandshakeDone)
+	peer.Send("inv", nil)
+	<-handling
+
+	closed := make(chan struct{})
+	go func() {
+		server.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("Close() returned before the handler")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close() didn't return after the handler")
+	}
+
+	if _, err := server.Connect(NodeAddr{Host: "127.0.0.1", Port: 1}); err != ErrClosed {
+		t.Errorf("Connect() after Close() error = %v, want %v", err, ErrClosed)
+	}
+}
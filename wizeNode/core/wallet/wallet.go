@@ -27,7 +27,7 @@ func CreateWallet(privateKey []byte) (*Wallet, error) {
 		fmt.Printf("Cant generate keys: %s", err)
 		return nil, err
 	}
-	public := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	public := crypto.SerializePublicKey(&private.PublicKey)
 	wallet := Wallet{*private, public}
 
 	return &wallet, nil
@@ -38,6 +38,11 @@ func (w Wallet) GetAddress() []byte {
 	return crypto.GetAddress(w.PublicKey)
 }
 
+// GetVersionedAddress returns the wallet address in the network with the address version
+func (w Wallet) GetVersionedAddress(version byte) []byte {
+	return crypto.EncodeAddress(version, crypto.HashPubKey(w.PublicKey))
+}
+
 func (w Wallet) GetPrivateKey() []byte {
 	return w.PrivateKey.D.Bytes()
 }
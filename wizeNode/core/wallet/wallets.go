@@ -8,12 +8,13 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 )
 
 // FIXME: wallet.dat should be only for central nodes (masternode?) and miner nodes(?)
 // NOW: admin (master) wallet/address
 // NOW: miner wallet/address
-const walletFile = "files/wallet%s/wallet.dat"
+const walletFile = "wallet%s/wallet.dat"
 
 // USECASE: just for deprecated functions like
 // REST: deprecatedWalletCreate
@@ -23,26 +24,24 @@ const walletFile = "files/wallet%s/wallet.dat"
 // CLI: getWallet
 
 // Wallets stores a collection of wallets
+// The addresses of the new wallets get the address version of the network
 type Wallets struct {
 	Wallets    map[string]*Wallet
 	walletFile string
+	version    byte
 }
 
-// NewWallets creates Wallets and fills it from a file if it exists
-func NewWallets(nodeID string) (*Wallets, error) {
-	wallets := Wallets{}
-	wallets.Wallets = make(map[string]*Wallet)
-	wallets.walletFile = walletFile
-
-	err := wallets.LoadFromFile(nodeID)
-
-	return &wallets, err
+// NewWallets creates Wallets of the node in the data directory of the network
+// and fills it from a file if it exists
+func NewWallets(dataDir, nodeID string, version byte) (*Wallets, error) {
+	return NewWalletsExt(dataDir+walletFile, nodeID, version)
 }
 
-func NewWalletsExt(file, nodeID string) (*Wallets, error) {
+func NewWalletsExt(file, nodeID string, version byte) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
 	wallets.walletFile = file
+	wallets.version = version
 
 	err := wallets.LoadFromFile(nodeID)
 
@@ -52,7 +51,7 @@ func NewWalletsExt(file, nodeID string) (*Wallets, error) {
 // CreateWallet adds a Wallet to Wallets
 func (ws *Wallets) CreateWallet() string {
 	wallet := NewWallet()
-	address := fmt.Sprintf("%s", wallet.GetAddress())
+	address := fmt.Sprintf("%s", wallet.GetVersionedAddress(ws.version))
 
 	ws.Wallets[address] = wallet
 
@@ -116,6 +115,12 @@ func (ws Wallets) SaveToFile(nodeID string) {
 		log.Panic(err)
 	}
 
+	// the directories of a new network don't exist yet
+	err = os.MkdirAll(filepath.Dir(walletFile), 0755)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
 	if err != nil {
 		log.Panic(err)
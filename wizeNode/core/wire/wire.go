@@ -0,0 +1,191 @@
+// Package wire implements the canonical binary encoding of blocks,
+// transactions and network messages.
+//
+// Every encoded value starts with a 4 byte header: the magic bytes
+// 0xF0 0x57 0x42 ("\xF0WB") followed by the format version. The first byte
+// can't start a gob stream, so data stored before the format was introduced
+// can be told apart and rejected.
+//
+// The fields follow the header in the order they are declared:
+//
+//	integer      8 bytes, signed, little-endian
+//	byte string  4 bytes length (unsigned, little-endian), then the bytes
+//	string       same as byte string, UTF-8
+//	list         4 bytes count (unsigned, little-endian), then the items
+//
+// Empty and missing byte strings are encoded the same way, as a zero length.
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version is the current format version
+const Version = 1
+
+// MaxLength is the largest byte string or list which is accepted by the decoder
+const MaxLength = 32 * 1024 * 1024
+
+var magic = []byte{0xF0, 'W', 'B'}
+
+// ErrNotEncoded is returned when data doesn't start with the format header
+var ErrNotEncoded = errors.New("wire: data is not in canonical encoding")
+
+// IsEncoded checks whether data starts with the format header
+func IsEncoded(data []byte) bool {
+	return len(data) > len(magic) && bytes.HasPrefix(data, magic)
+}
+
+// Writer builds an encoded value
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// NewWriter returns a Writer with the header already written
+func NewWriter() *Writer {
+	w := &Writer{}
+	w.buf.Write(magic)
+	w.buf.WriteByte(Version)
+
+	return w
+}
+
+// WriteInt writes an integer
+func (w *Writer) WriteInt(v int64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(v))
+	w.buf.Write(b[:])
+}
+
+// WriteCount writes a length or a number of list items
+func (w *Writer) WriteCount(n int) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(n))
+	w.buf.Write(b[:])
+}
+
+// WriteBytes writes a byte string
+func (w *Writer) WriteBytes(data []byte) {
+	w.WriteCount(len(data))
+	w.buf.Write(data)
+}
+
+// WriteString writes a string
+func (w *Writer) WriteString(s string) {
+	w.WriteCount(len(s))
+	w.buf.WriteString(s)
+}
+
+// Bytes returns the encoded value
+func (w *Writer) Bytes() []byte {
+	return w.buf.Bytes()
+}
+
+// Reader decodes an encoded value
+// The first error is kept and returned by Err, reads after it return zero values
+type Reader struct {
+	r       *bytes.Reader
+	version byte
+	err     error
+}
+
+// NewReader checks the header and returns a Reader positioned at the first field
+func NewReader(data []byte) (*Reader, error) {
+	if !IsEncoded(data) {
+		return nil, ErrNotEncoded
+	}
+
+	version := data[len(magic)]
+	if version == 0 || version > Version {
+		return nil, fmt.Errorf("wire: unsupported format version %d", version)
+	}
+
+	return &Reader{r: bytes.NewReader(data[len(magic)+1:]), version: version}, nil
+}
+
+// Version returns the format version of the value
+func (r *Reader) Version() int {
+	return int(r.version)
+}
+
+// ReadInt reads an integer
+func (r *Reader) ReadInt() int64 {
+	var b [8]byte
+	if !r.read(b[:]) {
+		return 0
+	}
+
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// ReadCount reads a length or a number of list items
+// Every item takes at least one byte, so counts above the rest of the data are rejected
+func (r *Reader) ReadCount() int {
+	var b [4]byte
+	if !r.read(b[:]) {
+		return 0
+	}
+
+	n := binary.LittleEndian.Uint32(b[:])
+	if n > MaxLength || int64(n) > int64(r.r.Len()) {
+		r.err = fmt.Errorf("wire: length %d is out of range", n)
+		return 0
+	}
+
+	return int(n)
+}
+
+// ReadBytes reads a byte string, an empty one is returned as nil
+func (r *Reader) ReadBytes() []byte {
+	n := r.ReadCount()
+	if n == 0 {
+		return nil
+	}
+
+	data := make([]byte, n)
+	if !r.read(data) {
+		return nil
+	}
+
+	return data
+}
+
+// ReadString reads a string
+func (r *Reader) ReadString() string {
+	return string(r.ReadBytes())
+}
+
+// Err returns the first error which happened while reading
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close checks that the whole value was read without errors
+func (r *Reader) Close() error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.r.Len() > 0 {
+		return fmt.Errorf("wire: %d unexpected bytes at the end", r.r.Len())
+	}
+
+	return nil
+}
+
+func (r *Reader) read(b []byte) bool {
+	if r.err != nil {
+		return false
+	}
+
+	_, err := io.ReadFull(r.r, b)
+	if err != nil {
+		r.err = fmt.Errorf("wire: unexpected end of data")
+		return false
+	}
+
+	return true
+}
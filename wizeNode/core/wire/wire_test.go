@@ -0,0 +1,70 @@
+package wire
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	w := NewWriter()
+	w.WriteInt(-1)
+	w.WriteBytes([]byte{1, 2, 3})
+	w.WriteBytes(nil)
+	w.WriteString("addr")
+	w.WriteCount(2)
+
+	r, err := NewReader(w.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v := r.ReadInt(); v != -1 {
+		t.Errorf("ReadInt() = %d, want -1", v)
+	}
+	if v := r.ReadBytes(); !bytes.Equal(v, []byte{1, 2, 3}) {
+		t.Errorf("ReadBytes() = %x, want 010203", v)
+	}
+	if v := r.ReadBytes(); v != nil {
+		t.Errorf("ReadBytes() = %x, want nil", v)
+	}
+	if v := r.ReadString(); v != "addr" {
+		t.Errorf("ReadString() = %q, want addr", v)
+	}
+
+	// the count is larger than the rest of the data
+	if v := r.ReadCount(); v != 0 || r.Err() == nil {
+		t.Errorf("ReadCount() = %d, %v, want an error", v, r.Err())
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	w := NewWriter()
+	w.WriteInt(1)
+	w.WriteString("ab")
+
+	expected := []byte{
+		0xF0, 'W', 'B', Version,
+		1, 0, 0, 0, 0, 0, 0, 0,
+		2, 0, 0, 0, 'a', 'b',
+	}
+	if !bytes.Equal(w.Bytes(), expected) {
+		t.Errorf("Bytes() = %x, want %x", w.Bytes(), expected)
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	if _, err := NewReader([]byte{0x0F, 0xFF, 0x81, 0x03}); err != ErrNotEncoded {
+		t.Errorf("NewReader(gob) error = %v, want ErrNotEncoded", err)
+	}
+	if _, err := NewReader([]byte{0xF0, 'W', 'B', Version + 1}); err == nil {
+		t.Error("NewReader() accepted unknown version")
+	}
+
+	w := NewWriter()
+	w.WriteInt(1)
+	r, _ := NewReader(append(w.Bytes(), 0))
+	r.ReadInt()
+	if r.Close() == nil {
+		t.Error("Close() accepted trailing bytes")
+	}
+}
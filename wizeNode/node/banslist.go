@@ -0,0 +1,89 @@
+package node
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const bansFileName = "bans.db"
+const bansBucket = "bans"
+
+// BansListStorage keeps banned hosts with the ban expiration time as unix seconds
+type BansListStorage struct {
+	DataDir string
+}
+
+func (s BansListStorage) GetBans() (map[string]time.Time, error) {
+	db, err := s.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	bans := make(map[string]time.Time)
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bansBucket))
+
+		return b.ForEach(func(k, v []byte) error {
+			until, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return err
+			}
+			bans[string(k)] = time.Unix(until, 0)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
+
+func (s BansListStorage) AddBan(host string, until time.Time) error {
+	db, err := s.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bansBucket))
+
+		return b.Put([]byte(host), []byte(strconv.FormatInt(until.Unix(), 10)))
+	})
+}
+
+func (s BansListStorage) RemoveBan(host string) error {
+	db, err := s.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bansBucket))
+
+		return b.Delete([]byte(host))
+	})
+}
+
+func (s BansListStorage) openDB() (*bolt.DB, error) {
+	db, err := bolt.Open(s.DataDir+bansFileName, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bansBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,108 @@
+package node
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/mempool"
+	"wizeBlock/wizeNode/core/wire"
+)
+
+const mempoolFileName = "mempool.db"
+const mempoolBucket = "mempool"
+
+// MempoolStorage keeps the mempool transactions with the time they were added
+// The keys are the positions in the pool, so parents are loaded before their children
+type MempoolStorage struct {
+	DataDir string
+}
+
+func (s MempoolStorage) Load() ([]mempool.StoredTransaction, error) {
+	db, err := s.openDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var txs []mempool.StoredTransaction
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(mempoolBucket))
+
+		return b.ForEach(func(k, v []byte) error {
+			r, err := wire.NewReader(v)
+			if err != nil {
+				return err
+			}
+			added := r.ReadInt()
+			transaction := blockchain.DeserializeTransaction(r.ReadBytes())
+			err = r.Close()
+			if err != nil {
+				return err
+			}
+
+			txs = append(txs, mempool.StoredTransaction{Tx: &transaction, Added: time.Unix(0, added)})
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+func (s MempoolStorage) Save(txs []mempool.StoredTransaction) error {
+	db, err := s.openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+		b, err := tx.CreateBucket([]byte(mempoolBucket))
+		if err != nil {
+			return err
+		}
+
+		for i, stored := range txs {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+
+			w := wire.NewWriter()
+			w.WriteInt(stored.Added.UnixNano())
+			w.WriteBytes(stored.Tx.Serialize())
+
+			err = b.Put(key, w.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s MempoolStorage) openDB() (*bolt.DB, error) {
+	db, err := bolt.Open(s.DataDir+mempoolFileName, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(mempoolBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
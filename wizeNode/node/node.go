@@ -7,8 +7,10 @@ import (
 	"syscall"
 
 	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/chaincfg"
 	"wizeBlock/wizeNode/core/log"
 	"wizeBlock/wizeNode/core/network"
+	"wizeBlock/wizeNode/core/wallet"
 )
 
 // DOING: refactoring
@@ -30,8 +32,11 @@ type Node struct {
 	// FIXME: deprecated in 0.3
 	NodeID string
 
+	// params are the rules, the magic and the files of the network the node works in
+	params *chaincfg.ChainParams
+
 	NodeAddress network.NodeAddr
-	Network     network.NodeNetwork
+	Network     *network.NodeNetwork // shared with the peer manager and the cloned nodes
 	Client      *network.NodeClient
 	Server      *NodeServer
 
@@ -40,20 +45,25 @@ type Node struct {
 
 	// FIXME: NodeBlockchain, NodeTransactions
 	blockchain  *blockchain.Blockchain
-	preparedTxs map[string]*PreparedTransaction
+	preparedTxs *preparedTxs
 }
 
 // TODO: minerWalletAddress should be in the Node struct
-func NewNode(nodeID string, nodeAddr network.NodeAddr, apiAddr, minerWalletAddress string) *Node {
+// The mempool is saved into the node data directory between restarts when persistMempool is set
+func NewNode(params *chaincfg.ChainParams, nodeID string, nodeAddr network.NodeAddr, apiAddr, minerWalletAddress string, persistMempool bool) *Node {
 	newNode := &Node{
 		NodeID:      nodeID,
+		params:      params,
 		NodeAddress: nodeAddr,
+		Network:     &network.NodeNetwork{},
 		apiAddr:     apiAddr,
-		blockchain:  blockchain.NewBlockchain(nodeID),
-		preparedTxs: make(map[string]*PreparedTransaction),
+		blockchain:  blockchain.NewBlockchain(params.DataDir, nodeID, &params.Params),
+		preparedTxs: newPreparedTxs(),
 	}
 
 	newNode.Init()
+	bans := network.NewBanManager(BansListStorage{newNode.dataDir()})
+	newNode.Client.Peers = network.NewPeerManager(params.Magic, newNode.Network, bans)
 	newNode.InitNetwork([]network.NodeAddr{}, false)
 
 	// REST Server constructor
@@ -61,15 +71,27 @@ func NewNode(nodeID string, nodeAddr network.NodeAddr, apiAddr, minerWalletAddre
 
 	// Node Server constructor
 	newNode.Server = NewNodeServer(newNode, minerWalletAddress)
+	if persistMempool {
+		newNode.Server.mempool.SetStorage(MempoolStorage{newNode.dataDir()})
+	}
 
 	return newNode
 }
 
+// dataDir returns the directory of the node files in the network
+func (node *Node) dataDir() string {
+	return fmt.Sprintf("%sdb%s/", node.params.DataDir, node.NodeID)
+}
+
+// wallets opens the wallets of the node in the network
+func (node *Node) wallets() (*wallet.Wallets, error) {
+	return wallet.NewWallets(node.params.DataDir, node.NodeID, node.params.AddressVersion)
+}
+
 func (node *Node) Init() {
 
 	// Nodes list storage
-	dataDir := fmt.Sprintf("files/db%s/", node.NodeID)
-	node.Network.SetExtraManager(NodesListStorage{dataDir})
+	node.Network.SetExtraManager(NodesListStorage{node.dataDir()})
 	// load list of nodes from config
 	node.Network.SetNodes([]network.NodeAddr{}, true)
 
@@ -81,7 +103,7 @@ func (node *Node) InitClient() error {
 		return nil
 	}
 	client := network.NodeClient{}
-	client.Network = &node.Network
+	client.Network = node.Network
 	node.Client = &client
 	return nil
 }
@@ -98,7 +120,7 @@ func (node *Node) InitNetwork(list []network.NodeAddr, force bool) error {
 		if node.Network.GetCountOfKnownNodes() == 0 {
 			// there are no any known nodes.
 			// load them from some external resource
-			node.Network.LoadInitialNodes(node.NodeAddress)
+			node.Network.LoadInitialNodes(node.params.InitialNodesFile(), node.NodeAddress)
 		}
 	} else {
 		node.Network.SetNodes(list, true)
@@ -114,13 +136,20 @@ func (node *Node) SendVersionToNodes(nodes []network.NodeAddr) {
 	bestHeight := node.blockchain.GetBestHeight()
 
 	if len(nodes) == 0 {
-		nodes = node.Network.Nodes
+		nodes = node.Network.GetNodes()
 	}
 
 	for _, n := range nodes {
 		if n.CompareToAddress(node.Client.NodeAddress) {
 			continue
 		}
+		// the version is sent during the handshake of a new connection
+		if node.Client.Peers.Find(n) == nil {
+			log.Info.Printf("Connect to [%s]", n)
+			node.Client.Connect(n)
+			continue
+		}
+
 		log.Info.Printf("Send Version [%d] Height to [%s]", bestHeight, n)
 		node.Client.SendVersion(n, bestHeight)
 	}
@@ -130,15 +159,16 @@ func (node *Node) CheckAddressKnown(addr network.NodeAddr) {
 	//log.Info.Printf("Check address known [%s]\n", addr)
 	//log.Info.Printf("All known nodes: %+v\n", node.Network.Nodes)
 	if !node.Network.CheckIsKnown(addr) {
-		if len(node.Network.Nodes) > 0 {
-			log.Info.Printf("Send Addr %s to %s", node.Network.Nodes, addr)
-			node.Client.SendAddr(addr, node.Network.Nodes)
+		nodes := node.Network.GetNodes()
+		if len(nodes) > 0 {
+			log.Info.Printf("Send Addr %s to %s", nodes, addr)
+			node.Client.SendAddr(addr, nodes)
 		} else {
 			log.Info.Printf("Don't Send Addr because Network Nodes is empty")
 		}
 
 		node.Network.AddNodeToKnown(addr)
-		log.Info.Printf("Updated known nodes: %+v\n", node.Network.Nodes)
+		log.Info.Printf("Updated known nodes: %+v\n", node.Network.GetNodes())
 	}
 }
 
@@ -146,20 +176,22 @@ func (node *Node) CheckAddressKnown(addr network.NodeAddr) {
 func (node *Node) Run() {
 	log.Debug.Printf("nodeID: %s, nodeAddress: %s, apiAddr: %s", node.NodeID, node.NodeAddress, node.apiAddr)
 
-	// TODO: go routine on exits
-	//	exitChannel := make(chan os.Signal, 1)
-	//	signal.Notify(exitChannel, os.Interrupt, os.Kill, syscall.SIGTERM)
-	//	go func() {
-	//		signalType := <-exitChannel
-	//		signal.Stop(exitChannel)
+	// the servers are stopped on exit, so the node data is saved
+	exitChannel := make(chan os.Signal, 1)
+	signal.Notify(exitChannel, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		signalType := <-exitChannel
+		signal.Stop(exitChannel)
 
-	//		// before terminating
-	//		log.Info.Println("Received signal type : ", signalType)
+		// before terminating
+		log.Info.Println("Received signal type : ", signalType)
 
-	//		// FIXME
-	//		node.Rest.Close()
-	//		node.Server.Stop()
-	//	}()
+		// FIXME
+		log.Info.Println("Stop servers")
+		node.rest.Close()
+		node.Server.Stop()
+		os.Exit(0)
+	}()
 
 	// REST Server start
 	if err := node.rest.Start(); err != nil {
@@ -167,19 +199,6 @@ func (node *Node) Run() {
 	}
 
 	node.RunNodeServer()
-
-	// TODO: refactoring exits from all routines
-	signalCh := make(chan os.Signal)
-	signal.Notify(signalCh, syscall.SIGTERM)
-	for {
-		s := <-signalCh
-		if s == syscall.SIGTERM {
-			// FIXME
-			log.Info.Println("Stop servers")
-			node.rest.Close()
-			node.Server.Stop()
-		}
-	}
 }
 
 // TODO: move to NodeStarter (NodeDaemon) struct?
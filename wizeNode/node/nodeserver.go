@@ -1,18 +1,22 @@
 package node
 
 import (
+	"bytes"
 	"fmt"
-	"io/ioutil"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"wizeBlock/wizeNode/core/blockchain"
 	"wizeBlock/wizeNode/core/log"
+	"wizeBlock/wizeNode/core/mempool"
+	"wizeBlock/wizeNode/core/miner"
 	"wizeBlock/wizeNode/core/network"
 )
 
 // TODO: rethink with NewNodeServer and Start/Stop
-// TODO: rethink with handleConnection and readRequest
+// TODO: rethink with handleMessage and NodeServerRequest
 // TODO: rethink with CloneNode and multiple goroutines
 
 const timeFormat = "15:04:05.000000"
@@ -24,27 +28,260 @@ type NodeServer struct {
 	minerAddress string
 
 	// TODO: to redesign
+	// inTransitMu guards the announced blocks from the handlers of several peers
+	inTransitMu     sync.Mutex
 	blocksInTransit [][]byte
+	moreBlocks      bool
 	bc              *blockchain.Blockchain
-	mempool         map[string]blockchain.Transaction
+	mempool         *mempool.Mempool
+	feeEstimator    *mempool.FeeEstimator
+	miner           *miner.Miner
+	work            *miner.Work
+	seenTxs         *seenCache
+	sync            *SyncManager
+
+	// reorganized is set by the disconnected blocks until the new tip is connected
+	reorganized int32
+
+	// background routines are waited for on Stop
+	routines sync.WaitGroup
+	stopOnce sync.Once
 
 	// TODO: to redesign
-	conn                net.Conn
 	StopMainChan        chan struct{}
 	StopMainConfirmChan chan struct{}
 }
 
 func NewNodeServer(node *Node, minerAddress string) *NodeServer {
-	return &NodeServer{
+	server := &NodeServer{
 		Node:                node,
 		NodeAddress:         node.NodeAddress,
 		minerAddress:        minerAddress,
 		blocksInTransit:     [][]byte{},
-		mempool:             make(map[string]blockchain.Transaction),
+		mempool:             mempool.New(node.blockchain),
+		feeEstimator:        mempool.NewFeeEstimator(node.blockchain.GetBestHeight()),
+		seenTxs:             newSeenCache(seenTxsCacheSize),
 		bc:                  node.blockchain,
 		StopMainChan:        make(chan struct{}),
 		StopMainConfirmChan: make(chan struct{}),
 	}
+
+	server.mempool.SetFeeEstimator(server.feeEstimator)
+	server.work = miner.NewWork(server.bc, server.mempool)
+	if len(minerAddress) > 0 {
+		server.miner = miner.New(server.bc, server.mempool, minerAddress, server.blockMined)
+	}
+	server.bc.SetListener(server)
+	server.sync = NewSyncManager(server)
+	node.Client.Peers.SetHandler(server.handleMessage)
+	node.Client.Peers.SetLocalVersion(server.localVersion)
+
+	return server
+}
+
+// localVersion returns the version message sent to peers during the handshake
+func (s *NodeServer) localVersion() *network.ComVersion {
+	return &network.ComVersion{
+		Version:    network.NodeVersion,
+		Services:   network.ServiceNode,
+		BestHeight: s.bc.GetBestHeight(),
+		AddrFrom:   s.NodeAddress,
+	}
+}
+
+// announceBlocks keeps the announced blocks which are not known yet and returns the first one
+// to request, the rest are requested one by one as the blocks arrive
+// more tells that the peer may have more blocks after them
+func (s *NodeServer) announceBlocks(hashes [][]byte, more bool) []byte {
+	s.inTransitMu.Lock()
+	defer s.inTransitMu.Unlock()
+
+	s.moreBlocks = more
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	s.blocksInTransit = hashes[1:]
+	return hashes[0]
+}
+
+// nextBlockInTransit returns the next announced block to request,
+// when there are none, true is returned once if the peer may have more blocks
+func (s *NodeServer) nextBlockInTransit() ([]byte, bool) {
+	s.inTransitMu.Lock()
+	defer s.inTransitMu.Unlock()
+
+	if len(s.blocksInTransit) > 0 {
+		blockHash := s.blocksInTransit[0]
+		s.blocksInTransit = s.blocksInTransit[1:]
+		return blockHash, false
+	}
+
+	more := s.moreBlocks
+	s.moreBlocks = false
+	return nil, more
+}
+
+// resetBlocksInTransit forgets the announced blocks
+func (s *NodeServer) resetBlocksInTransit() {
+	s.inTransitMu.Lock()
+	s.blocksInTransit = [][]byte{}
+	s.moreBlocks = false
+	s.inTransitMu.Unlock()
+}
+
+// RelayTransaction adds a transaction to the mempool and announces it to all peers
+// except the one it's received from, nil is passed for the local transactions
+// The transaction is remembered as seen once it's accepted or found invalid,
+// other rejections like missing inputs may pass later, so it can be received again
+func (s *NodeServer) RelayTransaction(tx *blockchain.Transaction, from *network.Peer) error {
+	err := s.mempool.Add(tx)
+	if err != nil {
+		if txErr, ok := err.(*mempool.TxError); ok && txErr.Code == mempool.ErrTxInvalid {
+			s.seenTxs.Add(tx.ID)
+		}
+		return err
+	}
+
+	s.seenTxs.Add(tx.ID)
+	s.Node.Client.BroadcastInv("tx", [][]byte{tx.ID}, from)
+	if s.miner != nil {
+		s.miner.Update()
+	}
+
+	return nil
+}
+
+// loadMempool returns the transactions saved before the restart to the mempool
+func (s *NodeServer) loadMempool() {
+	loaded, err := s.mempool.Load()
+	if err != nil {
+		log.Warn.Printf("Failed with loading the mempool: %s", err)
+		return
+	}
+	if loaded == 0 {
+		return
+	}
+
+	// the peers may announce the loaded transactions, they are not requested again
+	for _, tx := range s.mempool.Transactions() {
+		s.seenTxs.Add(tx.ID)
+	}
+
+	log.Info.Printf("Loaded %d transactions to the mempool", loaded)
+}
+
+// UTXOView returns the UTXO set with the mempool transactions applied
+func (s *NodeServer) UTXOView() *blockchain.UTXOView {
+	return blockchain.NewUTXOView(s.bc, s.mempool)
+}
+
+// preparingView returns the UTXO view for new transactions to sign, the outputs
+// reserved by the transactions prepared before are not spent again
+func (s *NodeServer) preparingView() *blockchain.UTXOView {
+	return blockchain.NewUTXOView(s.bc, reservingPending{s.mempool, s.Node.preparedTxs})
+}
+
+// blockMined announces a block mined by the node
+func (s *NodeServer) blockMined(block *blockchain.Block) {
+	nanonow := time.Now().Format(timeFormat)
+	log.Debug.Printf("nodeID: %s, %s: New block is mined!", s.Node.NodeID, nanonow)
+
+	// the mined transactions are removed from the pool by BlockConnected
+	s.relayBlock(block, nil)
+}
+
+// relayBlock announces a block accepted from the peer to the other peers,
+// blocks of a side chain are not announced until it becomes the main chain
+func (s *NodeServer) relayBlock(block *blockchain.Block, from *network.Peer) {
+	if !bytes.Equal(s.bc.GetTip(), block.Hash) {
+		return
+	}
+
+	s.Node.Client.BroadcastInv("block", [][]byte{block.Hash}, from)
+}
+
+// SubmitBlock adds a block solved by an external miner and announces it
+// A *blockchain.BlockError is returned when the block breaks consensus rules
+func (s *NodeServer) SubmitBlock(block *blockchain.Block) error {
+	if s.bc.HasBlock(block.Hash) {
+		return fmt.Errorf("Block %x is already known", block.Hash)
+	}
+
+	err := s.bc.AddBlock(block)
+	if err != nil {
+		return err
+	}
+
+	log.Info.Printf("New block %x of height %d is submitted", block.Hash, block.Height)
+	s.blockMined(block)
+
+	return nil
+}
+
+// GenerateBlocks mines the number of blocks right away with the pool transactions
+// and returns their hashes, it's allowed only in the networks generating blocks by request
+func (s *NodeServer) GenerateBlocks(address string, count int) ([][]byte, error) {
+	if !s.Node.params.GenerateOnDemand {
+		return nil, fmt.Errorf("Blocks are not generated by request in %s", s.Node.params.Name)
+	}
+
+	hashes := [][]byte{}
+	for i := 0; i < count; i++ {
+		block, err := s.work.Generate(address)
+		if err != nil {
+			return hashes, err
+		}
+
+		err = s.SubmitBlock(block)
+		if err != nil {
+			return hashes, err
+		}
+		hashes = append(hashes, block.Hash)
+	}
+
+	return hashes, nil
+}
+
+// BlockConnected removes transactions of a new main chain block and the conflicting ones from the mempool
+// When the tip of a reorganization is connected, the pool transactions left without inputs are removed
+// The miner starts a block on top of the new tip
+func (s *NodeServer) BlockConnected(block *blockchain.Block) {
+	s.mempool.RemoveForBlock(block)
+	if bytes.Equal(block.Hash, s.bc.GetTip()) && atomic.CompareAndSwapInt32(&s.reorganized, 1, 0) {
+		removed := s.mempool.RemoveInvalid()
+		log.Debug.Printf("Removed %d transactions without inputs after the reorganization", removed)
+	}
+	s.work.TipChanged()
+	if s.miner != nil {
+		s.miner.Update()
+	}
+}
+
+// BlockDisconnected returns transactions of a block removed from the main chain to the mempool
+// The blocks come oldest first and the transactions of a block go after their parents,
+// so a returned transaction finds its parent in the mempool or in the new branch.
+// Transactions which lost their parents or conflict with the new branch are dropped,
+// the pool transactions depending on them are removed when the new tip is connected.
+func (s *NodeServer) BlockDisconnected(block *blockchain.Block) {
+	atomic.StoreInt32(&s.reorganized, 1)
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+
+		// confirmed on the new branch as well
+		if _, err := s.bc.FindTransaction(tx.ID); err == nil {
+			continue
+		}
+
+		log.Debug.Printf("Return Tx [%x] to mempool", tx.ID)
+		err := s.mempool.Add(tx)
+		if err != nil {
+			log.Debug.Printf("Tx [%x] is not returned: %s", tx.ID, err)
+		}
+	}
 }
 
 // TESTS: should we support this?
@@ -76,8 +313,17 @@ func (s *NodeServer) Start(serverStartResult chan string) error {
 
 	s.Node.Client.SetNodeAddress(s.NodeAddress)
 
+	s.loadMempool()
+
+	s.run(func() { s.sync.Start(s.StopMainChan) })
+	s.run(func() { s.mempool.Start(s.StopMainChan) })
+	if s.miner != nil {
+		s.run(func() { s.miner.Start(s.StopMainChan) })
+	}
+	s.run(func() { s.Node.Client.Peers.Start(s.StopMainChan) })
+
 	log.Info.Printf("Node Server [%s] was started, knownNodes: %v",
-		s.Node.NodeAddress, s.Node.Network.Nodes)
+		s.Node.NodeAddress, s.Node.Network.GetNodes())
 	//s.bc = s.node.blockchain
 
 	s.Node.SendVersionToNodes([]network.NodeAddr{})
@@ -116,40 +362,46 @@ func (s *NodeServer) Start(serverStartResult chan string) error {
 			break
 		}
 
-		go s.handleConnection(conn)
+		s.Node.Client.Peers.Accept(conn)
 	}
 
 	return nil
 }
 
-func (s *NodeServer) Stop() {
-	if s.bc != nil && s.bc.Db != nil {
-		s.bc.Db.Close()
-	}
+// run starts a background routine which is stopped by StopMainChan
+func (s *NodeServer) run(routine func()) {
+	s.routines.Add(1)
+	go func() {
+		defer s.routines.Done()
+		routine()
+	}()
 }
 
-func (s *NodeServer) readRequest(conn net.Conn) (command string, databuffer []byte, err error) {
-	request, err := ioutil.ReadAll(conn)
+// Stop stops the miner and the background routines, then disconnects the peers and waits
+// for their handlers, so nothing changes the mempool and the blockchain when the mempool
+// is saved and the database is closed
+func (s *NodeServer) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.StopMainChan)
+	})
+	s.routines.Wait()
+
+	s.Node.Client.Peers.Close()
+
+	err := s.mempool.Save()
 	if err != nil {
-		log.Warn.Printf("NodeServer read request error: %s", err)
-		return
+		log.Warn.Printf("Failed with saving the mempool: %s", err)
 	}
 
-	command = network.BytesToCommand(request[:network.CommandLength])
-	databuffer = request[network.CommandLength:]
-	return
+	if s.bc != nil && s.bc.Db != nil {
+		s.bc.Db.Close()
+	}
 }
 
-func (s *NodeServer) handleConnection(conn net.Conn) {
+// handleMessage processes a message received from a peer
+func (s *NodeServer) handleMessage(peer *network.Peer, msg *network.Message) {
 	starttime := time.Now().UnixNano()
-	log.Debug.Println("New command. Start reading")
-
-	command, request, err := s.readRequest(conn)
-	if err != nil {
-		s.sendErrorBack(conn, fmt.Errorf("Network Data Reading Error: "+err.Error()))
-		conn.Close()
-		return
-	}
+	command := msg.Command
 
 	log.Debug.Printf("Received %s command", command)
 
@@ -160,12 +412,12 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 	requestObj := NodeServerRequest{}
 	// HACK: should we clone node?
 	requestObj.Node = s.CloneNode()
-	requestObj.Request = request[:]
+	requestObj.Request = msg.Payload
 	requestObj.Server = s
-	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+	requestObj.Peer = peer
+	if addr, ok := peer.RemoteAddr().(*net.TCPAddr); ok {
 		requestObj.RequestIP = addr.IP.String()
 	}
-	request = nil
 
 	log.Debug.Printf("RequestObj: %+v\n", requestObj)
 
@@ -179,55 +431,54 @@ func (s *NodeServer) handleConnection(conn net.Conn) {
 		rerr = requestObj.handleInv()
 	case "getblocks":
 		rerr = requestObj.handleGetBlocks()
+	case "getheaders":
+		rerr = requestObj.handleGetHeaders()
+	case "headers":
+		rerr = requestObj.handleHeaders()
 	case "getdata":
 		rerr = requestObj.handleGetData()
 	case "tx":
 		rerr = requestObj.handleTx()
 	case "version":
 		rerr = requestObj.handleVersion()
+	case "error":
+		rerr = fmt.Errorf("Peer error: %s", network.DecodeError(msg.Payload))
 	default:
-		rerr = fmt.Errorf("Unknown command!")
+		rerr = misbehaving(banScoreUnknownCommand, fmt.Errorf("Unknown command %s!", command))
+	}
+
+	if perr, ok := rerr.(*protocolError); ok {
+		s.Node.Client.Peers.Misbehaving(peer, perr.score, perr.Error())
 	}
 
 	if rerr != nil {
 		log.Info.Println("Network Command Handle Error: ", rerr.Error())
 		if requestObj.HasResponse {
 			// return error to the client
-			// first byte is bool false to indicate there was error
-			s.sendErrorBack(conn, rerr)
+			s.sendErrorBack(peer, msg, rerr)
+		}
+	} else if requestObj.HasResponse && requestObj.Response != nil {
+		// send the response back over the same connection
+		log.Debug.Printf("Responding %d bytes\n", len(requestObj.Response))
+		err := peer.Reply(msg, requestObj.ResponseCommand, requestObj.Response)
+		if err != nil {
+			log.Warn.Println("Sending response error: ", err.Error())
 		}
 	}
 
-	// TODO: add sending response
-	//	if requestObj.HasResponse && requestObj.Response != nil && rerr == nil {
-	//		// send this response back
-	//		// first byte is bool true to indicate request was success
-	//		dataresponse := append([]byte{1}, requestObj.Response...)
-	//		log.Info.Printf("Responding %d bytes\n", len(dataresponse))
-	//		_, err := conn.Write(dataresponse)
-	//		if err != nil {
-	//			log.Warn.Println("Sending response error: ", err.Error())
-	//		}
-	//	}
-
 	duration := time.Since(time.Unix(0, starttime))
 	ms := duration.Nanoseconds() / int64(time.Millisecond)
 	log.Debug.Printf("Complete processing %s command. Time: %d ms\n", command, ms)
-
-	conn.Close()
 }
 
-func (s *NodeServer) sendErrorBack(conn net.Conn, err error) {
+func (s *NodeServer) sendErrorBack(peer *network.Peer, request *network.Message, err error) {
 	log.Info.Println("Sending back error message: ", err.Error())
 
-	payload, err := network.GobEncode(err.Error())
-	if err == nil {
-		dataresponse := append([]byte{0}, payload...)
-		log.Info.Printf("Responding %d bytes as error message\n", len(dataresponse))
-		_, err = conn.Write(dataresponse)
-		if err != nil {
-			log.Warn.Println("Sending response error: ", err.Error())
-		}
+	payload := network.EncodeError(err.Error())
+	log.Info.Printf("Responding %d bytes as error message\n", len(payload))
+	err = peer.Reply(request, "error", payload)
+	if err != nil {
+		log.Warn.Println("Sending response error: ", err.Error())
 	}
 }
 
@@ -242,14 +493,16 @@ func (s *NodeServer) CloneNode() *Node {
 	//node := NewNode(originnode.NodeID, originnode.NodeAddress, originnode.apiAddr, s.minerAddress)
 	node := Node{
 		NodeID:      originnode.NodeID,
+		params:      originnode.params,
 		NodeAddress: originnode.NodeAddress,
+		Network:     originnode.Network,
 		blockchain:  originnode.blockchain,
 	}
 
-	node.Init()
+	// the known nodes are shared, so the nodes learned by the handlers are connected by the peer manager
+	node.InitClient()
 	node.Client.SetNodeAddress(s.NodeAddress)
-	// set list of nodes and skip loading default if this is empty list
-	node.InitNetwork(originnode.Network.Nodes, true)
+	node.Client.Peers = originnode.Client.Peers
 
 	return &node
 }
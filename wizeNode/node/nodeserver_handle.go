@@ -1,14 +1,13 @@
 package node
 
 import (
-	"bytes"
-	"encoding/gob"
-	"encoding/hex"
+	"encoding"
 	"fmt"
 	"time"
 
 	"wizeBlock/wizeNode/core/blockchain"
 	"wizeBlock/wizeNode/core/log"
+	"wizeBlock/wizeNode/core/mempool"
 	"wizeBlock/wizeNode/core/network"
 )
 
@@ -16,30 +15,75 @@ import (
 // TODO: rethink with handleAddr
 // TODO: rethink with Data messages
 
+// Misbehavior scores added to the host of a peer which violates the protocol,
+// the host is banned when its score reaches network.BanThreshold
+const (
+	banScoreParseError     = 20
+	banScoreUnknownCommand = 10
+	banScoreOversized      = 20
+	banScoreInvalidBlock   = 100
+	banScoreInvalidHeader  = 100
+	banScoreInvalidTx      = 10
+)
+
+// protocolError is returned by handlers when the peer has violated the protocol
+type protocolError struct {
+	score int
+	err   error
+}
+
+func (e *protocolError) Error() string {
+	return e.err.Error()
+}
+
+func misbehaving(score int, err error) error {
+	return &protocolError{score, err}
+}
+
+// isInvalidBlock checks whether the block error means the peer has sent a wrong block
+// An orphan block is not a violation, the peer can just know more blocks, and a block
+// ahead of the local clock can be valid later. Local failures are not block errors
+func isInvalidBlock(err error) bool {
+	berr, ok := err.(*blockchain.BlockError)
+
+	return ok && berr.Code != blockchain.ErrBlockOrphan && berr.Code != blockchain.ErrBlockTimeTooNew
+}
+
 type NodeServerRequest struct {
-	Node        *Node
-	Server      *NodeServer
-	Request     []byte
-	RequestIP   string
-	HasResponse bool
-	Response    []byte
+	Node            *Node
+	Server          *NodeServer
+	Peer            *network.Peer
+	Request         []byte
+	RequestIP       string
+	HasResponse     bool
+	ResponseCommand string
+	Response        []byte
 }
 
 func (self *NodeServerRequest) Init() {
 	self.HasResponse = false
+	self.ResponseCommand = ""
 	self.Response = nil
 }
 
-// Reads and parses request from network data
-func (self *NodeServerRequest) parseRequestData(payload interface{}) error {
-	var buff bytes.Buffer
+// Sets the response which is sent back to the peer as a reply to the request
+func (self *NodeServerRequest) setResponse(command string, data encoding.BinaryMarshaler) error {
+	response, err := data.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	self.ResponseCommand = command
+	self.Response = response
 
-	buff.Write(self.Request)
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(payload)
+	return nil
+}
 
+// Reads and parses request from network data
+func (self *NodeServerRequest) parseRequestData(payload encoding.BinaryUnmarshaler) error {
+	err := payload.UnmarshalBinary(self.Request)
 	if err != nil {
-		return fmt.Errorf("Parse request: " + err.Error())
+		return misbehaving(banScoreParseError, fmt.Errorf("Parse request: %s", err))
 	}
 
 	return nil
@@ -52,6 +96,10 @@ func (self *NodeServerRequest) handleAddr() error {
 		return err
 	}
 
+	if len(payload.AddrList) > network.MaxAddrPerMessage {
+		return misbehaving(banScoreOversized, fmt.Errorf("Too many addresses: %d", len(payload.AddrList)))
+	}
+
 	// TODO: check this logic
 	//KnownNodes = append(KnownNodes, payload.AddrList...)
 	//nanonow := time.Now().Format(timeFormat)
@@ -72,7 +120,7 @@ func (self *NodeServerRequest) handleAddr() error {
 
 	nanonow := time.Now().Format(timeFormat)
 	log.Info.Printf("NodeID: %s, %s: There are %d known nodes now: %+v!\n",
-		self.Node.NodeID, nanonow, len(self.Node.Network.Nodes), self.Node.Network.Nodes)
+		self.Node.NodeID, nanonow, self.Node.Network.GetCountOfKnownNodes(), self.Node.Network.GetNodes())
 
 	if len(addednodes) > 0 {
 		log.Info.Printf("Send Versions to new nodes %+v\n", addednodes)
@@ -95,24 +143,50 @@ func (self *NodeServerRequest) handleBlock() error {
 	}
 
 	blockData := payload.Block
-	block := blockchain.DeserializeBlock(blockData)
+	block, err := blockchain.DecodeBlock(blockData)
+	if err != nil {
+		return misbehaving(banScoreParseError, fmt.Errorf("Wrong block data from %s: %s", payload.AddrFrom, err))
+	}
+
+	// blocks requested during the sync are connected in the header chain order
+	if self.Server.sync.BlockReceived(block, payload.AddrFrom, self.Peer) {
+		return nil
+	}
 
 	nanonow := time.Now().Format(timeFormat)
 	log.Debug.Printf("nodeID: %s, %s: Received a new block!\n", self.Node.NodeID, nanonow)
-	self.Server.bc.AddBlock(block)
+
+	err = self.Server.bc.AddBlock(block)
+	if berr, ok := err.(*blockchain.BlockError); ok && berr.Code == blockchain.ErrBlockOrphan {
+		// ask for the blocks between our tip and the announced one
+		locator := self.Server.bc.BlockLocator(self.Server.bc.GetTip())
+		self.Node.Client.SendGetBlocks(payload.AddrFrom, locator, block.Hash)
+		return nil
+	}
+	if err != nil {
+		log.Warn.Printf("Reject block %x from %s: %s", block.Hash, payload.AddrFrom, err)
+		// the rest of the blocks in transit can't be connected without this one
+		self.Server.resetBlocksInTransit()
+		if isInvalidBlock(err) {
+			return misbehaving(banScoreInvalidBlock, err)
+		}
+		return err
+	}
 
 	log.Debug.Printf("nodeID: %s, %s: Added block %x\n", self.Node.NodeID, nanonow, block.Hash)
+	// a block waiting for the parent body is not stored yet, so it can't be served
+	if self.Server.bc.HasBlock(block.Hash) {
+		self.Server.relayBlock(block, self.Peer)
+	}
 
-	// OLDTODO: add validation of block
-	if len(self.Server.blocksInTransit) > 0 {
-		blockHash := self.Server.blocksInTransit[0]
+	// the UTXO set is updated by the blockchain when the block is connected
+	blockHash, more := self.Server.nextBlockInTransit()
+	if blockHash != nil {
 		self.Node.Client.SendGetData(payload.AddrFrom, "block", blockHash)
-
-		self.Server.blocksInTransit = self.Server.blocksInTransit[1:]
-	} else {
-		UTXOSet := blockchain.UTXOSet{self.Server.bc}
-		// OLDTODO: use UTXOSet.Update() instead UTXOSet.Reindex
-		UTXOSet.Reindex()
+	} else if more {
+		// the last inventory was full, so the peer may have more blocks
+		locator := self.Server.bc.BlockLocator(self.Server.bc.GetTip())
+		self.Node.Client.SendGetBlocks(payload.AddrFrom, locator, nil)
 	}
 
 	return nil
@@ -125,29 +199,33 @@ func (self *NodeServerRequest) handleInv() error {
 		return err
 	}
 
+	if len(payload.Items) > network.MaxInvPerMessage {
+		return misbehaving(banScoreOversized, fmt.Errorf("Too many inventory items: %d", len(payload.Items)))
+	}
+
 	nanonow := time.Now().Format(timeFormat)
 	log.Debug.Printf("nodeID: %s, %s: Received inventory with %d %s\n", self.Node.NodeID, nanonow, len(payload.Items), payload.Type)
-	log.Debug.Printf("len(mempool): %d\n", len(self.Server.mempool))
+	log.Debug.Printf("len(mempool): %d\n", self.Server.mempool.Count())
 
 	if payload.Type == "block" {
-		self.Server.blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		self.Node.Client.SendGetData(payload.AddrFrom, "block", blockHash)
-
+		// items go in the chain order, so each block is requested after its parent
 		newInTransit := [][]byte{}
-		for _, b := range self.Server.blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+		for _, item := range payload.Items {
+			if !self.Server.bc.HasBlock(item) {
+				newInTransit = append(newInTransit, item)
 			}
 		}
-		self.Server.blocksInTransit = newInTransit
+
+		blockHash := self.Server.announceBlocks(newInTransit, len(payload.Items) == network.MaxBlocksPerMessage)
+		if blockHash == nil {
+			return nil
+		}
+
+		self.Node.Client.SendGetData(payload.AddrFrom, "block", blockHash)
 	}
 
 	if payload.Type == "tx" {
-		txID := payload.Items[0]
-
-		if self.Server.mempool[hex.EncodeToString(txID)].ID == nil {
+		for _, txID := range self.Server.seenTxs.Unseen(payload.Items) {
 			self.Node.Client.SendGetData(payload.AddrFrom, "tx", txID)
 		}
 	}
@@ -162,13 +240,73 @@ func (self *NodeServerRequest) handleGetBlocks() error {
 		return err
 	}
 
-	blocks := self.Server.bc.GetBlockHashes()
+	blocks := self.Server.bc.LocateBlocks(payload.Locator, payload.StopHash, network.MaxBlocksPerMessage)
+	if len(blocks) == 0 {
+		return nil
+	}
+
 	self.Node.Client.SendInv(payload.AddrFrom, "block", blocks)
 
 	return nil
 }
 
+func (self *NodeServerRequest) handleGetHeaders() error {
+	var payload network.ComGetHeaders
+	err := self.parseRequestData(&payload)
+	if err != nil {
+		return err
+	}
+
+	headers := self.Server.bc.LocateHeaders(payload.Locator, payload.StopHash, network.MaxHeadersPerMessage)
+	self.Node.Client.SendHeaders(payload.AddrFrom, headers)
+
+	return nil
+}
+
+func (self *NodeServerRequest) handleHeaders() error {
+	var payload network.ComHeaders
+	err := self.parseRequestData(&payload)
+	if err != nil {
+		return err
+	}
+
+	log.Debug.Printf("Received %d headers from %s", len(payload.Headers), payload.AddrFrom)
+
+	if len(payload.Headers) > network.MaxHeadersPerMessage {
+		return misbehaving(banScoreOversized, fmt.Errorf("Too many headers: %d", len(payload.Headers)))
+	}
+
+	for _, data := range payload.Headers {
+		header, err := blockchain.DeserializeBlockHeader(data)
+		if err != nil {
+			return misbehaving(banScoreParseError, err)
+		}
+
+		err = self.Server.bc.AddHeader(header)
+		if err != nil {
+			log.Warn.Printf("Reject header %x from %s: %s", header.Hash(), payload.AddrFrom, err)
+			if isInvalidBlock(err) {
+				return misbehaving(banScoreInvalidHeader, err)
+			}
+			return err
+		}
+	}
+
+	// a full message means the peer may have more headers
+	if len(payload.Headers) == network.MaxHeadersPerMessage {
+		locator := self.Server.bc.BlockLocator(self.Server.bc.BestHeader())
+		self.Node.Client.SendGetHeaders(payload.AddrFrom, locator, nil)
+	}
+
+	self.Server.sync.HeadersReceived(payload.AddrFrom)
+
+	return nil
+}
+
 func (self *NodeServerRequest) handleGetData() error {
+	// the data is sent back over the same connection, as well as errors
+	self.HasResponse = true
+
 	var payload network.ComGetData
 	err := self.parseRequestData(&payload)
 	if err != nil {
@@ -181,18 +319,21 @@ func (self *NodeServerRequest) handleGetData() error {
 			return err
 		}
 
-		self.Node.Client.SendBlock(payload.AddrFrom, &block)
+		data := network.ComBlock{AddrFrom: self.Node.Client.NodeAddress, Block: block.Serialize()}
+		return self.setResponse("block", &data)
 	}
 
 	if payload.Type == "tx" {
-		txID := hex.EncodeToString(payload.ID)
-		tx := self.Server.mempool[txID]
+		tx, ok := self.Server.mempool.Get(payload.ID)
+		if !ok {
+			return fmt.Errorf("Transaction %x is not found", payload.ID)
+		}
 
-		self.Node.Client.SendTx(payload.AddrFrom, &tx)
-		// delete(mempool, txID)
+		data := network.ComTx{AddFrom: self.Node.Client.NodeAddress, Transaction: tx.Serialize()}
+		return self.setResponse("tx", &data)
 	}
 
-	return nil
+	return fmt.Errorf("Unknown data type %s", payload.Type)
 }
 
 func (self *NodeServerRequest) handleTx() error {
@@ -203,111 +344,36 @@ func (self *NodeServerRequest) handleTx() error {
 	}
 
 	txData := payload.Transaction
-	tx := blockchain.DeserializeTransaction(txData)
-	log.Debug.Printf("handleTx: [%x] minerAddress: %s\n", tx.ID, self.Server.minerAddress)
-
-	// TODO: mempool should be just for miners?
-	//if len(s.miningAddress) > 0 {
-	log.Debug.Printf("Added to pool %d Tx: [%x]\n", len(self.Server.mempool), tx.ID)
-	self.Server.mempool[hex.EncodeToString(tx.ID)] = tx
-	//}
-
-	//if s.nodeAddress == KnownNodes[0] {
-	if self.Node.Client.NodeAddress.CompareToAddress(self.Node.Network.Nodes[0]) {
-		log.Debug.Printf("nodeID: %s, knownNodes: %v\n", self.Node.NodeID, self.Node.Network.Nodes)
-		for _, node := range self.Node.Network.Nodes {
-			if !node.CompareToAddress(self.Node.Client.NodeAddress) &&
-				!node.CompareToAddress(payload.AddFrom) {
-				self.Node.Client.SendInv(node, "tx", [][]byte{tx.ID})
-			}
-		}
-	} else {
-		// OLDTODO: changing count of transaction for mining
-		log.Debug.Printf("minerAddress: %s, len(mempool): %d\n", self.Server.minerAddress, len(self.Server.mempool))
-		// FIXME: len of mempool?
-		if len(self.Server.mempool) >= 1 && len(self.Server.minerAddress) > 0 {
-		MineTransactions:
-			log.Debug.Println("MineTransactions...")
-			var txs []*blockchain.Transaction
-
-			for id := range self.Server.mempool {
-				tx := self.Server.mempool[id]
-				check, err := self.Server.bc.VerifyTransaction(&tx)
-				if check && err == nil {
-					txs = append(txs, &tx)
-				}
-			}
-
-			if len(txs) == 0 {
-				log.Debug.Println("All transactions are invalid! Waiting for new ones...")
-				return nil
-			}
+	tx, err := blockchain.DecodeTransaction(txData)
+	if err != nil {
+		return misbehaving(banScoreParseError, fmt.Errorf("Wrong transaction data from %s: %s", payload.AddFrom, err))
+	}
+	log.Debug.Printf("handleTx: [%x]\n", tx.ID)
 
-			// TODO: fix transactions with minenow=false, count = 2? more?
-			// TODO: we should check all transactions from the first to the last in time
-			// TODO: check all transactions by timestamp
-			/*
-				var inputTxID, check = "", ""
-				for index, tx := range txs {
-					fmt.Printf("Index: %d, Timestamp: %d, TxID: %x\n", index, tx.Timestamp, tx.ID)
-					if len(tx.Vin) > 0 {
-						for vi, vin := range tx.Vin {
-							fmt.Printf("  Input  %d, TxID: %x, Out: %d\n", vi, vin.Txid, vin.Vout)
-						}
-					}
-					if len(tx.Vout) > 0 {
-						for vo, vout := range tx.Vout {
-							fmt.Printf("  Output %d, PubKeyHash: %x, Out: %d\n", vo, vout.PubKeyHash, vout.Value)
-						}
-					}
-
-					if len(tx.Vin) > 0 && inputTxID == "" {
-						inputTxID = hex.EncodeToString(tx.Vin[0].Txid)
-						fmt.Printf("    inputTxID: %s\n", inputTxID)
-					} else if len(tx.Vin) > 0 {
-						check = hex.EncodeToString(tx.Vin[0].Txid)
-						fmt.Printf("    check:     %s\n", check)
-						if check == inputTxID {
-							fmt.Printf("    Equals!\n")
-
-							// FIXME
-							fmt.Printf("    Fix: %x to %x\n", tx.Vin[0].Txid, txs[index-1].ID)
-							//tx.Vin[0].Txid = txs[index-1].ID
-
-							inputTxID = check
-						}
-					}
-				}
-			*/
-
-			cbTx := blockchain.NewCoinbaseTX(self.Server.minerAddress, "")
-			txs = append(txs, cbTx)
-
-			newBlock := self.Server.bc.MineBlock(txs)
-			UTXOSet := blockchain.UTXOSet{self.Server.bc}
-			// OLDTODO: use UTXOSet.Update() instead UTXOSet.Reindex
-			UTXOSet.Reindex()
-
-			nanonow := time.Now().Format(timeFormat)
-			log.Debug.Printf("nodeID: %s, %s: New block is mined!", self.Node.NodeID, nanonow)
-			log.Debug.Printf("New block with %d tx is mined!\n", len(txs))
-
-			for _, tx := range txs {
-				txID := hex.EncodeToString(tx.ID)
-				delete(self.Server.mempool, txID)
-			}
+	if !tx.VerifyID() {
+		return misbehaving(banScoreInvalidTx, fmt.Errorf("Transaction %x has wrong ID", tx.ID))
+	}
 
-			for _, node := range self.Node.Network.Nodes {
-				if !node.CompareToAddress(self.Node.Client.NodeAddress) {
-					self.Node.Client.SendInv(node, "block", [][]byte{newBlock.Hash})
-				}
-			}
+	// the transaction is announced by several peers, but it's processed once
+	if self.Server.seenTxs.Contains(tx.ID) {
+		return nil
+	}
 
-			if len(self.Server.mempool) > 0 {
-				goto MineTransactions
-			}
+	err = self.Server.RelayTransaction(tx, self.Peer)
+	if txErr, ok := err.(*mempool.TxError); ok {
+		switch txErr.Code {
+		case mempool.ErrTxInvalid:
+			return misbehaving(banScoreInvalidTx, err)
+		case mempool.ErrTxDuplicate:
+			// another peer has delivered it a moment earlier
+			return nil
 		}
 	}
+	if err != nil {
+		// the previous transactions can be unknown yet or the pool can be full,
+		// it's not a violation
+		return err
+	}
 
 	return nil
 }
@@ -326,11 +392,14 @@ func (self *NodeServerRequest) handleVersion() error {
 		self.Node, payload.BestHeight, payload.AddrFrom)
 
 	if myBestHeight < foreignerBestHeight {
-		//log.Info.Printf("Request blocks from %s\n", payload.AddrFrom)
+		//log.Info.Printf("Request headers from %s\n", payload.AddrFrom)
 
-		self.Node.Client.SendGetBlocks(payload.AddrFrom)
+		locator := self.Server.bc.BlockLocator(self.Server.bc.BestHeader())
+		self.Node.Client.SendGetHeaders(payload.AddrFrom, locator, nil)
 
-	} else if myBestHeight > foreignerBestHeight {
+	} else if myBestHeight > foreignerBestHeight && self.Peer.HandshakeDone() {
+		// our height is sent with the version during the handshake,
+		// later versions tell about new blocks
 		//log.Info.Printf("Send my version back to %s\n", payload.AddrFrom)
 
 		self.Node.Client.SendVersion(payload.AddrFrom, myBestHeight)
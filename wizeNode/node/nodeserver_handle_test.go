@@ -0,0 +1,30 @@
+package node
+
+import (
+	"errors"
+	"testing"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+func TestIsInvalidBlock(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&blockchain.BlockError{Code: blockchain.ErrBlockBadProofOfWork}, true},
+		{&blockchain.BlockError{Code: blockchain.ErrBlockDoubleSpend}, true},
+		{&blockchain.BlockError{Code: blockchain.ErrBlockBadTimestamp}, true},
+		// the peer can know more blocks or have another clock
+		{&blockchain.BlockError{Code: blockchain.ErrBlockOrphan}, false},
+		{&blockchain.BlockError{Code: blockchain.ErrBlockTimeTooNew}, false},
+		// the local storage failures
+		{errors.New("Transaction is not found"), false},
+	}
+
+	for _, test := range tests {
+		if got := isInvalidBlock(test.err); got != test.want {
+			t.Errorf("isInvalidBlock(%v) = %t, want %t", test.err, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync"
+	"testing"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/chaincfg"
+	"wizeBlock/wizeNode/core/network"
+	"wizeBlock/wizeNode/core/wallet"
+)
+
+// newTestServer creates a node server without the network on top of a blockchain
+// in a temporary directory, the genesis block pays the emission to the returned wallet
+func newTestServer(t *testing.T) (*NodeServer, *wallet.Wallet, func()) {
+	dir, err := ioutil.TempDir("", "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := wallet.NewWallet()
+	params := &chaincfg.ChainParams{Name: "test", Params: blockchain.DefaultParams}
+	params.PowLimit = new(big.Int).Lsh(big.NewInt(1), 255)
+	params.RetargetInterval = 0
+	params.CoinbaseMaturity = 0
+	params.Genesis = blockchain.Genesis{
+		Address:   string(w.GetAddress()),
+		Reward:    1000000,
+		Timestamp: 1523558612,
+		Bits:      blockchain.BigToCompact(params.PowLimit),
+	}
+	for !blockchain.NewProofOfWork(&params.Genesis.Block().BlockHeader).Validate() {
+		params.Genesis.Nonce++
+	}
+	params.Genesis.Hash = hex.EncodeToString(params.Genesis.Block().Hash)
+
+	bc := blockchain.CreateBlockchain(dir+"/", "test", &params.Params)
+	blockchain.UTXOSet{Blockchain: bc}.Reindex()
+
+	node := &Node{
+		params:      params,
+		Client:      &network.NodeClient{Peers: network.NewPeerManager(0, &network.NodeNetwork{}, nil)},
+		blockchain:  bc,
+		preparedTxs: newPreparedTxs(),
+	}
+	server := NewNodeServer(node, "")
+	server.mempool.MinRelayFeeRate = 0
+
+	return server, w, func() {
+		bc.Db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestBlock solves a block after the parent with the transactions
+func newTestBlock(bc *blockchain.Blockchain, parent *blockchain.Block, address string, transactions ...*blockchain.Transaction) *blockchain.Block {
+	coinbase := blockchain.NewCoinbaseTX(address, "", bc.Params().BlockSubsidy(parent.Height+1))
+	transactions = append([]*blockchain.Transaction{coinbase}, transactions...)
+
+	return blockchain.NewBlock(transactions, parent.Hash, parent.Height+1, parent.Bits)
+}
+
+func TestReorganizeMempool(t *testing.T) {
+	s, w, done := newTestServer(t)
+	defer done()
+
+	address := string(w.GetAddress())
+	receiver := wallet.NewWallet()
+	genesis, err := s.bc.GetBlock(s.bc.GetTip())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parent := blockchain.NewUTXOTransaction(w, string(receiver.GetAddress()), 10, 0, s.UTXOView())
+	// the side branch spends the same output, so the parent can't come back
+	conflicting := blockchain.NewUTXOTransaction(w, address, 20, 0, s.UTXOView())
+
+	main1 := newTestBlock(s.bc, &genesis, address, parent)
+	if err := s.bc.AddBlock(main1); err != nil {
+		t.Fatal(err)
+	}
+
+	child := blockchain.NewUTXOTransaction(receiver, address, 5, 0, s.UTXOView())
+	if err := s.mempool.Add(child); err != nil {
+		t.Fatal(err)
+	}
+
+	side1 := newTestBlock(s.bc, &genesis, address)
+	side2 := newTestBlock(s.bc, side1, address, conflicting)
+	for _, block := range []*blockchain.Block{side1, side2} {
+		if err := s.bc.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if s.mempool.Has(parent.ID) || s.mempool.Has(child.ID) {
+		t.Error("Mempool keeps the transactions of the replaced branch without inputs")
+	}
+	if _, err := s.work.Template(address); err != nil {
+		t.Errorf("Template() after the reorganization error = %v", err)
+	}
+}
+
+// the handlers of several peers request the announced blocks at once,
+// the race detector checks the blocks in transit are locked
+func TestBlocksInTransit(t *testing.T) {
+	s := &NodeServer{}
+
+	if hash := s.announceBlocks([][]byte{{1}, {2}}, true); !bytes.Equal(hash, []byte{1}) {
+		t.Errorf("announceBlocks() = %x, want 01", hash)
+	}
+	if hash, more := s.nextBlockInTransit(); !bytes.Equal(hash, []byte{2}) || more {
+		t.Errorf("nextBlockInTransit() = %x, %t, want 02, false", hash, more)
+	}
+	if hash, more := s.nextBlockInTransit(); hash != nil || !more {
+		t.Errorf("nextBlockInTransit() = %x, %t, want nil, true", hash, more)
+	}
+	if _, more := s.nextBlockInTransit(); more {
+		t.Error("nextBlockInTransit() asks for more blocks twice")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.announceBlocks([][]byte{{1}, {2}, {3}}, j%2 == 0)
+				s.nextBlockInTransit()
+				s.resetBlocksInTransit()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,95 @@
+package node
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+// preparedTxTimeout is how long the inputs of a prepared transaction are reserved for its signing
+const preparedTxTimeout = 10 * time.Minute
+
+// preparedTxs keeps the transactions prepared to be signed by external wallets
+// Their inputs are reserved, so the next prepared transactions don't spend them too,
+// until the transaction is signed and submitted or the reservation expires
+type preparedTxs struct {
+	mu      sync.Mutex
+	txs     map[string]*PreparedTransaction
+	expires map[string]time.Time
+}
+
+func newPreparedTxs() *preparedTxs {
+	return &preparedTxs{
+		txs:     make(map[string]*PreparedTransaction),
+		expires: make(map[string]time.Time),
+	}
+}
+
+// Add keeps the prepared transaction and reserves its inputs
+func (p *preparedTxs) Add(txid string, preparedTx *PreparedTransaction) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(time.Now())
+	p.txs[txid] = preparedTx
+	p.expires[txid] = time.Now().Add(preparedTxTimeout)
+}
+
+// Get returns the prepared transaction which is not expired yet
+func (p *preparedTxs) Get(txid string) (*PreparedTransaction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(time.Now())
+	preparedTx, ok := p.txs[txid]
+
+	return preparedTx, ok
+}
+
+// Remove forgets the prepared transaction and releases its inputs
+func (p *preparedTxs) Remove(txid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.txs, txid)
+	delete(p.expires, txid)
+}
+
+// IsSpent checks whether the output is reserved by a prepared transaction
+func (p *preparedTxs) IsSpent(txID []byte, vout int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.expire(time.Now())
+	for _, preparedTx := range p.txs {
+		for _, vin := range preparedTx.Transaction.Vin {
+			if vin.Vout == vout && bytes.Compare(vin.Txid, txID) == 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *preparedTxs) expire(now time.Time) {
+	for txid, expires := range p.expires {
+		if now.After(expires) {
+			delete(p.txs, txid)
+			delete(p.expires, txid)
+		}
+	}
+}
+
+// reservingPending are the pending transactions with the inputs
+// of the prepared transactions treated as spent
+type reservingPending struct {
+	blockchain.PendingTransactions
+	prepared *preparedTxs
+}
+
+func (r reservingPending) IsSpent(txID []byte, vout int) bool {
+	return r.PendingTransactions.IsSpent(txID, vout) || r.prepared.IsSpent(txID, vout)
+}
@@ -0,0 +1,53 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+type noPending struct{}
+
+func (noPending) Get(txID []byte) (*blockchain.Transaction, bool) { return nil, false }
+func (noPending) IsSpent(txID []byte, vout int) bool              { return false }
+func (noPending) Transactions() []*blockchain.Transaction         { return nil }
+
+func TestPreparedTxsReserve(t *testing.T) {
+	prepared := newPreparedTxs()
+	pending := reservingPending{noPending{}, prepared}
+
+	tx := &blockchain.Transaction{Vin: []blockchain.TXInput{{Txid: []byte{1}, Vout: 2}}}
+	prepared.Add("a", &PreparedTransaction{From: "from", Transaction: tx})
+
+	if !pending.IsSpent([]byte{1}, 2) {
+		t.Error("IsSpent() of a reserved output = false")
+	}
+	if pending.IsSpent([]byte{1}, 0) {
+		t.Error("IsSpent() of another output = true")
+	}
+	if got, ok := prepared.Get("a"); !ok || got.Transaction != tx {
+		t.Errorf("Get() = %v, %v, want the prepared transaction", got, ok)
+	}
+
+	// the inputs are released when the transaction is submitted
+	prepared.Remove("a")
+	if pending.IsSpent([]byte{1}, 2) {
+		t.Error("IsSpent() after Remove() = true")
+	}
+}
+
+func TestPreparedTxsExpire(t *testing.T) {
+	prepared := newPreparedTxs()
+
+	tx := &blockchain.Transaction{Vin: []blockchain.TXInput{{Txid: []byte{1}, Vout: 0}}}
+	prepared.Add("a", &PreparedTransaction{From: "from", Transaction: tx})
+	prepared.expires["a"] = time.Now().Add(-time.Second)
+
+	if prepared.IsSpent([]byte{1}, 0) {
+		t.Error("IsSpent() of an expired reservation = true")
+	}
+	if _, ok := prepared.Get("a"); ok {
+		t.Error("Get() of an expired transaction is successful")
+	}
+}
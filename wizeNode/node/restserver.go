@@ -1,9 +1,11 @@
 package node
 
 import (
+	"context"
 	"log"
 	"net"
 	"net/http"
+	"time"
 
 	//"github.com/betacraft/yaag/middleware"
 	"github.com/betacraft/yaag/yaag"
@@ -21,11 +23,15 @@ import (
 
 // RestServer provides HTTP service.
 type RestServer struct {
-	node *Node
-	addr string
-	ln   net.Listener
+	node   *Node
+	addr   string
+	ln     net.Listener
+	server *http.Server
 }
 
+// restShutdownTimeout is how long the active requests are waited for on Close
+const restShutdownTimeout = 5 * time.Second
+
 // New returns an uninitialized HTTP service.
 func NewRestServer(node *Node, addr string) *RestServer {
 	return &RestServer{
@@ -50,14 +56,28 @@ func (s *RestServer) Start() error {
 
 	// inner usage
 	router.HandleFunc("/blockchain/print", s.printBlockchain).Methods("GET")
+	router.HandleFunc("/block/height/{height}", s.getBlockByHeight).Methods("GET")
 	router.HandleFunc("/block/{hash}", s.getBlock).Methods("GET")
 
 	router.HandleFunc("/wallet/{hash}", s.getWallet).Methods("GET")
 
+	router.HandleFunc("/peers", s.getPeers).Methods("GET")
+
+	// admin: banned hosts, changed only by the local requests
+	router.HandleFunc("/bans", s.getBans).Methods("GET")
+	router.HandleFunc("/bans", localOnly(s.clearBans)).Methods("DELETE")
+	router.HandleFunc("/bans/{host}", localOnly(s.removeBan)).Methods("DELETE")
+
 	// send transaction steps: prepare/sign
+	router.HandleFunc("/fee/estimate/{blocks}", s.estimateFee).Methods("GET")
 	router.HandleFunc("/prepare", s.prepare).Methods("POST")
 	router.HandleFunc("/sign", s.sign).Methods("POST")
 
+	// mining work for the external miners: template/submit
+	router.HandleFunc("/mining/template", s.blockTemplate).Methods("GET")
+	router.HandleFunc("/mining/submit", s.submitBlock).Methods("POST")
+	router.HandleFunc("/mining/generate", s.generateBlocks).Methods("POST")
+
 	router.HandleFunc("/state", s.echoHandler).Methods("POST")
 
 	// DEPRECATED: inner usage
@@ -73,10 +93,11 @@ func (s *RestServer) Start() error {
 	//n.Use(delay.Middleware{})
 	n.UseHandler(corsHandler)
 
-	server := http.Server{
+	server := &http.Server{
 		Handler: n,
 		Addr:    s.addr,
 	}
+	s.server = server
 
 	ln, err := net.Listen("tcp", s.addr)
 	if err != nil {
@@ -87,7 +108,7 @@ func (s *RestServer) Start() error {
 	// TODO: refactoring exits from all routines
 	go func() {
 		err := server.Serve(s.ln)
-		if err != nil {
+		if err != nil && err != http.ErrServerClosed {
 			log.Printf("HTTP serve: %s", err)
 		}
 		//shutdown <- 1
@@ -96,9 +117,34 @@ func (s *RestServer) Start() error {
 	return nil
 }
 
-// Close closes the service.
+// localOnly allows the handler only for the requests from the loopback address
+// The browser requests carry an Origin and are refused too: the CORS policy allows
+// any site, so a page opened on the node host could call the handler otherwise
+func localOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		if err != nil || ip == nil || !ip.IsLoopback() || r.Header.Get("Origin") != "" {
+			sendErrorMessage(w, "Forbidden: the request is allowed only from the node host", http.StatusForbidden)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Close closes the service and waits for the active requests
 func (s *RestServer) Close() {
 	log.Println("rest closing")
-	s.ln.Close()
-	return
+	if s.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), restShutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("HTTP shutdown: %s", err)
+	}
 }
@@ -8,12 +8,14 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 
 	"wizeBlock/wizeNode/core/blockchain"
 	"wizeBlock/wizeNode/core/crypto"
-	"wizeBlock/wizeNode/core/wallet"
+	"wizeBlock/wizeNode/core/mempool"
 )
 
 // TODO: refactoring - names, funcs
@@ -25,6 +27,12 @@ type Prepare struct {
 	To     string
 	Amount int
 	PubKey string
+	// SigHashType is SIGHASH_ALL when it's not set
+	SigHashType int
+	// Fee is paid as is when it's set, otherwise it's calculated with FeeRate per 1000 bytes
+	// FeeRate is the minimum relay fee rate when it's not set
+	Fee     int
+	FeeRate int
 }
 
 type Sign struct {
@@ -34,6 +42,40 @@ type Sign struct {
 	MineNow    bool
 }
 
+// SubmitBlock is a solution of an external miner: either a whole solved Block
+// serialized in hex, or the Nonce and the Timestamp of the template with the MerkleRoot
+// The Timestamp of the template is kept when it's not set
+type SubmitBlock struct {
+	Block      string
+	MerkleRoot string
+	Timestamp  int64
+	Nonce      int
+}
+
+// Generate asks for the number of Blocks mined right away, the reward is paid
+// to the Address or to the miner address of the node
+type Generate struct {
+	Blocks  int
+	Address string
+}
+
+// BlockResponse is a Block with the hashes in hex, as the blocks are requested by them
+type BlockResponse struct {
+	*blockchain.Block
+	Hash          string
+	PrevBlockHash string
+	MerkleRoot    string
+}
+
+func newBlockResponse(block *blockchain.Block) BlockResponse {
+	return BlockResponse{
+		Block:         block,
+		Hash:          hex.EncodeToString(block.Hash),
+		PrevBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		MerkleRoot:    hex.EncodeToString(block.MerkleRoot),
+	}
+}
+
 // DEPRECATED: inner usage
 type Send struct {
 	From    string
@@ -49,17 +91,87 @@ func (s *RestServer) sayHello(w http.ResponseWriter, r *http.Request) {
 func (s *RestServer) getWallet(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	hash := vars["hash"]
+
+	if !s.node.params.ValidateAddress(hash) {
+		sendErrorMessage(w, "Wallet address is not valid", http.StatusBadRequest)
+		return
+	}
+
+	pubKeyHash := crypto.Base58Decode([]byte(hash))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	// the pending balance is the change made by the mempool transactions
+	confirmed, pending := s.node.Server.UTXOView().Balance(pubKeyHash)
+
 	resp := map[string]interface{}{
 		"success": true,
-		"credit":  s.node.blockchain.GetWalletBalance(hash),
+		"credit":  confirmed,
+		"pending": pending,
 		//"credit":  GetWalletCredits(hash, node.nodeID, node.blockchain),
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 }
 
+func (s *RestServer) getPeers(w http.ResponseWriter, r *http.Request) {
+	peers := []map[string]interface{}{}
+	for _, peer := range s.node.Client.Peers.All() {
+		peers = append(peers, map[string]interface{}{
+			"address":    peer.Addr().String(),
+			"remote":     peer.RemoteAddr().String(),
+			"inbound":    peer.Inbound(),
+			"handshake":  peer.HandshakeDone(),
+			"version":    peer.Version(),
+			"services":   peer.Services(),
+			"bestHeight": peer.BestHeight(),
+			"latencyMs":  peer.Latency().Nanoseconds() / int64(time.Millisecond),
+		})
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"peers":   peers,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (s *RestServer) getBans(w http.ResponseWriter, r *http.Request) {
+	bans := map[string]string{}
+	for host, until := range s.node.Client.Peers.Bans().Bans() {
+		bans[host] = until.Format(time.RFC3339)
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"bans":    bans,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (s *RestServer) removeBan(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	s.node.Client.Peers.Bans().Unban(vars["host"])
+
+	resp := map[string]interface{}{
+		"success": true,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (s *RestServer) clearBans(w http.ResponseWriter, r *http.Request) {
+	bans := s.node.Client.Peers.Bans()
+	for host := range bans.Bans() {
+		bans.Unban(host)
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
 // DEPRECATED: inner usage
 func (s *RestServer) deprecatedWalletsList(w http.ResponseWriter, r *http.Request) {
-	wallets, err := wallet.NewWallets(s.node.NodeID)
+	wallets, err := s.node.wallets()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -73,7 +185,7 @@ func (s *RestServer) deprecatedWalletsList(w http.ResponseWriter, r *http.Reques
 
 // DEPRECATED: inner usage
 func (s *RestServer) deprecatedWalletCreate(w http.ResponseWriter, r *http.Request) {
-	wallets, _ := wallet.NewWallets(s.node.NodeID)
+	wallets, _ := s.node.wallets()
 	address := wallets.CreateWallet()
 	wallets.SaveToFile(s.node.NodeID)
 	wallet := wallets.GetWallet(address)
@@ -115,18 +227,24 @@ func (s *RestServer) deprecatedSend(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("ERROR: Sender address is equal to Recipient address")
 		return
 	}
-	if !crypto.ValidateAddress(from) {
+
+	// the transactions are mined in the background by the miner of the node
+	if mineNow && s.node.Server.miner == nil {
+		sendErrorMessage(w, "Mining is off, the node has to be started with -miner", http.StatusBadRequest)
+		return
+	}
+	if !s.node.params.ValidateAddress(from) {
 		fmt.Println("ERROR: Sender address is not valid")
 		return
 	}
-	if !crypto.ValidateAddress(to) {
+	if !s.node.params.ValidateAddress(to) {
 		fmt.Println("ERROR: Recipient address is not valid")
 		return
 	}
 
-	UTXOSet := blockchain.UTXOSet{s.node.blockchain}
+	UTXOView := s.node.Server.UTXOView()
 
-	wallets, err := wallet.NewWallets(s.node.NodeID)
+	wallets, err := s.node.wallets()
 	if err != nil {
 		log.Panic(err)
 	}
@@ -137,7 +255,7 @@ func (s *RestServer) deprecatedSend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx := blockchain.NewUTXOTransaction(wallet, to, amount, &UTXOSet)
+	tx := blockchain.NewUTXOTransaction(wallet, to, amount, s.node.Server.mempool.MinRelayFeeRate, UTXOView)
 
 	respsuccess := true
 
@@ -145,26 +263,13 @@ func (s *RestServer) deprecatedSend(w http.ResponseWriter, r *http.Request) {
 	currentNodeAddress := s.node.NodeAddress
 	fmt.Printf("currentNodeAddress: %s\n", currentNodeAddress)
 
-	if mineNow {
-		cbTx := blockchain.NewCoinbaseTX(from, "")
-		txs := []*blockchain.Transaction{cbTx, tx}
-
-		newBlock := s.node.blockchain.MineBlock(txs)
-		if newBlock != nil {
-			UTXOSet.Update(newBlock)
-		} else {
-			respsuccess = false
-		}
-	} else {
-		// TODO: проверять остаток на балансе с учетом незамайненых транзакций,
-		// во избежание двойного использования выходов
-		knownNode0 := s.node.Network.Nodes[0]
-		s.node.Client.SendTx(knownNode0, tx)
+	if s.relayTransaction(tx) != nil {
+		respsuccess = false
 	}
 
 	//
 	if respsuccess {
-		for _, node := range s.node.Network.Nodes {
+		for _, node := range s.node.Network.GetNodes() {
 			fmt.Printf("node: %s\n", node)
 			if !node.CompareToAddress(currentNodeAddress) {
 				s.node.Client.SendVersion(node, s.node.blockchain.GetBestHeight())
@@ -178,6 +283,31 @@ func (s *RestServer) deprecatedSend(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, resp)
 }
 
+// estimateFee returns the fee rate per 1000 bytes for a transaction to be mined in the number of blocks,
+// the minimum relay fee rate is returned when there isn't enough data for the estimate
+func (s *RestServer) estimateFee(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	blocks, err := strconv.Atoi(vars["blocks"])
+	if err != nil || blocks < 1 || blocks > mempool.MaxEstimateBlocks {
+		sendErrorMessage(w, fmt.Sprintf("Number of blocks must be from 1 to %d", mempool.MaxEstimateBlocks), http.StatusBadRequest)
+		return
+	}
+
+	minFeeRate := s.node.Server.mempool.MinRelayFeeRate
+	feeRate, estimated := s.node.Server.feeEstimator.Estimate(blocks)
+	if feeRate < minFeeRate {
+		feeRate = minFeeRate
+	}
+
+	resp := map[string]interface{}{
+		"success":   true,
+		"blocks":    blocks,
+		"feeRate":   feeRate,
+		"estimated": estimated,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
 // send transaction steps: prepare/sign
 func (s *RestServer) prepare(w http.ResponseWriter, r *http.Request) {
 	var prepare Prepare
@@ -198,40 +328,60 @@ func (s *RestServer) prepare(w http.ResponseWriter, r *http.Request) {
 	to := prepare.To
 	amount := prepare.Amount
 	pubKey, _ := hex.DecodeString(prepare.PubKey)
+	hashType := blockchain.SigHashType(prepare.SigHashType)
+	if hashType == 0 {
+		hashType = blockchain.SigHashAll
+	}
+	fee := prepare.Fee
+	feeRate := prepare.FeeRate
+	if feeRate == 0 {
+		feeRate = s.node.Server.mempool.MinRelayFeeRate
+	}
 
-	fmt.Printf("from: %s, to: %s, amount: %d\n", from, to, amount)
+	fmt.Printf("from: %s, to: %s, amount: %d, fee: %d, feeRate: %d\n", from, to, amount, fee, feeRate)
 	fmt.Printf("pubkey: %s, pubkeyHex: %x\n", prepare.PubKey, pubKey)
 
-	if from == "" || to == "" || amount <= 0 {
+	if from == "" || to == "" || amount <= 0 || fee < 0 || feeRate < 0 {
 		sendErrorMessage(w, "Please check your prepare request", http.StatusBadRequest)
 		return
 	}
 
+	if !hashType.Valid() {
+		sendErrorMessage(w, "Unknown sighash type", http.StatusBadRequest)
+		return
+	}
+
 	if from == to {
 		fmt.Println("ERROR: Sender address is equal to Recipient address")
 		sendErrorMessage(w, "Sender address is equal to Recipient address", http.StatusBadRequest)
 		return
 	}
 
-	if !crypto.ValidateAddress(from) {
+	if !s.node.params.ValidateAddress(from) {
 		fmt.Println("ERROR: Sender address is not valid")
 		sendErrorMessage(w, "Sender address is not valid", http.StatusBadRequest)
 		return
 	}
-	if !crypto.ValidateAddress(to) {
+	if !s.node.params.ValidateAddress(to) {
 		fmt.Println("ERROR: Recipient address is not valid")
 		sendErrorMessage(w, "Recipient address is not valid", http.StatusBadRequest)
 		return
 	}
 
-	UTXOSet := blockchain.UTXOSet{s.node.blockchain}
+	UTXOView := s.node.Server.preparingView()
 
-	tx, txToSign, err := blockchain.PrepareUTXOTransaction(from, to, amount, pubKey, &UTXOSet)
+	tx, txToSign, err := blockchain.PrepareUTXOTransaction(from, to, amount, fee, feeRate, pubKey, hashType, UTXOView)
 	if err != nil || tx == nil || txToSign == nil {
 		sendErrorMessage(w, "Could not prepare transaction", http.StatusInternalServerError)
 		return
 	}
 
+	txFee, err := UTXOView.Fee(tx)
+	if err != nil {
+		sendErrorMessage(w, "Could not prepare transaction", http.StatusInternalServerError)
+		return
+	}
+
 	//txid := fmt.Sprintf("%x", txToSign.TxID)
 
 	fmt.Printf("tx.ID: %x\n", tx.ID)
@@ -244,14 +394,16 @@ func (s *RestServer) prepare(w http.ResponseWriter, r *http.Request) {
 		From:        from,
 		Transaction: tx,
 	}
-	s.node.preparedTxs[txid] = preparedTx
+	s.node.preparedTxs.Add(txid, preparedTx)
 
 	fmt.Printf("txid: %s, hashesToSign count: %d\n", txid, len(txToSign.HashesToSign))
 
 	resp := map[string]interface{}{
-		"success": true,
-		"txid":    txid,
-		"hashes":  txToSign.HashesToSign,
+		"success":     true,
+		"txid":        txid,
+		"sighashtype": txToSign.SigHashType,
+		"hashes":      txToSign.HashesToSign,
+		"fee":         txFee,
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 }
@@ -284,17 +436,17 @@ func (s *RestServer) sign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	UTXOSet := blockchain.UTXOSet{s.node.blockchain}
-	TxID, _ := hex.DecodeString(txid)
-
-	// get from Prepared Transactions
-	preparedTx, ok := s.node.preparedTxs[txid]
-	from := preparedTx.From
+	// the transactions are mined in the background by the miner of the node
+	if mineNow && s.node.Server.miner == nil {
+		sendErrorMessage(w, "Mining is off, the node has to be started with -miner", http.StatusBadRequest)
+		return
+	}
 
-	fmt.Printf("TxID: %x\n", TxID)
-	fmt.Printf("preparedTx: %x\n", preparedTx.Transaction.ID)
-	fmt.Printf("preparedTx From: %s\n", from)
+	UTXOView := s.node.Server.UTXOView()
+	TxID, _ := hex.DecodeString(txid)
 
+	// get from Prepared Transactions, the expired ones are forgotten
+	preparedTx, ok := s.node.preparedTxs.Get(txid)
 	if !ok {
 		fmt.Println("Could not get transaction by txid")
 		sendErrorMessage(w, "Could not get transaction by txid", http.StatusBadRequest)
@@ -302,8 +454,13 @@ func (s *RestServer) sign(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Println("GOOD: Get transaction by txid!")
 
+	from := preparedTx.From
+	fmt.Printf("TxID: %x\n", TxID)
+	fmt.Printf("preparedTx: %x\n", preparedTx.Transaction.ID)
+	fmt.Printf("preparedTx From: %s\n", from)
+
 	// check from
-	if !crypto.ValidateAddress(from) {
+	if !s.node.params.ValidateAddress(from) {
 		fmt.Println("ERROR: Sender address is not valid")
 		sendErrorMessage(w, "Sender address is not valid", http.StatusBadRequest)
 		return
@@ -314,52 +471,181 @@ func (s *RestServer) sign(w http.ResponseWriter, r *http.Request) {
 		Signatures: signatures,
 	}
 
+	tx, err := blockchain.SignUTXOTransaction(preparedTx.Transaction, txSignatures, UTXOView)
+	if err != nil {
+		fmt.Printf("Could not sign transaction: %s\n", err)
+		sendErrorMessage(w, "Could not sign transaction", http.StatusBadRequest)
+		return
+	}
+
 	respsuccess := true
 
-	tx := blockchain.SignUTXOTransaction(preparedTx.Transaction, txSignatures, &UTXOSet)
+	fmt.Printf("Relay Tx: %x from %s\n", tx.ID, s.node.NodeAddress)
+	if s.relayTransaction(tx) != nil {
+		respsuccess = false
+	}
 
-	// network update
-	currentNodeAddress := s.node.NodeAddress
-	fmt.Printf("currentNodeAddress: %s\n", currentNodeAddress)
+	// remove from Prepared-Transactions, the inputs are spent by the mempool transaction now
+	s.node.preparedTxs.Remove(txid)
+
+	resp := map[string]interface{}{
+		"success": respsuccess,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// blockTemplate returns a block to solve with the mempool transactions, the reward
+// is paid to the address parameter or to the miner address of the node
+func (s *RestServer) blockTemplate(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		address = s.node.Server.minerAddress
+	}
+
+	if !s.node.params.ValidateAddress(address) {
+		sendErrorMessage(w, "Reward address is not valid", http.StatusBadRequest)
+		return
+	}
+
+	block, err := s.node.Server.work.Template(address)
+	if err != nil {
+		fmt.Printf("Could not create block template: %s\n", err)
+		sendErrorMessage(w, "Could not create block template", http.StatusInternalServerError)
+		return
+	}
+
+	coinbase := block.Transactions[0]
+	transactions := make([]string, 0, len(block.Transactions)-1)
+	for _, tx := range block.Transactions[1:] {
+		transactions = append(transactions, hex.EncodeToString(tx.Serialize()))
+	}
+
+	resp := map[string]interface{}{
+		"success":           true,
+		"version":           block.Version,
+		"previousblockhash": hex.EncodeToString(block.PrevBlockHash),
+		"merkleroot":        hex.EncodeToString(block.MerkleRoot),
+		"timestamp":         block.Timestamp,
+		"bits":              fmt.Sprintf("%08x", block.Bits),
+		"height":            block.Height,
+		"target":            fmt.Sprintf("%064x", blockchain.CompactToBig(block.Bits)),
+		"header":            hex.EncodeToString(block.BlockHeader.Serialize()),
+		"coinbase":          hex.EncodeToString(coinbase.Serialize()),
+		"coinbasevalue":     coinbase.Vout[0].Value,
+		"transactions":      transactions,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// submitBlock validates a block solved by an external miner, adds it to the blockchain
+// and announces it to the peers
+func (s *RestServer) submitBlock(w http.ResponseWriter, r *http.Request) {
+	var submit SubmitBlock
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fmt.Printf("Failed to read the request body: %v\n", err)
+		sendErrorMessage(w, "Failed to read the request body", http.StatusBadRequest)
+		return
+	}
 
-	// mining block: now and with miner's help
-	if mineNow {
-		// TODO: minenow=true
-		cbTx := blockchain.NewCoinbaseTX(from, "")
-		txs := []*blockchain.Transaction{cbTx, tx}
-
-		newBlock := s.node.blockchain.MineBlock(txs)
-		if newBlock != nil {
-			UTXOSet.Update(newBlock)
-		} else {
-			respsuccess = false
+	if err := json.Unmarshal(body, &submit); err != nil {
+		fmt.Printf("Could not decode the request body as JSON: %v\n", err)
+		sendErrorMessage(w, "Could not decode the request body as JSON", http.StatusBadRequest)
+		return
+	}
+
+	var block *blockchain.Block
+	switch {
+	case submit.Block != "":
+		data, err := hex.DecodeString(submit.Block)
+		if err != nil {
+			sendErrorMessage(w, "Block is not valid hex", http.StatusBadRequest)
+			return
+		}
+		block, err = blockchain.DecodeBlock(data)
+		if err != nil {
+			sendErrorMessage(w, fmt.Sprintf("Block is not valid: %s", err), http.StatusBadRequest)
+			return
 		}
 
-		// network update
-		if respsuccess {
-			for _, node := range s.node.Network.Nodes {
-				fmt.Printf("node: %s\n", node)
-				if !node.CompareToAddress(currentNodeAddress) {
-					s.node.Client.SendVersion(node, s.node.blockchain.GetBestHeight())
-				}
-			}
+	case submit.MerkleRoot != "":
+		merkleRoot, err := hex.DecodeString(submit.MerkleRoot)
+		if err != nil {
+			sendErrorMessage(w, "Merkle root is not valid hex", http.StatusBadRequest)
+			return
+		}
+		block, err = s.node.Server.work.Solved(merkleRoot, submit.Timestamp, submit.Nonce)
+		if err != nil {
+			sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-	} else {
-		// TODO: minenow=false
 
-		// TODO: проверять остаток на балансе с учетом незамайненых транзакций,
-		// во избежание двойного использования выходов
+	default:
+		sendErrorMessage(w, "Please check your submit request", http.StatusBadRequest)
+		return
+	}
+
+	err = s.node.Server.SubmitBlock(block)
+	if err != nil {
+		fmt.Printf("Submitted block %x is rejected: %s\n", block.Hash, err)
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"success": true,
+		"hash":    hex.EncodeToString(block.Hash),
+		"height":  block.Height,
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// generateBlocks mines blocks by request in the networks for testing
+func (s *RestServer) generateBlocks(w http.ResponseWriter, r *http.Request) {
+	var generate Generate
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fmt.Printf("Failed to read the request body: %v\n", err)
+		sendErrorMessage(w, "Failed to read the request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := json.Unmarshal(body, &generate); err != nil {
+		fmt.Printf("Could not decode the request body as JSON: %v\n", err)
+		sendErrorMessage(w, "Could not decode the request body as JSON", http.StatusBadRequest)
+		return
+	}
+
+	address := generate.Address
+	if address == "" {
+		address = s.node.Server.minerAddress
+	}
 
-		knownNode0 := s.node.Network.Nodes[0]
-		fmt.Printf("Send Tx: %x, from %s, to: %s\n", tx.ID, knownNode0, currentNodeAddress)
-		s.node.Client.SendTx(knownNode0, tx)
+	if generate.Blocks <= 0 {
+		sendErrorMessage(w, "Please check your generate request", http.StatusBadRequest)
+		return
 	}
 
-	// remove from Prepared-Transactions
-	delete(s.node.preparedTxs, txid)
+	if !s.node.params.ValidateAddress(address) {
+		sendErrorMessage(w, "Reward address is not valid", http.StatusBadRequest)
+		return
+	}
+
+	hashes, err := s.node.Server.GenerateBlocks(address, generate.Blocks)
+	if err != nil {
+		fmt.Printf("Could not generate blocks: %s\n", err)
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	blocks := make([]string, len(hashes))
+	for i, hash := range hashes {
+		blocks[i] = hex.EncodeToString(hash)
+	}
 
 	resp := map[string]interface{}{
-		"success": respsuccess,
+		"success": true,
+		"blocks":  blocks,
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 }
@@ -368,7 +654,7 @@ func (s *RestServer) sign(w http.ResponseWriter, r *http.Request) {
 func (s *RestServer) printBlockchain(w http.ResponseWriter, r *http.Request) {
 
 	bci := s.node.blockchain.Iterator()
-	chain := make([]*blockchain.Block, 0)
+	chain := make([]BlockResponse, 0)
 
 	for {
 		block := bci.Next()
@@ -383,7 +669,7 @@ func (s *RestServer) printBlockchain(w http.ResponseWriter, r *http.Request) {
 		//	fmt.Println(tx)
 		//}
 		//fmt.Printf("\n\n")
-		chain = append(chain, block)
+		chain = append(chain, newBlockResponse(block))
 
 		if len(block.PrevBlockHash) == 0 {
 			break
@@ -399,43 +685,59 @@ func (s *RestServer) printBlockchain(w http.ResponseWriter, r *http.Request) {
 
 func (s *RestServer) getBlock(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	blockHash := vars["hash"]
-	//TODO: зачем итеретор? попробовать выбрать по ключу
-	bci := s.node.blockchain.Iterator()
-	var result *blockchain.Block
+	blockHash, err := hex.DecodeString(vars["hash"])
+	if err != nil {
+		sendErrorMessage(w, "Block hash is not valid", http.StatusBadRequest)
+		return
+	}
 
-	for {
-		block := bci.Next()
+	block, err := s.node.blockchain.GetBlock(blockHash)
+	if err != nil {
+		sendErrorMessage(w, "Block is not found", http.StatusNotFound)
+		return
+	}
 
-		bh, _ := json.Marshal(block.Hash)
-		hash := string(bh[1 : len(bh)-1])
-
-		if hash == blockHash {
-			//fmt.Printf("============ Block %x ============\n", block.Hash)
-			//fmt.Printf("Height: %d\n", block.Height)
-			//fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-			//fmt.Printf("Created at : %s\n", time.Unix(block.Timestamp, 0))
-			//pow := b.NewProofOfWork(block)
-			//fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
-			//for _, tx := range block.Transactions {
-			//	fmt.Println(tx)
-			//}
-			//fmt.Printf("\n\n")
-			result = block
-		}
+	resp := map[string]interface{}{
+		"success": true,
+		"block":   newBlockResponse(&block),
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+// getBlockByHeight returns the main chain block of the height
+func (s *RestServer) getBlockByHeight(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	height, err := strconv.Atoi(vars["height"])
+	if err != nil || height < 0 {
+		sendErrorMessage(w, "Block height is not valid", http.StatusBadRequest)
+		return
+	}
+
+	block, err := s.node.blockchain.GetBlockByHeight(height)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
 	}
 
 	resp := map[string]interface{}{
 		"success": true,
-		"credit":  result,
+		"block":   newBlockResponse(&block),
 	}
 	respondWithJSON(w, http.StatusOK, resp)
 }
 
+// relayTransaction announces a local transaction to the peers
+// An error is returned when the transaction isn't accepted into the mempool
+func (s *RestServer) relayTransaction(tx *blockchain.Transaction) error {
+	err := s.node.Server.RelayTransaction(tx, nil)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+		return err
+	}
+
+	return nil
+}
+
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
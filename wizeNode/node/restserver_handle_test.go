@@ -0,0 +1,32 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"wizeBlock/wizeNode/core/blockchain"
+)
+
+func TestBlockResponseJSON(t *testing.T) {
+	block := blockchain.NewGenesisBlock(blockchain.NewCoinbaseTX("1H5M49gaZ7urr8fHHjDbVbSgqhnDmw4m39", "test", 10), 0x207fffff)
+
+	data, err := json.Marshal(newBlockResponse(block))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["Hash"] != hex.EncodeToString(block.Hash) {
+		t.Errorf("Hash = %v, want %x", got["Hash"], block.Hash)
+	}
+	if got["MerkleRoot"] != hex.EncodeToString(block.MerkleRoot) {
+		t.Errorf("MerkleRoot = %v, want %x", got["MerkleRoot"], block.MerkleRoot)
+	}
+	if got["Height"] != float64(block.Height) || got["Transactions"] == nil {
+		t.Errorf("block fields are missing in %s", data)
+	}
+}
@@ -0,0 +1,39 @@
+package node
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLocalOnly(t *testing.T) {
+	handler := localOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		origin     string
+		want       int
+	}{
+		{"loopback", "127.0.0.1:40000", "", http.StatusOK},
+		{"loopback IPv6", "[::1]:40000", "", http.StatusOK},
+		{"remote", "192.168.1.10:40000", "", http.StatusForbidden},
+		{"browser on the node host", "127.0.0.1:40000", "http://example.com", http.StatusForbidden},
+		{"malformed address", "localhost", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("DELETE", "/bans", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != test.want {
+			t.Errorf("localOnly(%s) status = %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package node
+
+import (
+	"encoding/hex"
+	"sync"
+)
+
+// seenTxsCacheSize is the number of recently seen transactions kept to skip their duplicates
+const seenTxsCacheSize = 10000
+
+// seenCache remembers recently seen hashes, the oldest ones are forgotten when it's full
+type seenCache struct {
+	mu    sync.Mutex
+	items map[string]bool
+	order []string
+	next  int
+}
+
+func newSeenCache(capacity int) *seenCache {
+	return &seenCache{
+		items: make(map[string]bool),
+		order: make([]string, capacity),
+	}
+}
+
+// Add remembers the hash, false is returned when it's seen already
+func (c *seenCache) Add(hash []byte) bool {
+	key := hex.EncodeToString(hash)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items[key] {
+		return false
+	}
+
+	delete(c.items, c.order[c.next])
+	c.order[c.next] = key
+	c.next = (c.next + 1) % len(c.order)
+	c.items[key] = true
+
+	return true
+}
+
+// Contains checks whether the hash is seen recently
+func (c *seenCache) Contains(hash []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.items[hex.EncodeToString(hash)]
+}
+
+// Unseen returns the hashes which are not seen recently in the same order
+func (c *seenCache) Unseen(hashes [][]byte) [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	unseen := [][]byte{}
+	for _, hash := range hashes {
+		if !c.items[hex.EncodeToString(hash)] {
+			unseen = append(unseen, hash)
+		}
+	}
+
+	return unseen
+}
@@ -0,0 +1,84 @@
+package node
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeenCacheAdd(t *testing.T) {
+	c := newSeenCache(3)
+
+	if !c.Add([]byte{1}) {
+		t.Errorf("Add(1) = false, want true")
+	}
+	if c.Add([]byte{1}) {
+		t.Errorf("Add(1) again = true, want false")
+	}
+}
+
+func TestSeenCacheEviction(t *testing.T) {
+	c := newSeenCache(3)
+	for i := byte(1); i <= 3; i++ {
+		c.Add([]byte{i})
+	}
+
+	// the oldest hashes are forgotten first
+	c.Add([]byte{4})
+	c.Add([]byte{5})
+
+	tests := []struct {
+		hash byte
+		want bool
+	}{
+		{1, false},
+		{2, false},
+		{3, true},
+		{4, true},
+		{5, true},
+	}
+	for _, tt := range tests {
+		if got := c.Contains([]byte{tt.hash}); got != tt.want {
+			t.Errorf("Contains(%d) = %v, want %v", tt.hash, got, tt.want)
+		}
+	}
+}
+
+func TestSeenCacheWraparound(t *testing.T) {
+	c := newSeenCache(2)
+
+	// several full turns of the ring, each time the previous pair is evicted
+	for i := byte(0); i < 10; i++ {
+		c.Add([]byte{i})
+
+		if !c.Contains([]byte{i}) {
+			t.Errorf("Contains(%d) = false right after Add", i)
+		}
+		if i > 0 && !c.Contains([]byte{i - 1}) {
+			t.Errorf("Contains(%d) = false, want true", i-1)
+		}
+		if i > 1 && c.Contains([]byte{i - 2}) {
+			t.Errorf("Contains(%d) = true, want false", i-2)
+		}
+	}
+
+	// an evicted hash can be added again
+	if !c.Add([]byte{0}) {
+		t.Errorf("Add(0) after eviction = false, want true")
+	}
+}
+
+func TestSeenCacheUnseen(t *testing.T) {
+	c := newSeenCache(10)
+	c.Add([]byte{2})
+	c.Add([]byte{4})
+
+	items := [][]byte{{1}, {2}, {3}, {4}}
+	want := [][]byte{{1}, {3}}
+
+	if got := c.Unseen(items); !reflect.DeepEqual(got, want) {
+		t.Errorf("Unseen() = %v, want %v", got, want)
+	}
+	if got := c.Unseen([][]byte{{2}}); len(got) != 0 {
+		t.Errorf("Unseen() = %v, want none", got)
+	}
+}
@@ -0,0 +1,288 @@
+package node
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"wizeBlock/wizeNode/core/blockchain"
+	"wizeBlock/wizeNode/core/log"
+	"wizeBlock/wizeNode/core/network"
+)
+
+const (
+	// blockDownloadWindow is how far ahead of the main chain tip blocks are requested
+	blockDownloadWindow = 128
+	// maxBlocksInFlightPerPeer is the number of block requests a peer can have at once
+	maxBlocksInFlightPerPeer = 16
+	// blockRequestTimeout is how long to wait for a requested block
+	blockRequestTimeout = 30 * time.Second
+	// syncCheckInterval is how often the block requests are checked for timeouts
+	syncCheckInterval = 5 * time.Second
+)
+
+type blockRequest struct {
+	peer network.NodeAddr
+	sent time.Time
+}
+
+type receivedBlock struct {
+	block *blockchain.Block
+	peer  network.NodeAddr
+	// from is the connection the block came from, it's scored when the block is invalid
+	from *network.Peer
+}
+
+type getDataRequest struct {
+	peer network.NodeAddr
+	hash []byte
+}
+
+// SyncManager downloads bodies of the best header chain blocks from several peers at once
+// Headers are synced first, so blocks are requested only for a chain with validated proof-of-work.
+// Blocks may arrive in any order, they are kept until the previous blocks are connected.
+type SyncManager struct {
+	server *NodeServer
+
+	mu       sync.Mutex
+	peers    []network.NodeAddr
+	nextPeer int
+	queue    [][]byte
+	inFlight map[string]*blockRequest
+	received map[string]receivedBlock
+}
+
+func NewSyncManager(server *NodeServer) *SyncManager {
+	return &SyncManager{
+		server:   server,
+		inFlight: make(map[string]*blockRequest),
+		received: make(map[string]receivedBlock),
+	}
+}
+
+// Start checks block requests for timeouts until the stop channel is closed
+func (sm *SyncManager) Start(stop chan struct{}) {
+	ticker := time.NewTicker(syncCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sm.checkTimeouts()
+		}
+	}
+}
+
+// HeadersReceived adds the peer to the download sources and requests the missing blocks
+func (sm *SyncManager) HeadersReceived(peer network.NodeAddr) {
+	sm.mu.Lock()
+	sm.addPeer(peer)
+	sm.queue = sm.server.bc.BlocksToDownload()
+	requests := sm.requestBlocks()
+	sm.mu.Unlock()
+
+	sm.send(requests)
+}
+
+// BlockReceived connects the block if it was requested by the sync manager
+// False is returned for blocks which were not requested, e.g. announced new blocks
+// The peer which has sent an invalid block is scored as misbehaving
+func (sm *SyncManager) BlockReceived(block *blockchain.Block, peer network.NodeAddr, from *network.Peer) bool {
+	key := hex.EncodeToString(block.Hash)
+
+	sm.mu.Lock()
+	if _, ok := sm.inFlight[key]; !ok {
+		sm.mu.Unlock()
+		return false
+	}
+
+	delete(sm.inFlight, key)
+	sm.received[key] = receivedBlock{block, peer, from}
+	last, invalid, err := sm.connectBlocks()
+	requests := sm.requestBlocks()
+	sm.mu.Unlock()
+
+	sm.send(requests)
+
+	if invalid != nil && invalid.from != nil {
+		sm.server.Node.Client.Peers.Misbehaving(invalid.from, banScoreInvalidBlock, err.Error())
+	}
+
+	// only the new tip is announced, the peers ask for the blocks before it
+	if last != nil {
+		sm.server.relayBlock(last.block, sm.server.Node.Client.Peers.Find(last.peer))
+	}
+
+	return true
+}
+
+// IsSyncing checks whether there are blocks to download
+func (sm *SyncManager) IsSyncing() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return len(sm.queue) > 0
+}
+
+// connectBlocks adds the received blocks to the blockchain in the header chain order
+// and returns the last connected one, the block sent invalid by the peer is returned
+// with the rejection error
+func (sm *SyncManager) connectBlocks() (*receivedBlock, *receivedBlock, error) {
+	var last *receivedBlock
+
+	for len(sm.queue) > 0 {
+		key := hex.EncodeToString(sm.queue[0])
+		received, ok := sm.received[key]
+		if !ok {
+			return last, nil, nil
+		}
+		delete(sm.received, key)
+
+		err := sm.server.bc.AddBlock(received.block)
+		if err != nil {
+			log.Warn.Printf("Sync: reject block %s from %s: %s", key, received.peer, err)
+
+			var invalid *receivedBlock
+			if isInvalidBlock(err) {
+				invalid = &received
+			}
+
+			berr, ok := err.(*blockchain.BlockError)
+			if !ok || berr.Code == blockchain.ErrBlockBadMerkleRoot || berr.Code == blockchain.ErrBlockTimeTooNew {
+				// the transactions don't belong to the header or the block is valid later,
+				// the block is requested again
+				return last, invalid, err
+			}
+
+			sm.invalidate(received.block.Hash)
+			return last, invalid, err
+		}
+
+		sm.queue = sm.queue[1:]
+		last = &received
+	}
+
+	if last != nil {
+		log.Info.Printf("Sync: all blocks are downloaded, height %d", sm.server.bc.GetBestHeight())
+	}
+
+	return last, nil, nil
+}
+
+// invalidate forgets the invalid block and its descendants, their headers are removed,
+// so the blocks are not downloaded anymore
+func (sm *SyncManager) invalidate(hash []byte) {
+	removed := make(map[string]bool)
+	for _, removedHash := range sm.server.bc.InvalidateHeader(hash) {
+		key := hex.EncodeToString(removedHash)
+		removed[key] = true
+		delete(sm.inFlight, key)
+		delete(sm.received, key)
+	}
+
+	var queue [][]byte
+	for _, queued := range sm.queue {
+		if !removed[hex.EncodeToString(queued)] {
+			queue = append(queue, queued)
+		}
+	}
+	sm.queue = queue
+}
+
+// requestBlocks assigns the blocks of the download window to peers
+func (sm *SyncManager) requestBlocks() []getDataRequest {
+	var requests []getDataRequest
+
+	perPeer := make(map[string]int)
+	for _, request := range sm.inFlight {
+		perPeer[request.peer.String()]++
+	}
+
+	window := sm.queue
+	if len(window) > blockDownloadWindow {
+		window = window[:blockDownloadWindow]
+	}
+
+	for _, hash := range window {
+		key := hex.EncodeToString(hash)
+		if _, ok := sm.received[key]; ok || sm.inFlight[key] != nil {
+			continue
+		}
+
+		peer, ok := sm.pickPeer(perPeer)
+		if !ok {
+			break
+		}
+
+		sm.inFlight[key] = &blockRequest{peer, time.Now()}
+		perPeer[peer.String()]++
+		requests = append(requests, getDataRequest{peer, hash})
+	}
+
+	return requests
+}
+
+// pickPeer returns the next peer which can take one more request
+func (sm *SyncManager) pickPeer(perPeer map[string]int) (network.NodeAddr, bool) {
+	for i := 0; i < len(sm.peers); i++ {
+		peer := sm.peers[(sm.nextPeer+i)%len(sm.peers)]
+
+		if perPeer[peer.String()] < maxBlocksInFlightPerPeer {
+			sm.nextPeer = (sm.nextPeer + i + 1) % len(sm.peers)
+			return peer, true
+		}
+	}
+
+	return network.NodeAddr{}, false
+}
+
+func (sm *SyncManager) addPeer(peer network.NodeAddr) {
+	for _, p := range sm.peers {
+		if p.CompareToAddress(peer) {
+			return
+		}
+	}
+
+	sm.peers = append(sm.peers, peer)
+}
+
+func (sm *SyncManager) removePeer(peer network.NodeAddr) {
+	for i, p := range sm.peers {
+		if p.CompareToAddress(peer) {
+			sm.peers = append(sm.peers[:i], sm.peers[i+1:]...)
+			return
+		}
+	}
+}
+
+// checkTimeouts drops stalled peers and requests their blocks from others
+func (sm *SyncManager) checkTimeouts() {
+	sm.mu.Lock()
+
+	now := time.Now()
+	for key, request := range sm.inFlight {
+		if now.Sub(request.sent) < blockRequestTimeout {
+			continue
+		}
+
+		log.Warn.Printf("Sync: block %s from %s timed out", key, request.peer)
+		delete(sm.inFlight, key)
+		sm.removePeer(request.peer)
+	}
+
+	requests := sm.requestBlocks()
+	sm.mu.Unlock()
+
+	sm.send(requests)
+}
+
+func (sm *SyncManager) send(requests []getDataRequest) {
+	for _, request := range requests {
+		err := sm.server.Node.Client.SendGetData(request.peer, "block", request.hash)
+		if err != nil {
+			log.Warn.Printf("Sync: request block %x from %s: %s", request.hash, request.peer, err)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/urfave/cli"
 
+	"wizeBlock/wizeNode/core/chaincfg"
 	"wizeBlock/wizeNode/core/crypto"
 	"wizeBlock/wizeNode/core/wallet"
 )
@@ -31,6 +32,12 @@ var GlobalFlags = []cli.Flag{
 		Usage:  "Node ID (port)",
 		EnvVar: "NODE_ID",
 	},
+	cli.StringFlag{
+		Name:   "network",
+		Value:  chaincfg.MainNet.Name,
+		Usage:  "Network: mainnet, testnet or regtest",
+		EnvVar: "NODE_NETWORK",
+	},
 }
 
 var Commands = []cli.Command{
@@ -92,10 +99,31 @@ var Commands = []cli.Command{
 				Name:  "mine",
 				Usage: "Mine in the same node or only with miner nodes",
 			},
+			cli.IntFlag{
+				Name:  "fee",
+				Usage: "Fee of the transaction, it's calculated with the fee rate when it's not set",
+			},
+			cli.IntFlag{
+				Name:  "feerate",
+				Usage: "Fee rate per 1000 bytes, the minimum relay fee rate of the node when it's not set",
+			},
 		},
 		Usage:  "Send AMOUNT of coins from FROM address to TO. Mine on the same node, when -mine is set",
 		Action: CmdSend,
 	},
+	{
+		Name:    "estimatefee",
+		Aliases: []string{"fee"},
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:  "blocks",
+				Value: 6,
+				Usage: "Number of blocks",
+			},
+		},
+		Usage:  "Estimate the fee rate per 1000 bytes for a transaction to be mined in BLOCKS",
+		Action: CmdEstimateFee,
+	},
 	// blockchain explorer commands
 	//	{
 	//		Name:    "printchain",
@@ -128,13 +156,33 @@ func CommandBefore(c *cli.Context) error {
 	if c.GlobalBool("debug") {
 		//tlog.Debug.Enabled = true
 	}
+
+	// the addresses and the node port of the network
+	params, err := chaincfg.Get(c.GlobalString("network"))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	baseURL = fmt.Sprintf("http://localhost:%d", params.DefaultAPIPort)
+
 	return nil
 }
 
+// chainParams returns the network of the command, it's checked by CommandBefore
+func chainParams(c *cli.Context) *chaincfg.ChainParams {
+	params, _ := chaincfg.Get(c.GlobalString("network"))
+	return params
+}
+
+// openWallets opens the wallets of the node with the addresses of the network
+func openWallets(c *cli.Context, nodeID string) (*wallet.Wallets, error) {
+	return wallet.NewWalletsExt("wallet%s.dat", nodeID, chainParams(c).AddressVersion)
+}
+
 // wallet commands
 func CmdCreateWallet(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
-	wallets, _ := wallet.NewWalletsExt("wallet%s.dat", nodeID)
+	wallets, _ := openWallets(c, nodeID)
 	address := wallets.CreateWallet()
 	wallets.SaveToFile(nodeID)
 	walletNew := wallets.GetWallet(address)
@@ -148,7 +196,7 @@ func CmdCreateWallet(c *cli.Context) (err error) {
 func CmdListAddresses(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
 	var addresses []string = []string{}
-	wallets, err := wallet.NewWalletsExt("wallet%s.dat", nodeID)
+	wallets, err := openWallets(c, nodeID)
 	if err != nil {
 		return err
 	}
@@ -172,7 +220,7 @@ func CmdGetBalance(c *cli.Context) (err error) {
 func CmdGetWalletInfo(c *cli.Context) (err error) {
 	nodeID := c.GlobalString("nodeID")
 	address := c.String("address")
-	wallets, _ := wallet.NewWalletsExt("wallet%s.dat", nodeID)
+	wallets, _ := openWallets(c, nodeID)
 	walletInfo := wallets.GetWallet(address)
 	fmt.Println("Your address:", address)
 	fmt.Println("Private key: ", hex.EncodeToString(walletInfo.GetPrivateKey()))
@@ -187,17 +235,19 @@ func CmdSend(c *cli.Context) (err error) {
 	to := c.String("to")
 	amount := c.Int("amount")
 	mineNow := c.Bool("mine")
+	fee := c.Int("fee")
+	feeRate := c.Int("feerate")
 
-	if !crypto.ValidateAddress(from) {
+	if !chainParams(c).ValidateAddress(from) {
 		fmt.Println("ERROR: Sender address is not valid")
 		return fmt.Errorf("ERROR: Sender address is not valid")
 	}
-	if !crypto.ValidateAddress(to) {
+	if !chainParams(c).ValidateAddress(to) {
 		fmt.Println("ERROR: Recipient address is not valid")
 		return fmt.Errorf("ERROR: Recipient address is not valid")
 	}
 
-	wallets, _ := wallet.NewWalletsExt("wallet%s.dat", nodeID)
+	wallets, _ := openWallets(c, nodeID)
 	walletInfo := wallets.GetWallet(from)
 	pubKey := hex.EncodeToString(walletInfo.GetPublicKey())
 	privKeyStruct, err := crypto.GetPrivateKey(nil, walletInfo.GetPrivateKey())
@@ -207,16 +257,19 @@ func CmdSend(c *cli.Context) (err error) {
 	}
 
 	prepare := &PrepareTxRequest{
-		From:   from,
-		To:     to,
-		Amount: amount,
-		PubKey: pubKey,
+		From:    from,
+		To:      to,
+		Amount:  amount,
+		PubKey:  pubKey,
+		Fee:     fee,
+		FeeRate: feeRate,
 	}
 	prepared, err := blockApi.PostTxPrepare(prepare)
 	if err != nil {
 		fmt.Printf("Error: %s", err)
 		return err
 	}
+	fmt.Printf("Fee: %d\n", prepared.Fee)
 
 	// sign
 	signatures := make([]string, 0)
@@ -227,8 +280,7 @@ func CmdSend(c *cli.Context) (err error) {
 			fmt.Printf("Error: %s\n", err)
 			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
-		signatures = append(signatures, hex.EncodeToString(signature))
+		signatures = append(signatures, hex.EncodeToString(crypto.SerializeSignature(r, s)))
 	}
 
 	fmt.Printf("Signatures: %+v\n", signatures)
@@ -250,6 +302,21 @@ func CmdSend(c *cli.Context) (err error) {
 	return nil
 }
 
+func CmdEstimateFee(c *cli.Context) (err error) {
+	blocks := c.Int("blocks")
+	estimate, err := blockApi.GetFeeEstimate(blocks)
+	if err != nil {
+		fmt.Printf("Error: %s", err)
+		return err
+	}
+
+	if !estimate.Estimated {
+		fmt.Println("Not enough data for the estimate, the minimum relay fee rate is used")
+	}
+	fmt.Printf("Fee rate for %d blocks: %d per 1000 bytes\n", estimate.Blocks, estimate.FeeRate)
+	return nil
+}
+
 // blockchain explorer commands
 func CmdPrintChain(c *cli.Context) (err error) {
 	return nil
@@ -11,9 +11,8 @@ import (
 	"github.com/mitchellh/mapstructure"
 )
 
-const (
-	baseURL = "http://localhost:4000"
-)
+// baseURL is the REST service of the node, the port is the default API port of the selected network
+var baseURL = "http://localhost:4000"
 
 type WalletCreateRequest struct {
 }
@@ -33,19 +32,23 @@ type WalletListResponse struct {
 type WalletHashInfo struct {
 	Success bool
 	Credit  int
+	Pending int
 }
 
 type PrepareTxRequest struct {
-	From   string
-	To     string
-	Amount int
-	PubKey string
+	From    string
+	To      string
+	Amount  int
+	PubKey  string
+	Fee     int
+	FeeRate int
 }
 
 type PrepareTxResponse struct {
 	Success bool
 	Txid    string
 	Hashes  []string
+	Fee     int
 }
 
 type SignTxRequest struct {
@@ -58,6 +61,13 @@ type SignTxResponse struct {
 	Success bool
 }
 
+type FeeEstimateResponse struct {
+	Success   bool
+	Blocks    int
+	FeeRate   int
+	Estimated bool
+}
+
 type BlockApi struct {
 	Available bool
 	http      *http.Client
@@ -176,6 +186,21 @@ func (c *BlockApi) GetWalletInfo(address string) (*WalletHashInfo, error) {
 	return &result, nil
 }
 
+func (c *BlockApi) GetFeeEstimate(blocks int) (*FeeEstimateResponse, error) {
+	data, err := c.Get(fmt.Sprintf("/fee/estimate/%d", blocks))
+	if err != nil {
+		return nil, err
+	}
+
+	var result FeeEstimateResponse
+	err = mapstructure.Decode(data, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 func (c *BlockApi) PostWalletCreate(request *WalletCreateRequest) (*WalletCreateInfo, error) {
 	j, err := json.Marshal(request)
 	if err != nil {